@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/twitter/gozer/gozer"
+)
+
+// The path of the task resources in version 1 of the API. Each task is under
+// tasksPath/id.
+const tasksPath = "/api/v1/tasks"
+
+// How many tasks a page lists, unless it asks for fewer, or up to maxListLimit more.
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// taskIndex numbers the ids made up for tasks submitted without one.
+var taskIndex uint64
+
+// writeJSON responds with v as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error.Printf("Failed to marshal %+v to JSON: %+v", v, err)
+	}
+}
+
+// writeError responds with an error, as a gozer.APIError.
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, &gozer.APIError{Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// errorStatus returns the status to respond to a task store error with.
+func errorStatus(err error) int {
+	switch err.(type) {
+	case *TaskNotFoundError:
+		return http.StatusNotFound
+	case *TaskExistsError, *TaskEndedError:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allow ...string) {
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method %s not allowed; want one of %s", r.Method, strings.Join(allow, ", "))
+}
+
+// validateTask returns an error if a submitted task can not be run.
+func validateTask(task *gozer.Task) error {
+	if err := validateId(task.Id); err != nil {
+		return err
+	}
+	if task.Command == "" {
+		return fmt.Errorf("task %q has no command", task.Id)
+	}
+	if task.Cpus < 0 || task.Mem < 0 {
+		return fmt.Errorf("task %q asks for negative resources", task.Id)
+	}
+	if task.Retry != nil {
+		if err := task.Retry.Validate(); err != nil {
+			return fmt.Errorf("task %q has an invalid retry policy: %+v", task.Id, err)
+		}
+	}
+	return nil
+}
+
+// submit adds a task to the task store. A task without an id is given one that is
+// not taken.
+func submit(task *gozer.Task) error {
+	generated := task.Id == ""
+	for {
+		if generated {
+			task.Id = fmt.Sprintf("gozer-task-%d", atomic.AddUint64(&taskIndex, 1)-1)
+		}
+		err := taskstore.Add(&Task{gozerTask: task})
+		if _, exists := err.(*TaskExistsError); !exists || !generated {
+			return err
+		}
+	}
+}
+
+// tasksV1Handler serves the collection of tasks: GET lists them, and POST submits
+// one.
+func tasksV1Handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		listTasksHandler(w, r)
+	case "POST":
+		leaderOnly(createTaskHandler)(w, r)
+	default:
+		methodNotAllowed(w, r, "GET", "POST")
+	}
+}
+
+// taskV1Handler serves a task: GET returns it, and DELETE kills it.
+func taskV1Handler(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimPrefix(r.URL.Path, tasksPath+"/") == "" {
+		tasksV1Handler(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		getTaskHandler(w, r)
+	case "DELETE":
+		leaderOnly(killTaskHandler)(w, r)
+	default:
+		methodNotAllowed(w, r, "GET", "DELETE")
+	}
+}
+
+// labelsMatch reports whether labels has every one of filters, each as key=value, or
+// as key for the label to be set to anything.
+func labelsMatch(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		value, ok := labels[parts[0]]
+		if !ok || (len(parts) == 2 && value != parts[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// queryInt returns the integer query parameter name, or def if it is not given.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a number that is not negative, not %q", name, value)
+	}
+	return n, nil
+}
+
+// listTasksHandler lists the tasks we hold and those in the history, in the order
+// they were submitted. The state and label parameters, which may be repeated, keep
+// only the tasks in any of the states that have all of the labels. The offset and
+// limit parameters pick a page of them.
+func listTasksHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%+v", err)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultListLimit)
+	if err != nil || limit == 0 {
+		writeError(w, http.StatusBadRequest, "limit must be a positive number, not %q", query.Get("limit"))
+		return
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	var states []gozer.TaskState
+	for _, state := range query["state"] {
+		states = append(states, gozer.TaskState(strings.ToUpper(state)))
+	}
+
+	list := gozer.TaskList{Tasks: []gozer.Task{}}
+	for _, record := range taskstore.All() {
+		if len(states) > 0 && !inStates(record.Task.State, states) {
+			continue
+		}
+		if !labelsMatch(record.Task.Labels, query["label"]) {
+			continue
+		}
+		if list.Total >= offset && len(list.Tasks) < limit {
+			list.Tasks = append(list.Tasks, record.Task)
+		}
+		list.Total++
+	}
+	if next := offset + limit; next < list.Total {
+		list.NextOffset = next
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func getTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := strings.TrimPrefix(r.URL.Path, tasksPath+"/")
+	record, err := taskstore.Get(taskId)
+	if err != nil {
+		writeError(w, errorStatus(err), "%+v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, record.Task)
+}
+
+// createTaskHandler submits a task, and responds with it as it was added.
+func createTaskHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var task gozer.Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		writeError(w, http.StatusBadRequest, "failed to parse task: %+v", err)
+		return
+	}
+	if err := validateTask(&task); err != nil {
+		writeError(w, http.StatusBadRequest, "%+v", err)
+		return
+	}
+
+	if err := submit(&task); err != nil {
+		log.Error.Printf("Failed to add task %q: %+v", task.Id, err)
+		writeError(w, errorStatus(err), "%+v", err)
+		return
+	}
+	record, err := taskstore.Get(task.Id)
+	if err != nil {
+		writeError(w, errorStatus(err), "%+v", err)
+		return
+	}
+	w.Header().Set("Location", tasksPath+"/"+task.Id)
+	writeJSON(w, http.StatusCreated, record.Task)
+}
+
+// killTaskHandler kills a task. A task that was not launched is killed at once; a
+// launched task is killed once Mesos says so, so the request is only accepted.
+func killTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := strings.TrimPrefix(r.URL.Path, tasksPath+"/")
+	mesosId, err := taskstore.Kill(taskId)
+	if err != nil {
+		writeError(w, errorStatus(err), "%+v", err)
+		return
+	}
+
+	status := http.StatusOK
+	if mesosId != "" {
+		requestKills()
+		status = http.StatusAccepted
+	}
+	record, err := taskstore.Get(taskId)
+	if err != nil {
+		writeError(w, errorStatus(err), "%+v", err)
+		return
+	}
+	writeJSON(w, status, record.Task)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/twitter/gozer/gozer"
+)
+
+// newAPIServer serves the API from a new task store, as the leader.
+func newAPIServer(t *testing.T) (*httptest.Server, func()) {
+	savedStore, savedLeader := taskstore, leader
+	taskstore = NewTaskStore()
+	leader = &leadership{leading: 1}
+
+	server := httptest.NewServer(apiHandler())
+	return server, func() {
+		server.Close()
+		taskstore, leader = savedStore, savedLeader
+	}
+}
+
+// request sends a request with the given body, if any, and decodes the response
+// into v, if it is not nil. It returns the response, with its body closed.
+func request(t *testing.T, method, url, body string, v interface{}) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK && ct != "application/json" {
+		t.Errorf("%s %s: got %d with content type %q, want JSON", method, url, resp.StatusCode, ct)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %+v", method, url, err)
+		}
+	}
+	return resp
+}
+
+func TestCreateTask(t *testing.T) {
+	server, done := newAPIServer(t)
+	defer done()
+	url := server.URL + tasksPath
+
+	var task gozer.Task
+	resp := request(t, "POST", url, `{"id": "task-1", "command": "true", "labels": {"team": "infra"}}`, &task)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") != tasksPath+"/task-1" {
+		t.Errorf("got %d at %q, want %d at %q", resp.StatusCode, resp.Header.Get("Location"), http.StatusCreated, tasksPath+"/task-1")
+	}
+	if task.Id != "task-1" || task.State != gozer.TaskState_PENDING || task.Labels["team"] != "infra" {
+		t.Errorf("got %+v, want task-1 PENDING with its labels", task)
+	}
+	if task.Cpus != gozer.DefaultCpus || task.Mem != gozer.DefaultMem {
+		t.Errorf("got %+v, want the default resources", task)
+	}
+
+	var apiErr gozer.APIError
+	if resp := request(t, "POST", url, `{"id": "task-1", "command": "true"}`, &apiErr); resp.StatusCode != http.StatusConflict || apiErr.Status != http.StatusConflict {
+		t.Errorf("got %d %+v for a duplicate task, want %d", resp.StatusCode, apiErr, http.StatusConflict)
+	}
+
+	for _, body := range []string{
+		`{"command": `,
+		`{"id": "no-command"}`,
+		`{"id": "task-1#2", "command": "true"}`,
+		`{"id": "negative", "command": "true", "cpus": -1}`,
+		`{"id": "bad-retry", "command": "true", "retry": {"max_attempts": 0}}`,
+	} {
+		if resp := request(t, "POST", url, body, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("got %d for %s, want %d", resp.StatusCode, body, http.StatusBadRequest)
+		}
+	}
+
+	// Tasks submitted without an id are given one that is not taken.
+	addTask(t, taskstore, "gozer-task-0", 0)
+	if resp := request(t, "POST", url, `{"command": "true"}`, &task); resp.StatusCode != http.StatusCreated || task.Id == "" || task.Id == "gozer-task-0" {
+		t.Errorf("got %d %+v, want a new id", resp.StatusCode, task)
+	}
+
+	// The older API refuses duplicates too.
+	if resp := request(t, "POST", server.URL+"/api/addtask", `{"id": "task-1", "command": "true"}`, &apiErr); resp.StatusCode != http.StatusConflict {
+		t.Errorf("got %d for a duplicate task, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	var tasks []gozer.Task
+	if request(t, "GET", server.URL+"/tasks", "", &tasks); len(tasks) != 3 {
+		t.Errorf("got tasks %+v, want 3", tasks)
+	}
+}
+
+func TestListTasks(t *testing.T) {
+	server, done := newAPIServer(t)
+	defer done()
+	url := server.URL + tasksPath
+
+	for i, labels := range []string{`{"team": "infra"}`, `{"team": "web"}`, `{"team": "infra", "env": "prod"}`, `{}`} {
+		body := fmt.Sprintf(`{"id": "task-%d", "command": "true", "labels": %s}`, i, labels)
+		if resp := request(t, "POST", url, body, nil); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("got %d for %s", resp.StatusCode, body)
+		}
+	}
+	launch(t, taskstore, "task-0", gozer.TaskState_RUNNING, "")
+
+	tests := []struct {
+		query string
+		want  []string
+		total int
+		next  int
+	}{
+		{"", []string{"task-0", "task-1", "task-2", "task-3"}, 4, 0},
+		{"?state=pending", []string{"task-1", "task-2", "task-3"}, 3, 0},
+		{"?state=RUNNING&state=PENDING&label=team=infra", []string{"task-0", "task-2"}, 2, 0},
+		{"?label=team&label=env=prod", []string{"task-2"}, 1, 0},
+		{"?limit=2", []string{"task-0", "task-1"}, 4, 2},
+		{"?offset=2&limit=2", []string{"task-2", "task-3"}, 4, 0},
+		{"?offset=10", nil, 4, 0},
+	}
+	for _, test := range tests {
+		var list gozer.TaskList
+		if resp := request(t, "GET", url+test.query, "", &list); resp.StatusCode != http.StatusOK {
+			t.Errorf("%q: got %d", test.query, resp.StatusCode)
+			continue
+		}
+		var got []string
+		for _, task := range list.Tasks {
+			got = append(got, task.Id)
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") || list.Total != test.total || list.NextOffset != test.next {
+			t.Errorf("%q: got %v of %d, next %d, want %v of %d, next %d", test.query, got, list.Total, list.NextOffset, test.want, test.total, test.next)
+		}
+	}
+
+	for _, query := range []string{"?limit=0", "?offset=-1", "?limit=many"} {
+		if resp := request(t, "GET", url+query, "", nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: got %d, want %d", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestKillTask(t *testing.T) {
+	server, done := newAPIServer(t)
+	defer done()
+	url := server.URL + tasksPath
+
+	addTask(t, taskstore, "pending", 0)
+	addTask(t, taskstore, "running", 10)
+	launch(t, taskstore, "running", gozer.TaskState_RUNNING, "")
+
+	// A task that was not launched is killed at once.
+	var task gozer.Task
+	if resp := request(t, "DELETE", url+"/pending", "", &task); resp.StatusCode != http.StatusOK || task.State != gozer.TaskState_KILLED {
+		t.Errorf("got %d %+v, want %d and KILLED", resp.StatusCode, task, http.StatusOK)
+	}
+	if resp := request(t, "GET", url+"/pending", "", &task); resp.StatusCode != http.StatusOK || task.State != gozer.TaskState_KILLED {
+		t.Errorf("got %d %+v from the history, want KILLED", resp.StatusCode, task)
+	}
+	if resp := request(t, "DELETE", url+"/pending", "", nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("got %d killing a task that has ended, want %d", resp.StatusCode, http.StatusConflict)
+	}
+
+	// A launched task is killed by Mesos.
+	select {
+	case <-kills:
+	default:
+	}
+	if resp := request(t, "DELETE", url+"/running", "", &task); resp.StatusCode != http.StatusAccepted || task.State != gozer.TaskState_KILLING {
+		t.Errorf("got %d %+v, want %d and KILLING", resp.StatusCode, task, http.StatusAccepted)
+	}
+	select {
+	case <-kills:
+	default:
+		t.Error("Mesos was not asked to kill the task")
+	}
+	if err := taskstore.Update("running", gozer.TaskState_KILLED, ""); err != nil {
+		t.Fatal(err)
+	}
+	if history := taskstore.History(gozer.TaskState_KILLED); len(history) != 2 {
+		t.Errorf("got killed tasks %+v, want both", history)
+	}
+
+	var apiErr gozer.APIError
+	for _, method := range []string{"GET", "DELETE"} {
+		if resp := request(t, method, url+"/unknown", "", &apiErr); resp.StatusCode != http.StatusNotFound || apiErr.Status != http.StatusNotFound {
+			t.Errorf("%s: got %d %+v for an unknown task, want %d", method, resp.StatusCode, apiErr, http.StatusNotFound)
+		}
+	}
+	if resp := request(t, "PUT", url+"/running", "", &apiErr); resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, DELETE" {
+		t.Errorf("got %d allowing %q, want %d", resp.StatusCode, resp.Header.Get("Allow"), http.StatusMethodNotAllowed)
+	}
+}
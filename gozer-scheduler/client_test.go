@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twitter/gozer/gozer"
+	"github.com/twitter/gozer/gozer/client"
+)
+
+// TestClient runs the client against the API the scheduler serves.
+func TestClient(t *testing.T) {
+	server, done := newAPIServer(t)
+	defer done()
+	c, err := client.New(client.Config{URL: server.URL, PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	task, err := c.Submit(ctx, &gozer.Task{Id: "task-1", Command: "true", Labels: map[string]string{"team": "infra"}})
+	if err != nil || task.State != gozer.TaskState_PENDING {
+		t.Fatalf("got %+v (%v), want task-1 PENDING", task, err)
+	}
+	if _, err := c.Submit(ctx, &gozer.Task{Id: "task-1", Command: "true"}); err == nil {
+		t.Error("submitted a duplicate task")
+	} else if _, ok := err.(*client.ConflictError); !ok {
+		t.Errorf("got %T %v for a duplicate task, want a ConflictError", err, err)
+	}
+	if _, err := c.Get(ctx, "unknown"); err == nil {
+		t.Error("got an unknown task")
+	} else if _, ok := err.(*client.NotFoundError); !ok {
+		t.Errorf("got %T %v for an unknown task, want a NotFoundError", err, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Submit(ctx, &gozer.Task{Command: "true"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tasks, err := c.ListAll(ctx, client.ListOptions{Limit: 2})
+	if err != nil || len(tasks) != 4 || tasks[0].Id != "task-1" {
+		t.Errorf("got %+v (%v), want 4 tasks, task-1 first", tasks, err)
+	}
+	list, err := c.List(ctx, client.ListOptions{Labels: map[string]string{"team": "infra"}})
+	if err != nil || len(list.Tasks) != 1 || list.Tasks[0].Id != "task-1" {
+		t.Errorf("got %+v (%v), want task-1", list, err)
+	}
+
+	// Watching a task follows it until it ends.
+	launch(t, taskstore, "task-1", gozer.TaskState_RUNNING, "")
+	states := make(chan gozer.TaskState, 10)
+	watched := make(chan error, 1)
+	go func() {
+		watched <- c.Watch(ctx, "task-1", func(task *gozer.Task) { states <- task.State })
+	}()
+	if state := <-states; state != gozer.TaskState_RUNNING {
+		t.Errorf("watch started at %s, want RUNNING", state)
+	}
+	if task, err := c.Kill(ctx, "task-1"); err != nil || task.State != gozer.TaskState_KILLING {
+		t.Errorf("got %+v (%v), want task-1 KILLING", task, err)
+	}
+	if err := taskstore.Update("task-1", gozer.TaskState_KILLED, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-watched; err != nil {
+		t.Fatal(err)
+	}
+	close(states)
+	var last gozer.TaskState
+	for state := range states {
+		last = state
+	}
+	if last != gozer.TaskState_KILLED {
+		t.Errorf("watch ended at %s, want KILLED", last)
+	}
+
+	history, err := c.History(ctx, gozer.TaskState_KILLED)
+	if err != nil || len(history) != 1 || history[0].Id != "task-1" || history[0].SlaveId != "slave-1" {
+		t.Errorf("got history %+v (%v), want task-1 on slave-1", history, err)
+	}
+}
+
+// TestClientList runs the client's finding, listing and killing of tasks against the
+// API the scheduler serves.
+func TestClientList(t *testing.T) {
+	server, done := newAPIServer(t)
+	defer done()
+	c, err := client.New(client.Config{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		task := &gozer.Task{Id: fmt.Sprintf("task-%d", i), Command: "true"}
+		if i%2 == 0 {
+			task.Labels = map[string]string{"team": "infra"}
+		}
+		if _, err := c.Submit(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	launch(t, taskstore, "task-0", gozer.TaskState_RUNNING, "")
+
+	// A task killed before it was launched ends at once, and is found in the history.
+	if task, err := c.Kill(ctx, "task-1"); err != nil || task.State != gozer.TaskState_KILLED {
+		t.Errorf("got %+v (%v), want task-1 KILLED", task, err)
+	}
+	if task, err := c.Get(ctx, "task-1"); err != nil || task.State != gozer.TaskState_KILLED {
+		t.Errorf("got %+v (%v), want task-1 KILLED", task, err)
+	}
+	if _, err := c.Kill(ctx, "task-1"); err == nil {
+		t.Error("killed a task that has ended")
+	} else if _, ok := err.(*client.ConflictError); !ok {
+		t.Errorf("got %T %v for killing an ended task, want a ConflictError", err, err)
+	}
+	if task, err := c.Kill(ctx, "task-0"); err != nil || task.State != gozer.TaskState_KILLING {
+		t.Errorf("got %+v (%v), want task-0 KILLING", task, err)
+	}
+
+	ids := func(list *gozer.TaskList) []string {
+		var ids []string
+		for _, task := range list.Tasks {
+			ids = append(ids, task.Id)
+		}
+		return ids
+	}
+	for _, test := range []struct {
+		options    client.ListOptions
+		want       []string
+		total      int
+		nextOffset int
+	}{
+		{client.ListOptions{}, []string{"task-0", "task-1", "task-2", "task-3", "task-4"}, 5, 0},
+		{client.ListOptions{States: []gozer.TaskState{gozer.TaskState_PENDING}}, []string{"task-2", "task-3", "task-4"}, 3, 0},
+		{client.ListOptions{States: []gozer.TaskState{gozer.TaskState_KILLING, gozer.TaskState_KILLED}}, []string{"task-0", "task-1"}, 2, 0},
+		{client.ListOptions{Labels: map[string]string{"team": "infra"}}, []string{"task-0", "task-2", "task-4"}, 3, 0},
+		{client.ListOptions{Labels: map[string]string{"team": ""}}, []string{"task-0", "task-2", "task-4"}, 3, 0},
+		{client.ListOptions{Labels: map[string]string{"team": "web"}}, nil, 0, 0},
+		{client.ListOptions{
+			States: []gozer.TaskState{gozer.TaskState_PENDING},
+			Labels: map[string]string{"team": "infra"},
+		}, []string{"task-2", "task-4"}, 2, 0},
+		{client.ListOptions{Limit: 2}, []string{"task-0", "task-1"}, 5, 2},
+		{client.ListOptions{Offset: 2, Limit: 2}, []string{"task-2", "task-3"}, 5, 4},
+		{client.ListOptions{Offset: 4, Limit: 2}, []string{"task-4"}, 5, 0},
+		{client.ListOptions{Offset: 10}, nil, 5, 0},
+	} {
+		list, err := c.List(ctx, test.options)
+		if err != nil {
+			t.Errorf("List(%+v): %v", test.options, err)
+			continue
+		}
+		if got := ids(list); !reflect.DeepEqual(got, test.want) || list.Total != test.total || list.NextOffset != test.nextOffset {
+			t.Errorf("List(%+v): got %v of %d, next at %d, want %v of %d, next at %d",
+				test.options, got, list.Total, list.NextOffset, test.want, test.total, test.nextOffset)
+		}
+	}
+}
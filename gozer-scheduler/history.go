@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"time"
+
+	"github.com/twitter/gozer/gozer"
+)
+
+var (
+	historySize = flag.Int("historySize", 1000, "How many completed tasks to keep in the history (0 keeps none)")
+	historyAge  = flag.Duration("historyAge", 7*24*time.Hour, "How long to keep completed tasks in the history (0 keeps them until -historySize is reached)")
+)
+
+// taskHistory holds what is stored of the tasks that have ended for good, in the
+// order they ended, up to a number of tasks and an age.
+type taskHistory struct {
+	size int
+	age  time.Duration
+
+	records []*TaskRecord
+}
+
+// add appends a task that has just ended. Its record must not be changed after.
+func (h *taskHistory) add(record *TaskRecord) {
+	h.records = append(h.records, record)
+}
+
+// load replaces the history with records, which may be in any order.
+func (h *taskHistory) load(records []*TaskRecord) {
+	h.records = records
+	sort.SliceStable(h.records, func(i, j int) bool {
+		return h.records[i].Completed.Before(*h.records[j].Completed)
+	})
+}
+
+// remove drops the task with the given id, and reports whether there was one.
+func (h *taskHistory) remove(taskId string) bool {
+	for i, record := range h.records {
+		if record.Task.Id == taskId {
+			h.records = append(h.records[:i], h.records[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// expired reports whether a task that ended at completed is too old to keep at now.
+func (h *taskHistory) expired(completed, now time.Time) bool {
+	return h.age > 0 && now.Sub(completed) > h.age
+}
+
+// trim drops the tasks beyond the limits at now, and returns them.
+func (h *taskHistory) trim(now time.Time) []*TaskRecord {
+	n := 0
+	for n < len(h.records) && h.expired(*h.records[n].Completed, now) {
+		n++
+	}
+	if over := len(h.records) - n - h.size; over > 0 {
+		n += over
+	}
+
+	dropped := h.records[:n:n]
+	h.records = h.records[n:]
+	return dropped
+}
+
+// list returns the tasks not too old to keep at now, most recent first.
+func (h *taskHistory) list(now time.Time) []*TaskRecord {
+	var records []*TaskRecord
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if h.expired(*h.records[i].Completed, now) {
+			break
+		}
+		records = append(records, h.records[i])
+	}
+	return records
+}
+
+// get returns the task with the given id, if it is not too old to keep at now.
+func (h *taskHistory) get(taskId string, now time.Time) *TaskRecord {
+	for _, record := range h.records {
+		if record.Task.Id == taskId && !h.expired(*record.Completed, now) {
+			return record
+		}
+	}
+	return nil
+}
+
+// completedTask returns what the API shows of a task in the history.
+func completedTask(record *TaskRecord) gozer.CompletedTask {
+	task := gozer.CompletedTask{
+		Task:    record.Task,
+		SlaveId: record.SlaveId,
+		Ended:   *record.Completed,
+	}
+	if attempts := record.Task.Attempts; len(attempts) > 0 {
+		last := attempts[len(attempts)-1]
+		task.Started = &attempts[0].Launched
+		task.Message = last.Message
+		// A task lost on its way to being killed is no longer on a slave.
+		if task.SlaveId == "" {
+			task.SlaveId = last.SlaveId
+		}
+	}
+	return task
+}
@@ -4,26 +4,34 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/twitter/gozer/gozer"
 )
 
-var taskIndex = 0
-
 func startHTTP() {
-	http.HandleFunc("/tasks", tasksHandler)
-	http.HandleFunc("/api/addtask", addTaskHandler)
 	log.Info.Printf("API listening on port %d", *port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), apiHandler()); err != nil {
 		log.Error.Fatalf("Failed to start listening on port %d", *port)
 	}
 }
 
+// apiHandler serves every version of the API.
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tasks", tasksHandler)
+	mux.HandleFunc("/history", historyHandler)
+	mux.HandleFunc("/api/addtask", leaderOnly(addTaskHandler))
+	mux.HandleFunc(tasksPath, tasksV1Handler)
+	mux.HandleFunc(tasksPath+"/", taskV1Handler)
+	return mux
+}
+
 func addTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		w.Header().Add("Allow", "POST")
-		w.WriteHeader(http.StatusMethodNotAllowed)
 		log.Error.Printf("Received addtask request with unexpected method. want %q, got %q: %+v", "POST", r.Method, r)
+		methodNotAllowed(w, r, "POST")
+		return
 	}
 	defer r.Body.Close()
 
@@ -31,29 +39,41 @@ func addTaskHandler(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&task)
 	if err != nil {
 		log.Error.Printf("Failed to parse JSON body from addtask request %+v: %+v", r, err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "failed to parse task: %+v", err)
 		return
 	}
-
-	if len(task.Id) == 0 {
-		task.Id = fmt.Sprintf("gozer-task-%d", taskIndex)
-		taskIndex += 1
+	if err := validateTask(&task); err != nil {
+		writeError(w, http.StatusBadRequest, "%+v", err)
+		return
 	}
 
-	taskstore.Add(&Task{gozerTask: &task})
+	if err := submit(&task); err != nil {
+		log.Error.Printf("Failed to add task %q: %+v", task.Id, err)
+		writeError(w, errorStatus(err), "%+v", err)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// tasksHandler serves the tasks we hold, in the order they were submitted.
 func tasksHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	tasks := make([]gozer.Task, len(taskstore.tasks))
-	for _, task := range taskstore.tasks {
-		tasks = append(tasks, *task.gozerTask)
+	tasks := []gozer.Task{}
+	for _, record := range taskstore.All() {
+		if record.Completed == nil {
+			tasks = append(tasks, record.Task)
+		}
 	}
-	err := json.NewEncoder(w).Encode(tasks)
-	if err != nil {
-		log.Error.Printf("Failed to marshal %+v to JSON: %+v", taskstore.tasks, err)
-		w.WriteHeader(http.StatusInternalServerError)
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// historyHandler serves the tasks that have ended for good, most recent first. Any
+// state parameters keep only the tasks that ended in those states.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	var states []gozer.TaskState
+	for _, state := range r.URL.Query()["state"] {
+		states = append(states, gozer.TaskState(strings.ToUpper(state)))
 	}
+
+	writeJSON(w, http.StatusOK, taskstore.History(states...))
 }
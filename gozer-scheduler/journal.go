@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// The files a fileStore keeps in its directory.
+const (
+	journalFile  = "journal.log"
+	snapshotFile = "snapshot.json"
+)
+
+// How many entries the journal holds before it is compacted into a snapshot.
+const journalCompactAfter = 10000
+
+type journalOp string
+
+const (
+	journalPutTask     journalOp = "put_task"
+	journalDeleteTask  journalOp = "delete_task"
+	journalFrameworkId journalOp = "framework_id"
+	journalSnapshot    journalOp = "snapshot"
+)
+
+// A journalEntry is one line of the journal. Every entry holds the whole of what it
+// changes, so replaying an entry more than once is harmless.
+type journalEntry struct {
+	Op          journalOp   `json:"op"`
+	Task        *TaskRecord `json:"task,omitempty"`
+	TaskId      string      `json:"task_id,omitempty"`
+	FrameworkId string      `json:"framework_id,omitempty"`
+	Snapshot    *snapshot   `json:"snapshot,omitempty"`
+}
+
+type snapshot struct {
+	FrameworkId string        `json:"framework_id,omitempty"`
+	Tasks       []*TaskRecord `json:"tasks"`
+}
+
+// fileStore is a Store that appends every change to a journal file, and syncs it
+// before returning. When the store is opened, the journal is replayed on top of the
+// last snapshot. Once the journal grows long, it is compacted into a new snapshot.
+type fileStore struct {
+	sync.Mutex
+	dir          string
+	compactAfter int
+
+	journal *os.File
+	// The length of the journal, and the number of entries in it.
+	offset  int64
+	entries int
+
+	// The state the snapshot and journal add up to, which snapshots are written from.
+	journalState
+}
+
+// journalState is what a sequence of journal entries adds up to.
+type journalState struct {
+	frameworkId string
+	tasks       map[string]*TaskRecord
+}
+
+func newJournalState() journalState {
+	return journalState{tasks: make(map[string]*TaskRecord)}
+}
+
+// openFileStore opens the store kept in dir, creating it if it does not exist.
+func openFileStore(dir string, compactAfter int) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory %s: %+v", dir, err)
+	}
+
+	s := &fileStore{
+		dir:          dir,
+		compactAfter: compactAfter,
+		journalState: newJournalState(),
+	}
+	if err := s.readSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	if s.entries >= s.compactAfter {
+		if err := s.compact(); err != nil {
+			s.journal.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// readFileStore returns what is stored in dir without changing it, as the scheduler
+// that opened the store may be writing to it. An incomplete or unreadable last entry
+// of the journal may still be being written, and is skipped.
+func readFileStore(dir string) (string, []*TaskRecord, error) {
+	s := &fileStore{dir: dir, journalState: newJournalState()}
+	if err := s.readSnapshot(); err != nil {
+		return "", nil, err
+	}
+
+	path := filepath.Join(dir, journalFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("failed to read journal %s: %+v", path, err)
+	}
+	lines := bytes.SplitAfter(data, []byte{'\n'})
+	last := len(lines) - 1
+	if len(lines[last]) == 0 {
+		last--
+	}
+
+	var offset int
+	for i, line := range lines[:last+1] {
+		var entry journalEntry
+		if line[len(line)-1] != '\n' {
+			err = io.ErrUnexpectedEOF
+		} else {
+			err = json.Unmarshal(line, &entry)
+		}
+		if err == nil {
+			err = s.apply(&entry)
+		}
+		if err != nil {
+			if i == last {
+				break
+			}
+			return "", nil, fmt.Errorf("corrupt journal %s at offset %d: %+v", path, offset, err)
+		}
+		offset += len(line)
+	}
+
+	frameworkId, tasks := s.load()
+	return frameworkId, tasks, nil
+}
+
+func (s *fileStore) readSnapshot() error {
+	path := filepath.Join(s.dir, snapshotFile)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot %s: %+v", path, err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("corrupt snapshot %s: %+v", path, err)
+	}
+	return s.apply(&journalEntry{Op: journalSnapshot, Snapshot: &snap})
+}
+
+// replay applies the journal's entries, and leaves the journal open for appending.
+// An incomplete or unreadable last entry was being written when we stopped, and so
+// was never reported stored; it is dropped. Any other unreadable entry is an error.
+func (s *fileStore) replay() error {
+	path := filepath.Join(s.dir, journalFile)
+	journal, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal %s: %+v", path, err)
+	}
+
+	reader := bufio.NewReader(journal)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			journal.Close()
+			return fmt.Errorf("failed to read journal %s: %+v", path, err)
+		}
+
+		var entry journalEntry
+		if err == nil {
+			err = json.Unmarshal(line, &entry)
+		} else {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			if _, peekErr := reader.Peek(1); peekErr != io.EOF {
+				journal.Close()
+				return fmt.Errorf("corrupt journal %s at offset %d: %+v", path, s.offset, err)
+			}
+			log.Warn.Printf("Dropping incomplete last entry of journal %s: %q", path, line)
+			if err := journal.Truncate(s.offset); err != nil {
+				journal.Close()
+				return fmt.Errorf("failed to truncate journal %s: %+v", path, err)
+			}
+			break
+		}
+		if err := s.apply(&entry); err != nil {
+			journal.Close()
+			return fmt.Errorf("corrupt journal %s at offset %d: %+v", path, s.offset, err)
+		}
+
+		s.offset += int64(len(line))
+		s.entries++
+	}
+
+	if _, err := journal.Seek(s.offset, io.SeekStart); err != nil {
+		journal.Close()
+		return fmt.Errorf("failed to seek in journal %s: %+v", path, err)
+	}
+	s.journal = journal
+	return nil
+}
+
+func (s *journalState) apply(entry *journalEntry) error {
+	switch entry.Op {
+	case journalPutTask:
+		if entry.Task == nil {
+			return fmt.Errorf("%s entry without a task", entry.Op)
+		}
+		s.tasks[entry.Task.Task.Id] = entry.Task
+	case journalDeleteTask:
+		delete(s.tasks, entry.TaskId)
+	case journalFrameworkId:
+		s.frameworkId = entry.FrameworkId
+	case journalSnapshot:
+		if entry.Snapshot == nil {
+			return fmt.Errorf("%s entry without a snapshot", entry.Op)
+		}
+		*s = newJournalState()
+		s.frameworkId = entry.Snapshot.FrameworkId
+		for _, task := range entry.Snapshot.Tasks {
+			s.tasks[task.Task.Id] = task
+		}
+	default:
+		return fmt.Errorf("unknown journal entry %q", entry.Op)
+	}
+	return nil
+}
+
+// append writes an entry to the journal and syncs it, then applies it.
+func (s *fileStore) append(entry *journalEntry) error {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry %+v: %+v", entry, err)
+	}
+	data = append(data, '\n')
+
+	if _, err := s.journal.Write(data); err != nil {
+		// Do not leave part of the entry for the next one to be appended to.
+		s.journal.Truncate(s.offset)
+		s.journal.Seek(s.offset, io.SeekStart)
+		return fmt.Errorf("failed to write to journal: %+v", err)
+	}
+	s.offset += int64(len(data))
+	s.entries++
+	if err := s.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %+v", err)
+	}
+
+	if err := s.apply(entry); err != nil {
+		return err
+	}
+
+	if s.entries >= s.compactAfter {
+		if err := s.compact(); err != nil {
+			// The entry is safe in the journal, which is left to grow until the
+			// next attempt.
+			log.Warn.Printf("Failed to compact journal: %+v", err)
+		}
+	}
+	return nil
+}
+
+// compact writes a new snapshot and empties the journal. The new snapshot replaces
+// the old one atomically, and should we stop before the journal is emptied,
+// replaying it on top of the new snapshot is harmless.
+func (s *fileStore) compact() error {
+	data, err := json.Marshal(snapshot{FrameworkId: s.frameworkId, Tasks: s.sorted()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %+v", err)
+	}
+
+	path := filepath.Join(s.dir, snapshotFile)
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to replace snapshot %s: %+v", path, err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	if err := s.journal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal: %+v", err)
+	}
+	if _, err := s.journal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in journal: %+v", err)
+	}
+	s.offset, s.entries = 0, 0
+
+	log.Info.Printf("Compacted journal into snapshot of %d tasks", len(s.tasks))
+	return nil
+}
+
+// sorted returns the stored tasks in the order they were submitted.
+func (s *journalState) sorted() []*TaskRecord {
+	tasks := make([]*TaskRecord, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Sequence < tasks[j].Sequence })
+	return tasks
+}
+
+// load returns copies of the stored tasks, in the order they were submitted.
+func (s *journalState) load() (string, []*TaskRecord) {
+	tasks := s.sorted()
+	for i, task := range tasks {
+		record := *task
+		tasks[i] = &record
+	}
+	return s.frameworkId, tasks
+}
+
+func (s *fileStore) Load() (string, []*TaskRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	frameworkId, tasks := s.load()
+	return frameworkId, tasks, nil
+}
+
+func (s *fileStore) PutTask(task *TaskRecord) error {
+	record := *task
+	return s.append(&journalEntry{Op: journalPutTask, Task: &record})
+}
+
+func (s *fileStore) DeleteTask(taskId string) error {
+	return s.append(&journalEntry{Op: journalDeleteTask, TaskId: taskId})
+}
+
+func (s *fileStore) PutFrameworkId(frameworkId string) error {
+	return s.append(&journalEntry{Op: journalFrameworkId, FrameworkId: frameworkId})
+}
+
+func (s *fileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.journal.Close()
+}
+
+// writeFileSync writes a file and syncs it to disk.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %+v", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %+v", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync %s: %+v", path, err)
+	}
+	return f.Close()
+}
+
+// syncDir syncs a directory, so that files renamed into it stay there.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %+v", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %+v", dir, err)
+	}
+	return nil
+}
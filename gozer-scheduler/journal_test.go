@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/twitter/gozer/gozer"
+)
+
+func openTestStore(t *testing.T, dir string, compactAfter int) *fileStore {
+	store, err := openFileStore(dir, compactAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func taskRecord(id string, state gozer.TaskState, sequence uint64) *TaskRecord {
+	return &TaskRecord{Task: gozer.Task{Id: id, Command: "true", State: state}, Sequence: sequence}
+}
+
+// checkLoad checks that store holds the given framework id and tasks, in order.
+func checkLoad(t *testing.T, store Store, wantId string, want ...*TaskRecord) {
+	id, tasks, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != wantId {
+		t.Errorf("got framework id %q, want %q", id, wantId)
+	}
+	if len(tasks) != len(want) {
+		t.Fatalf("got %d tasks, want %d", len(tasks), len(want))
+	}
+	for i := range want {
+		if tasks[i].Task.Id != want[i].Task.Id || tasks[i].Task.State != want[i].Task.State || tasks[i].SlaveId != want[i].SlaveId {
+			t.Errorf("task %d: got %+v, want %+v", i, tasks[i], want[i])
+		}
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := openTestStore(t, dir, 100)
+	running := taskRecord("task-1", gozer.TaskState_RUNNING, 1)
+	running.SlaveId = "slave-1"
+	for _, task := range []*TaskRecord{
+		taskRecord("task-1", gozer.TaskState_PENDING, 1),
+		taskRecord("task-2", gozer.TaskState_PENDING, 2),
+		taskRecord("task-0", gozer.TaskState_PENDING, 0),
+		running,
+	} {
+		if err := store.PutTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.DeleteTask("task-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutFrameworkId("framework-1"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = openTestStore(t, dir, 100)
+	defer store.Close()
+	checkLoad(t, store, "framework-1", taskRecord("task-0", gozer.TaskState_PENDING, 0), running)
+}
+
+func TestJournalCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := openTestStore(t, dir, 3)
+	store.PutFrameworkId("framework-1")
+	store.PutTask(taskRecord("task-0", gozer.TaskState_PENDING, 0))
+	store.PutTask(taskRecord("task-1", gozer.TaskState_PENDING, 1))
+	store.PutTask(taskRecord("task-0", gozer.TaskState_ASSIGNED, 0))
+
+	// The first three entries are in the snapshot, and the last in the journal.
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Fatal(err)
+	}
+	if store.entries != 1 {
+		t.Errorf("got %d journal entries after compaction, want 1", store.entries)
+	}
+	store.Close()
+
+	store = openTestStore(t, dir, 3)
+	defer store.Close()
+	checkLoad(t, store, "framework-1",
+		taskRecord("task-0", gozer.TaskState_ASSIGNED, 0),
+		taskRecord("task-1", gozer.TaskState_PENDING, 1))
+}
+
+func TestJournalTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := openTestStore(t, dir, 100)
+	store.PutTask(taskRecord("task-0", gozer.TaskState_PENDING, 0))
+	store.Close()
+
+	// We stopped part way through writing an entry.
+	path := filepath.Join(dir, journalFile)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`{"op":"put_task","task":{"task":{"id":"task-1"`))
+	f.Close()
+
+	store = openTestStore(t, dir, 100)
+	checkLoad(t, store, "", taskRecord("task-0", gozer.TaskState_PENDING, 0))
+
+	// The torn entry is gone, and does not get in the way of the next one.
+	store.PutTask(taskRecord("task-1", gozer.TaskState_PENDING, 1))
+	store.Close()
+	store = openTestStore(t, dir, 100)
+	defer store.Close()
+	checkLoad(t, store, "",
+		taskRecord("task-0", gozer.TaskState_PENDING, 0),
+		taskRecord("task-1", gozer.TaskState_PENDING, 1))
+}
+
+func TestJournalCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	journal := `{"op":"put_task","task":{"task":{"id":"task-0"}}}
+not json
+{"op":"delete_task","task_id":"task-0"}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, journalFile), []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openFileStore(dir, 100); err == nil {
+		t.Error("opened a store with a corrupt journal entry")
+	}
+}
+
+func TestReadFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := openTestStore(t, dir, 3)
+	defer store.Close()
+	store.PutFrameworkId("framework-1")
+	store.PutTask(taskRecord("task-0", gozer.TaskState_PENDING, 0))
+	store.PutTask(taskRecord("task-1", gozer.TaskState_PENDING, 1))
+	store.PutTask(taskRecord("task-0", gozer.TaskState_ASSIGNED, 0))
+
+	// The store is read as it is written: from the snapshot and the journal, and
+	// skipping an entry still being written.
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`{"op":"delete_task","task_id":"task-1"`))
+	f.Close()
+
+	id, tasks, err := readFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "framework-1" || len(tasks) != 2 || tasks[0].Task.State != gozer.TaskState_ASSIGNED || tasks[1].Task.Id != "task-1" {
+		t.Errorf("got %q and %+v", id, tasks)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+
+	"github.com/twitter/gozer/gozer"
+	"github.com/twitter/gozer/mesos"
+)
+
+// kills is signalled when tasks have been marked KILLING, for the main loop to ask
+// Mesos to kill them. Signals that arrive while one is waiting are merged into it.
+var kills = make(chan struct{}, 1)
+
+func requestKills() {
+	select {
+	case kills <- struct{}{}:
+	default:
+	}
+}
+
+// killTasks asks Mesos to kill every task marked KILLING. Asking again for a task
+// that is already being killed is harmless, and covers requests that were lost.
+func killTasks(driver *mesos.Driver) {
+	for _, record := range taskstore.InState(gozer.TaskState_KILLING) {
+		taskId := mesosId(&record.Task)
+		log.Info.Printf("Killing task %q", taskId)
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		if err := driver.KillTask(ctx, taskId); err != nil {
+			log.Error.Printf("Failed to kill task %q: %+v", taskId, err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// LeaderElector elects one of several schedulers to lead: to register the framework
+// and manage the tasks. The others stand by to take over.
+type LeaderElector interface {
+	// Campaign returns once we are the leader, or with an error if ctx is done first.
+	// The channel it returns is closed if we stop being the leader.
+	Campaign(ctx context.Context) (<-chan struct{}, error)
+	// Leader returns the address of the leader's API, or "" if it is not known.
+	Leader() (string, error)
+	// Resign stops us being the leader, if we are.
+	Resign() error
+}
+
+// leaderElectors makes the LeaderElector for each backend -leaderElection can name,
+// from the argument after the backend's name and the address of our API.
+var leaderElectors = map[string]func(argument, address string) (LeaderElector, error){
+	"lockfile": func(path, address string) (LeaderElector, error) {
+		if path == "" {
+			return nil, fmt.Errorf("lockfile leader election needs the path of the lock file")
+		}
+		return newLockFileElector(path, address, lockFilePoll), nil
+	},
+}
+
+// newLeaderElector returns the LeaderElector described by spec, as backend:argument,
+// or nil if spec is empty.
+func newLeaderElector(spec, address string) (LeaderElector, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	newElector, ok := leaderElectors[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown leader election backend %q", parts[0])
+	}
+	argument := ""
+	if len(parts) == 2 {
+		argument = parts[1]
+	}
+	return newElector(argument, address)
+}
+
+// How often a standby tries to take the lock file.
+const lockFilePoll = time.Second
+
+// lockFileElector elects whichever scheduler holds an exclusive lock on a file, which
+// it holds until it resigns or exits. The leader writes the address of its API into
+// the file for the others to find.
+type lockFileElector struct {
+	sync.Mutex
+	path    string
+	address string
+	poll    time.Duration
+
+	// The locked file and the channel closed on resigning, while we are the leader.
+	file *os.File
+	lost chan struct{}
+}
+
+func newLockFileElector(path, address string, poll time.Duration) *lockFileElector {
+	return &lockFileElector{path: path, address: address, poll: poll}
+}
+
+func (e *lockFileElector) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	for {
+		lost, err := e.tryLock()
+		if err != nil || lost != nil {
+			return lost, err
+		}
+
+		select {
+		case <-time.After(e.poll):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryLock takes the lock if nobody holds it. It returns nil if somebody does.
+func (e *lockFileElector) tryLock() (chan struct{}, error) {
+	e.Lock()
+	defer e.Unlock()
+	if e.file != nil {
+		return e.lost, nil
+	}
+
+	file, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %+v", e.path, err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to lock %s: %+v", e.path, err)
+	}
+
+	if err := writeLockFile(file, e.address); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write lock file %s: %+v", e.path, err)
+	}
+	e.file, e.lost = file, make(chan struct{})
+	return e.lost, nil
+}
+
+func writeLockFile(file *os.File, address string) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt([]byte(address), 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (e *lockFileElector) Leader() (string, error) {
+	data, err := ioutil.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read lock file %s: %+v", e.path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Resign leaves the file behind, as removing it could let two schedulers lock
+// different files at the same path.
+func (e *lockFileElector) Resign() error {
+	e.Lock()
+	defer e.Unlock()
+	if e.file == nil {
+		return nil
+	}
+
+	// Whoever takes over writes its own address.
+	e.file.Truncate(0)
+	err := e.file.Close()
+	close(e.lost)
+	e.file, e.lost = nil, nil
+	return err
+}
+
+// leadership is whether we lead, and how to reach the leader if we do not.
+type leadership struct {
+	elector LeaderElector
+	// The address of our API.
+	address string
+	leading int32
+}
+
+// leader is our leadership. Without an elector, we lead alone once we have recovered.
+var leader = &leadership{}
+
+func (l *leadership) isLeader() bool {
+	return atomic.LoadInt32(&l.leading) == 1
+}
+
+func (l *leadership) setLeader(leading bool) {
+	var value int32
+	if leading {
+		value = 1
+	}
+	atomic.StoreInt32(&l.leading, value)
+}
+
+// leaderOnly serves requests that change tasks when we lead, and proxies them to
+// the leader when we stand by.
+func leaderOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if leader.isLeader() {
+			handler(w, r)
+			return
+		}
+		if leader.elector == nil {
+			writeError(w, http.StatusServiceUnavailable, "recovering tasks")
+			return
+		}
+
+		address, err := leader.elector.Leader()
+		if err != nil {
+			log.Error.Printf("Failed to find the leader: %+v", err)
+		}
+		// We may have been elected and not yet recovered, or a leader that stopped
+		// without resigning may have left our address behind.
+		if address == "" || address == leader.address {
+			writeError(w, http.StatusServiceUnavailable, "no leader elected")
+			return
+		}
+		log.Debug.Printf("Proxying %s %s to leader %s", r.Method, r.URL, address)
+		httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: address}).ServeHTTP(w, r)
+	}
+}
+
+// standBy waits until we are elected, keeping the task store up to date with what
+// the leader stores every interval. It returns the channel that is closed if we stop
+// being the leader.
+func standBy(ctx context.Context, elector LeaderElector, load func() (string, []*TaskRecord, error), interval time.Duration) (<-chan struct{}, error) {
+	type result struct {
+		lost <-chan struct{}
+		err  error
+	}
+	elected := make(chan result, 1)
+	go func() {
+		lost, err := elector.Campaign(ctx)
+		elected <- result{lost, err}
+	}()
+
+	reload := func() {
+		if load == nil {
+			return
+		}
+		_, records, err := load()
+		if err != nil {
+			log.Warn.Printf("Failed to load tasks while standing by: %+v", err)
+			return
+		}
+		if err := taskstore.Reload(records); err != nil {
+			log.Warn.Printf("Failed to reload tasks while standing by: %+v", err)
+		}
+	}
+
+	reload()
+	refresh := time.NewTicker(interval)
+	defer refresh.Stop()
+	for {
+		select {
+		case r := <-elected:
+			if r.err != nil {
+				return nil, r.err
+			}
+			log.Info.Printf("Elected leader")
+			return r.lost, nil
+		case <-refresh.C:
+			reload()
+		}
+	}
+}
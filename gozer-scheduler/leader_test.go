@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockFileElector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lock")
+	first := newLockFileElector(path, "first:4343", 10*time.Millisecond)
+	second := newLockFileElector(path, "second:4343", 10*time.Millisecond)
+
+	lost, err := first.Campaign(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	if _, err := second.Campaign(ctx); err == nil {
+		t.Error("second elector elected while the first leads")
+	}
+	cancel()
+	for _, elector := range []*lockFileElector{first, second} {
+		if address, err := elector.Leader(); err != nil || address != "first:4343" {
+			t.Errorf("got leader %q (%v), want %q", address, err, "first:4343")
+		}
+	}
+
+	// The second takes over once the first resigns.
+	elected := make(chan error, 1)
+	go func() {
+		_, err := second.Campaign(context.Background())
+		elected <- err
+	}()
+	if err := first.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lost:
+	default:
+		t.Error("resigning did not close the lost channel")
+	}
+	if err := <-elected; err != nil {
+		t.Fatal(err)
+	}
+	if address, err := first.Leader(); err != nil || address != "second:4343" {
+		t.Errorf("got leader %q (%v), want %q", address, err, "second:4343")
+	}
+	second.Resign()
+}
+
+func TestNewLeaderElector(t *testing.T) {
+	if elector, err := newLeaderElector("", "here:4343"); elector != nil || err != nil {
+		t.Errorf("got %v (%v) for no leader election, want none", elector, err)
+	}
+	if _, err := newLeaderElector("lockfile:/tmp/gozer.lock", "here:4343"); err != nil {
+		t.Error(err)
+	}
+	for _, spec := range []string{"lockfile", "lockfile:", "zookeeper:localhost:2181"} {
+		if _, err := newLeaderElector(spec, "here:4343"); err == nil {
+			t.Errorf("made a leader elector for %q", spec)
+		}
+	}
+}
+
+// fixedElector reports a leader, and never elects us.
+type fixedElector string
+
+func (e fixedElector) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (e fixedElector) Leader() (string, error) { return string(e), nil }
+func (e fixedElector) Resign() error           { return nil }
+
+// post posts to url, and returns the status and body of the response.
+func post(t *testing.T, url string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestLeaderOnly(t *testing.T) {
+	leaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("leader " + r.URL.Path))
+	}))
+	defer leaderServer.Close()
+	leaderAddress := strings.TrimPrefix(leaderServer.URL, "http://")
+
+	server := httptest.NewServer(leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("local " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	saved := leader
+	defer func() { leader = saved }()
+
+	for _, test := range []struct {
+		name       string
+		leadership *leadership
+		status     int
+		body       string
+	}{
+		{"leader", &leadership{elector: fixedElector(leaderAddress), address: "here:4343", leading: 1}, http.StatusOK, "local /api/addtask"},
+		{"standby", &leadership{elector: fixedElector(leaderAddress), address: "here:4343"}, http.StatusOK, "leader /api/addtask"},
+		{"no leader", &leadership{elector: fixedElector(""), address: "here:4343"}, http.StatusServiceUnavailable, ""},
+		{"elected, recovering", &leadership{elector: fixedElector("here:4343"), address: "here:4343"}, http.StatusServiceUnavailable, ""},
+		{"recovering alone", &leadership{}, http.StatusServiceUnavailable, ""},
+	} {
+		leader = test.leadership
+		status, body := post(t, server.URL+"/api/addtask")
+		if status != test.status || (test.body != "" && body != test.body) {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, status, body, test.status, test.body)
+		}
+	}
+}
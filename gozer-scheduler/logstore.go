@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/twitter/gozer/replog"
+)
+
+// The path our replica of the replicated log is served under.
+const replicaPath = "/log"
+
+// How long to wait for the replicated log to take a write.
+const logStoreTimeout = 10 * time.Second
+
+// How long to wait before trying again to catch up with the log while opening it.
+const logStoreRetry = 500 * time.Millisecond
+
+// logStore is a Store that appends every change to a log replicated among several
+// schedulers, as a journal entry. Reading the log adds the entries up to the stored
+// state. Once enough entries have been appended, a snapshot of the state is
+// appended, and the log truncated up to it.
+//
+// Any of the schedulers may write to the log, but their writes interrupt each other.
+// Each reads what the others wrote before its own entries. A standby only reads what
+// the others have learned, with LoadLearned, so as not to interrupt the leader.
+type logStore struct {
+	sync.Mutex
+	log          *replog.Log
+	compactAfter int
+
+	// The last position read, and how many entries have been read since the last
+	// snapshot.
+	position uint64
+	entries  int
+
+	journalState
+}
+
+// openLogStore recovers our replica of l, and reads what has been learned of the log.
+// It waits for a quorum of the replicas to be reachable, and to have recovered.
+func openLogStore(l *replog.Log, compactAfter int) (*logStore, error) {
+	if err := l.Recover(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to recover replicated log: %+v", err)
+	}
+
+	s := &logStore{
+		log:          l,
+		compactAfter: compactAfter,
+		journalState: newJournalState(),
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), logStoreTimeout)
+		end, err := l.Learn(ctx)
+		cancel()
+		if err == nil {
+			return s, s.read(end)
+		}
+		log.Warn.Printf("Waiting to catch up with replicated log: %+v", err)
+		time.Sleep(logStoreRetry)
+	}
+}
+
+// serveReplica serves replica to the other schedulers on port.
+func serveReplica(replica *replog.Replica, port int) {
+	mux := http.NewServeMux()
+	mux.Handle(replicaPath+"/", replica)
+	log.Info.Printf("Log replica listening on port %d", port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		log.Error.Fatalf("Failed to start listening on port %d", port)
+	}
+}
+
+// catchUp reads everything written to the log so far.
+func (s *logStore) catchUp() error {
+	ctx, cancel := context.WithTimeout(context.Background(), logStoreTimeout)
+	defer cancel()
+
+	end, err := s.log.CatchUp(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to catch up with replicated log: %+v", err)
+	}
+	return s.read(end)
+}
+
+// read applies the entries up to position to, which must all have been learned.
+// Entries that have been truncated are covered by the snapshot the log now begins
+// with.
+func (s *logStore) read(to uint64) error {
+	from := s.position + 1
+	if begin := s.log.Beginning(); from < begin {
+		from = begin
+	}
+	if from > to {
+		return nil
+	}
+
+	entries, err := s.log.Read(from, to)
+	if err != nil {
+		return fmt.Errorf("failed to read replicated log: %+v", err)
+	}
+	for _, e := range entries {
+		var entry journalEntry
+		if err := json.Unmarshal(e.Data, &entry); err != nil {
+			return fmt.Errorf("corrupt entry at position %d of replicated log: %+v", e.Position, err)
+		}
+		if err := s.apply(&entry); err != nil {
+			return fmt.Errorf("corrupt entry at position %d of replicated log: %+v", e.Position, err)
+		}
+		if entry.Op == journalSnapshot {
+			s.entries = 0
+		} else {
+			s.entries++
+		}
+	}
+	s.position = to
+	return nil
+}
+
+// append writes an entry to the log, then reads the log up to it. A write only
+// succeeds once every earlier position has been learned by our replica.
+func (s *logStore) append(entry *journalEntry) error {
+	s.Lock()
+	defer s.Unlock()
+
+	position, err := s.write(entry)
+	if err != nil {
+		return err
+	}
+	if err := s.read(position); err != nil {
+		return err
+	}
+
+	if s.entries >= s.compactAfter {
+		if err := s.compact(); err != nil {
+			// The entry is safe in the log, which is left to grow until the next
+			// attempt.
+			log.Warn.Printf("Failed to compact replicated log: %+v", err)
+		}
+	}
+	return nil
+}
+
+func (s *logStore) write(entry *journalEntry) (uint64, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal journal entry %+v: %+v", entry, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logStoreTimeout)
+	defer cancel()
+	position, err := s.log.Append(ctx, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to append to replicated log: %+v", err)
+	}
+	return position, nil
+}
+
+// compact appends a snapshot, and truncates the log up to it. Should we stop before
+// the log is truncated, reading the entries before the snapshot again is harmless.
+func (s *logStore) compact() error {
+	position, err := s.write(&journalEntry{
+		Op:       journalSnapshot,
+		Snapshot: &snapshot{FrameworkId: s.frameworkId, Tasks: s.sorted()},
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.read(position); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logStoreTimeout)
+	defer cancel()
+	if _, err := s.log.Truncate(ctx, position); err != nil {
+		return fmt.Errorf("failed to truncate replicated log: %+v", err)
+	}
+
+	log.Info.Printf("Compacted replicated log into snapshot of %d tasks at position %d", len(s.tasks), position)
+	return nil
+}
+
+// Load catches up with the log before returning what is stored, as other schedulers
+// may have written to it.
+func (s *logStore) Load() (string, []*TaskRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.catchUp(); err != nil {
+		return "", nil, err
+	}
+	frameworkId, tasks := s.load()
+	return frameworkId, tasks, nil
+}
+
+// LoadLearned returns what is stored as far as the other schedulers have learned it.
+// Unlike Load, it does not catch up by proposing anything to the log, which would
+// interrupt the leader's writes, so a standby loads this way.
+func (s *logStore) LoadLearned() (string, []*TaskRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), logStoreTimeout)
+	defer cancel()
+	end, err := s.log.Learn(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to learn replicated log: %+v", err)
+	}
+	if err := s.read(end); err != nil {
+		return "", nil, err
+	}
+	frameworkId, tasks := s.load()
+	return frameworkId, tasks, nil
+}
+
+func (s *logStore) PutTask(task *TaskRecord) error {
+	record := *task
+	return s.append(&journalEntry{Op: journalPutTask, Task: &record})
+}
+
+func (s *logStore) DeleteTask(taskId string) error {
+	return s.append(&journalEntry{Op: journalDeleteTask, TaskId: taskId})
+}
+
+func (s *logStore) PutFrameworkId(frameworkId string) error {
+	return s.append(&journalEntry{Op: journalFrameworkId, FrameworkId: frameworkId})
+}
+
+// Close leaves the replica to be closed by its owner, which may still be serving it.
+func (s *logStore) Close() error {
+	return nil
+}
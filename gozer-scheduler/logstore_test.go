@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/twitter/gozer/gozer"
+	"github.com/twitter/gozer/replog"
+)
+
+// openLogStores opens a store for each of n schedulers, sharing a log replicated
+// among them in memory.
+func openLogStores(t *testing.T, n int, compactAfter int) []*logStore {
+	var replicas []*replog.Replica
+	for i := 0; i < n; i++ {
+		replica, err := replog.NewReplica("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas = append(replicas, replica)
+	}
+
+	// The replicas start the log together, so the stores are opened at once.
+	stores := make([]*logStore, n)
+	errs := make(chan error, n)
+	for i := range replicas {
+		var peers []replog.Peer
+		for j, replica := range replicas {
+			if j != i {
+				peers = append(peers, replica)
+			}
+		}
+		go func(i int, log *replog.Log) {
+			var err error
+			stores[i], err = openLogStore(log, compactAfter)
+			errs <- err
+		}(i, replog.New(replicas[i], peers))
+	}
+	for range replicas {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stores
+}
+
+func TestLogStore(t *testing.T) {
+	stores := openLogStores(t, 3, 100)
+
+	running := taskRecord("task-1", gozer.TaskState_RUNNING, 1)
+	running.SlaveId = "slave-1"
+	for _, task := range []*TaskRecord{
+		taskRecord("task-1", gozer.TaskState_PENDING, 1),
+		taskRecord("task-2", gozer.TaskState_PENDING, 2),
+		taskRecord("task-0", gozer.TaskState_PENDING, 0),
+		running,
+	} {
+		if err := stores[0].PutTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stores[0].DeleteTask("task-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another scheduler reads what the first wrote, and carries on from it.
+	if err := stores[1].PutFrameworkId("framework-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range stores {
+		checkLoad(t, store, "framework-1", taskRecord("task-0", gozer.TaskState_PENDING, 0), running)
+	}
+}
+
+func TestLogStoreStandby(t *testing.T) {
+	stores := openLogStores(t, 3, 100)
+
+	if err := stores[0].PutFrameworkId("framework-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores[0].PutTask(taskRecord("task-0", gozer.TaskState_PENDING, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A standby loads what the leader stored without interrupting it, so the
+	// leader's next write succeeds the first time.
+	id, tasks, err := stores[1].LoadLearned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "framework-1" || len(tasks) != 1 || tasks[0].Task.Id != "task-0" {
+		t.Errorf("standby loaded %q, %+v", id, tasks)
+	}
+	if err := stores[0].PutTask(taskRecord("task-1", gozer.TaskState_PENDING, 1)); err != nil {
+		t.Errorf("leader failed to write after a standby loaded: %v", err)
+	}
+}
+
+func TestLogStoreCompaction(t *testing.T) {
+	stores := openLogStores(t, 3, 3)
+
+	stores[0].PutFrameworkId("framework-1")
+	stores[0].PutTask(taskRecord("task-0", gozer.TaskState_PENDING, 0))
+	stores[0].PutTask(taskRecord("task-1", gozer.TaskState_PENDING, 1))
+	stores[0].PutTask(taskRecord("task-0", gozer.TaskState_ASSIGNED, 0))
+
+	// The first three entries are in the snapshot, which the log now begins with.
+	if stores[0].entries != 1 {
+		t.Errorf("got %d entries since the snapshot, want 1", stores[0].entries)
+	}
+	if begin := stores[0].log.Beginning(); begin != 4 {
+		t.Errorf("log begins at %d after compaction, want 4", begin)
+	}
+
+	// A scheduler that had read none of it starts from the snapshot.
+	for _, store := range stores {
+		checkLoad(t, store, "framework-1",
+			taskRecord("task-0", gozer.TaskState_ASSIGNED, 0),
+			taskRecord("task-1", gozer.TaskState_PENDING, 1))
+	}
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/twitter/gozer/gozer"
 	"github.com/twitter/gozer/mesos"
+	"github.com/twitter/gozer/replog"
 )
 
 var (
@@ -14,6 +21,24 @@ var (
 	master     = flag.String("master", "localhost", "Hostname of the master")
 	masterPort = flag.Int("masterPort", 5050, "Port of the master")
 
+	libprocessIp   = flag.String("libprocessIp", "", "Interface to bind the libprocess endpoint to (default $LIBPROCESS_IP, or all)")
+	libprocessPort = flag.Int("libprocessPort", 0, "Port to bind the libprocess endpoint to (default $LIBPROCESS_PORT, or ephemeral)")
+	advertiseIp    = flag.String("advertiseIp", "", "IP the master should use to reach us (default $LIBPROCESS_ADVERTISE_IP)")
+	advertisePort  = flag.Int("advertisePort", 0, "Port the master should use to reach us (default $LIBPROCESS_ADVERTISE_PORT)")
+
+	failover        = flag.Bool("failover", false, "On SIGINT or SIGTERM, leave tasks running for another scheduler to take over")
+	failoverTimeout = flag.Duration("failoverTimeout", 24*time.Hour, "How long the master keeps our tasks running while no scheduler is registered")
+
+	stateDir = flag.String("stateDir", "", "Directory to keep the task journal in; if empty, tasks do not survive a restart")
+	logPort  = flag.Int("logPort", 0, "Port to serve our replica of the replicated task log on; if zero, the journal in -stateDir is not replicated")
+	logPeers = flag.String("logPeers", "", "Comma-separated host:port of the other schedulers' log replicas")
+
+	leaderElection = flag.String("leaderElection", "", "How to elect the leader among schedulers, as backend:argument, such as lockfile:/var/run/gozer.lock; if empty, we lead alone")
+	apiAddress     = flag.String("apiAddress", "", "host:port other schedulers reach our API at (default this host's name and -port)")
+	standbyRefresh = flag.Duration("standbyRefresh", 5*time.Second, "How often a standby reloads the tasks the leader has stored")
+
+	slaveQuarantine = flag.Duration("slaveQuarantine", 5*time.Minute, "How long to avoid placing tasks on a lost slave that has not come back")
+
 	taskstore = NewTaskStore()
 
 	// TODO(dhamon): flags for log level
@@ -25,37 +50,246 @@ var (
 	)
 )
 
+// How long to wait for the driver to deliver a launch, decline or ack to the master.
+const commandTimeout = 10 * time.Second
+
+// The file our replica of the replicated log is kept in, in -stateDir.
+const replicaFile = "replica.log"
+
+// openStore opens the store the flags ask for, or returns nil if tasks are not to be
+// stored. With -logPort, the journal is kept in a log replicated among the schedulers
+// listed in -logPeers, and opening it waits for a quorum of them to be running.
+func openStore() (Store, error) {
+	if *stateDir == "" {
+		if *logPort != 0 {
+			return nil, fmt.Errorf("-logPort needs -stateDir to keep the replica in")
+		}
+		return nil, nil
+	}
+	if *logPort == 0 {
+		return openFileStore(*stateDir, journalCompactAfter)
+	}
+
+	if err := os.MkdirAll(*stateDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory %s: %+v", *stateDir, err)
+	}
+	replica, err := replog.NewReplica(filepath.Join(*stateDir, replicaFile))
+	if err != nil {
+		return nil, err
+	}
+	go serveReplica(replica, *logPort)
+
+	var peers []replog.Peer
+	for _, peer := range strings.Split(*logPeers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, replog.NewHTTPPeer(fmt.Sprintf("http://%s%s", peer, replicaPath), nil))
+		}
+	}
+	log.Info.Printf("Recovering replicated log with %d peers", len(peers))
+	return openLogStore(replog.New(replica, peers), journalCompactAfter)
+}
+
 func main() {
+	os.Exit(run())
+}
+
+// run runs the scheduler until it stops, and returns the status to exit with. It
+// returns rather than exiting so that its deferred clean-up runs.
+func run() int {
 	flag.Parse()
+	taskstore.LimitHistory(*historySize, *historyAge)
+
+	serverTLS, clientTLS, err := tlsConfigs()
+	if err != nil {
+		log.Error.Print(err)
+		return 1
+	}
 
+	address := *apiAddress
+	if address == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Error.Print(err)
+			return 1
+		}
+		address = fmt.Sprintf("%s:%d", hostname, *port)
+	}
+	elector, err := newLeaderElector(*leaderElection, address)
+	if err != nil {
+		log.Error.Print(err)
+		return 1
+	}
+	leader.elector, leader.address = elector, address
+
+	// Until we lead and have recovered, the API serves reads but no writes.
 	go startHTTP()
 
+	// A standby reads what the leader stores, but must not write to it. The
+	// replicated log can be read by all, but a journal file is only opened by the
+	// leader.
+	var store Store
+	var load func() (string, []*TaskRecord, error)
+	if elector == nil || *logPort != 0 {
+		if store, err = openStore(); err != nil {
+			log.Error.Print(err)
+			return 1
+		}
+		if replicated, ok := store.(*logStore); ok {
+			load = replicated.LoadLearned
+		} else if store != nil {
+			load = store.Load
+		}
+	} else if *stateDir != "" {
+		load = func() (string, []*TaskRecord, error) { return readFileStore(*stateDir) }
+	} else {
+		log.Warn.Printf("No -stateDir: a new leader will register as a new framework, and lose the tasks")
+	}
+
+	var lost <-chan struct{}
+	if elector != nil {
+		log.Info.Printf("Standing by for election as %s", address)
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			select {
+			case sig := <-interrupted:
+				log.Info.Printf("Received %s while standing by. Exiting", sig)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		lost, err = standBy(ctx, elector, load, *standbyRefresh)
+		signal.Stop(interrupted)
+		interruptedErr := ctx.Err()
+		cancel()
+		if interruptedErr != nil {
+			if store != nil {
+				store.Close()
+			}
+			return 0
+		}
+		if err != nil {
+			log.Error.Print(err)
+			return 1
+		}
+		defer elector.Resign()
+
+		if store == nil && *stateDir != "" {
+			if store, err = openStore(); err != nil {
+				log.Error.Print(err)
+				return 1
+			}
+		}
+	}
+
+	// Recover the tasks from the last run before the driver can tell us about them.
+	frameworkId := ""
+	if store != nil {
+		defer store.Close()
+
+		var records []*TaskRecord
+		if frameworkId, records, err = store.Load(); err != nil {
+			log.Error.Print(err)
+			return 1
+		}
+		if err := taskstore.Recover(store, records); err != nil {
+			log.Error.Print(err)
+			return 1
+		}
+	}
+	leader.setLeader(true)
+
 	log.Info.Println("Registering")
-	driver, err := mesos.New("gozer", *user, *master, *masterPort)
+	driver, err := mesos.NewWithConfig(mesos.DriverConfig{
+		FrameworkName:   "gozer",
+		RegisteredUser:  *user,
+		FrameworkId:     frameworkId,
+		FailoverTimeout: *failoverTimeout,
+		Masters: []mesos.MasterAddress{
+			mesos.MasterAddress{Hostname: *master, Port: *masterPort},
+		},
+		Endpoint: mesos.RouterConfig{
+			BindAddress:   *libprocessIp,
+			Port:          *libprocessPort,
+			AdvertiseIP:   *advertiseIp,
+			AdvertisePort: *advertisePort,
+			ServerTLS:     serverTLS,
+			ClientTLS:     clientTLS,
+		},
+	})
 	if err != nil {
-		log.Error.Fatal(err)
+		log.Error.Print(err)
+		return 1
 	}
+	go reconcile(driver)
 
 	// Shepherd all our tasks
 	//
-	// Note: This will require a significant re-architecting, most likely to break out the
-	// gozer based tasks and their state transitions, possibly using a go-routine per task,
-	// which may limit the total number of tasks we can handle (100k go-routines might be
-	// too much).  It should make for a simple abstraction, where the update routine should
-	// then be able to simply use a channel to post a state transition to the gozer task,
-	// which the gozer task manager (per task) go routine would then use to transition the
-	// task through its state diagram.  This should also make it very simple to detect bad
-	// transitions.
-	//
-	// It would be nice if we could just only use the mesos.TaskState_TASK_* states, however,
-	// they do not encompass the ideas of PENDING (waiting for offers), and ASSIGNED (offer
-	// selected, waiting for running), nor do they encompass tear-down and death.
+	// The mesos.TaskState_TASK_* states do not encompass the ideas of PENDING (waiting
+	// for offers), and ASSIGNED (offer selected, waiting for running), nor do they
+	// encompass tear-down and death. The gozer task lifecycle does, and the task store
+	// rejects updates that would move a task through it in a way that makes no sense.
 	//
 	// For now we use a simple loop to do a very naive management of tasks, updates, events,
 	// errors, etc.
+	slaves := newSlaveTracker(*slaveQuarantine)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	// Once we are stopping, updates are still acknowledged until the driver closes
+	// its channels, but no more tasks are launched.
+	stopping := false
+	stopped := make(chan error, 1)
+
+	// Tasks that are still pending when the request timer fires were not placed by
+	// the offers since the last one, so we ask the allocator for more.
+	var requestTimer <-chan time.Time
+	if *requestInterval > 0 {
+		requestTimer = time.Tick(*requestInterval)
+	}
+
 	exit := false
 	for !exit {
 		select {
+		case sig := <-signals:
+			if stopping {
+				continue
+			}
+			log.Info.Printf("Received %s. Stopping (failover %v)", sig, *failover)
+			stopping = true
+			go func() { stopped <- driver.Stop(*failover) }()
+
+		case <-lost:
+			// Another scheduler leads now, and takes over our tasks.
+			lost = nil
+			leader.setLeader(false)
+			if stopping {
+				continue
+			}
+			log.Error.Printf("Lost leadership. Stopping")
+			stopping = true
+			go func() { stopped <- driver.Stop(true) }()
+
+		case <-kills:
+			if !stopping {
+				killTasks(driver)
+			}
+
+		case <-requestTimer:
+			pending := taskstore.Pending()
+			if stopping || len(pending) == 0 {
+				continue
+			}
+			log.Info.Printf("Requesting resources for %d pending tasks", len(pending))
+			ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+			if err := driver.RequestResources(ctx, pendingRequests(pending)); err != nil {
+				log.Error.Printf("Failed to request resources: %+v", err)
+			}
+			cancel()
+
 		case update, ok := <-driver.Updates:
 			if !ok {
 				log.Info.Printf("Update channel closed. Exiting")
@@ -63,24 +297,16 @@ func main() {
 				break
 			}
 			log.Info.Printf("Received update: %+v", update)
-			state, err := taskstore.State(update.TaskId)
-			if err != nil {
-				log.Error.Printf("Failed to get current state for updated task %q: %+s", update.TaskId, err)
-				continue
-			}
-
-			newState, ok := gozer.TaskStateMap[update.State]
-			if !ok {
-				log.Error.Printf("Unknown mesos task state: %q", update.State)
+			if !applyUpdate(update) {
+				// The update is sent again, for us to have another go.
 				continue
 			}
 
-			log.Info.Printf("Updating task state from %q to %q", state, newState)
-			if err := taskstore.Update(update.TaskId, newState); err != nil {
-				log.Error.Print(err)
+			ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+			if err := update.Ack(ctx); err != nil {
+				log.Error.Printf("Failed to acknowledge update %s: %+v", update, err)
 			}
-
-			update.Ack()
+			cancel()
 
 		case offer, ok := <-driver.Offers:
 			if !ok {
@@ -89,43 +315,99 @@ func main() {
 				break
 			}
 			log.Info.Printf("Received offer: %+v", offer)
+			slaves.offered(offer.SlaveId, offer.Hostname)
 
-			taskIds := taskstore.Ids()
-			launched := false
-			for _, taskId := range taskIds {
-				state, err := taskstore.State(taskId)
-				if err != nil {
-					log.Error.Printf("Error getting task state for task %q: %+v", taskId, err)
-					continue
+			// We have registered by now. The id must be stored before any task is
+			// launched under it, for the next run to take the task over.
+			idStored := true
+			if id := driver.FrameworkId(); store != nil && id != frameworkId {
+				if err := store.PutFrameworkId(id); err != nil {
+					log.Error.Printf("Failed to store framework id %q: %+v", id, err)
+					idStored = false
+				} else {
+					frameworkId = id
 				}
+			}
 
-				if state != gozer.TaskState_INIT {
-					continue
-				}
+			var mesosTask *mesos.MesosTask
+			ok = !stopping && idStored
+			if ok && !slaves.available(offer.SlaveId, offer.Hostname) {
+				log.Info.Printf("Not placing tasks on recently lost slave %s", offer.Hostname)
+				ok = false
+			}
+			if ok {
+				// The offer goes to the first task in the queue it is big enough for.
+				mesosTask, ok = taskstore.NextPendingFitting(offer.Fits)
+			}
 
-				mesosTask, err := taskstore.MesosTask(taskId)
-				if err != nil {
-					log.Error.Printf("Error getting mesos task for task %q: %+v", taskId, err)
-					continue
+			launched := false
+			if ok {
+				log.Info.Printf("Launching task %s", mesosTask.Id)
+				// The task is assigned before it is launched, so that a kill from now
+				// on reaches Mesos rather than leaving the task to run.
+				if err := taskstore.Launched(mesosTask.Id, offer.SlaveId); err != nil {
+					log.Error.Print(err)
+				} else {
+					ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+					err := driver.LaunchTask(ctx, offer, mesosTask)
+					cancel()
+					if err != nil {
+						log.Error.Printf("Error launching task %q: %+v", mesosTask.Id, err)
+						// Let the tasks behind it have a go with the next offer.
+						if err := taskstore.LaunchFailed(mesosTask.Id); err != nil {
+							log.Error.Print(err)
+						}
+					} else {
+						launched = true
+					}
 				}
+			}
 
-				// TODO(dhamon): Check for resource match before launching.
-				log.Info.Printf("Launching task %s", taskId)
-				err = driver.LaunchTask(offer, mesosTask)
-				if err != nil {
-					log.Error.Printf("Error launching task %q: %+v", taskId, err)
-					continue
+			if !launched {
+				log.Info.Printf("Declining offer %s", offer.Id)
+				ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+				if err := offer.Decline(ctx); err != nil {
+					log.Error.Printf("Failed to decline offer %s: %+v", offer.Id, err)
 				}
+				cancel()
+			}
 
-				taskstore.Update(taskId, gozer.TaskState_STARTING)
-				launched = true
+		case loss, ok := <-driver.Lost:
+			if !ok {
+				log.Info.Printf("Lost channel closed. Exiting")
+				exit = true
 				break
 			}
+			log.Warn.Printf("Received %s", loss)
 
-			if !launched {
-				log.Info.Printf("Declining offer %s", offer.Id)
-				offer.Decline()
+			var taskIds []string
+			if loss.SlaveLost() {
+				slaves.slaveLost(loss.SlaveId)
+				taskIds = taskstore.OnSlave(loss.SlaveId)
+			} else if _, err := taskstore.State(loss.ExecutorId); err == nil && loss.Status != 0 {
+				// Tasks are run by the command executor, which shares their id. One that
+				// exits cleanly is followed by an update with the task's final state.
+				taskIds = []string{loss.ExecutorId}
+			}
+
+			for _, taskId := range taskIds {
+				log.Info.Printf("Marking task %q lost", taskId)
+				if err := taskstore.Lost(taskId, loss.String()); err != nil {
+					log.Error.Print(err)
+				}
 			}
 		}
 	}
+
+	if err := driver.Err(); err != nil {
+		log.Error.Printf("Driver stopped: %v", err)
+		return 1
+	}
+	if stopping {
+		if err := <-stopped; err != nil {
+			log.Error.Printf("Failed to stop cleanly: %v", err)
+			return 1
+		}
+	}
+	return 0
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/gozer"
+	"github.com/twitter/gozer/mesos"
+	mesos_pb "github.com/twitter/gozer/proto/mesos.pb"
+)
+
+// reconcileStates maps the states of launched tasks to the Mesos state we last knew
+// them to be in.
+var reconcileStates = map[gozer.TaskState]mesos_pb.TaskState{
+	gozer.TaskState_ASSIGNED: mesos_pb.TaskState_TASK_STAGING,
+	gozer.TaskState_STARTING: mesos_pb.TaskState_TASK_STARTING,
+	gozer.TaskState_RUNNING:  mesos_pb.TaskState_TASK_RUNNING,
+	gozer.TaskState_KILLING:  mesos_pb.TaskState_TASK_RUNNING,
+}
+
+// reconcile asks the master about the recovered tasks that were launched, so that
+// those that changed state while we were not running are updated, and asks again for
+// those we were killing to be killed. It waits for the driver to register.
+func reconcile(driver *mesos.Driver) {
+	var states []gozer.TaskState
+	for state := range reconcileStates {
+		states = append(states, state)
+	}
+	records := taskstore.InState(states...)
+	if len(records) == 0 {
+		return
+	}
+
+	statuses := make([]*mesos_pb.TaskStatus, len(records))
+	for i, record := range records {
+		state := reconcileStates[record.Task.State]
+		statuses[i] = &mesos_pb.TaskStatus{
+			TaskId: &mesos_pb.TaskID{Value: proto.String(mesosId(&record.Task))},
+			State:  &state,
+		}
+		if record.SlaveId != "" {
+			statuses[i].SlaveId = &mesos_pb.SlaveID{Value: proto.String(record.SlaveId)}
+		}
+	}
+
+	log.Info.Printf("Reconciling %d launched tasks", len(statuses))
+	if err := driver.ReconcileTasks(context.Background(), statuses); err != nil {
+		log.Error.Printf("Failed to reconcile tasks: %+v", err)
+		return
+	}
+	requestKills()
+}
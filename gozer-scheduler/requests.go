@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/mesos"
+	mesos_pb "github.com/twitter/gozer/proto/mesos.pb"
+)
+
+var requestInterval = flag.Duration("requestInterval", 0, "How often to ask the allocator for resources for pending tasks (0 disables)")
+
+// defaultRole is the role offers are made to frameworks that do not set one.
+const defaultRole = "*"
+
+// requestGroup is the set of pending tasks asked for in one request: those wanting
+// resources of the same role on the same slave, or on any slave if SlaveId is empty.
+type requestGroup struct {
+	role    string
+	slaveId string
+}
+
+// requestGroupOf returns the group a pending task is asked for in. Tasks are not yet
+// tied to slaves or roles, so all of them share the default role on any slave.
+func requestGroupOf(task *mesos.MesosTask) requestGroup {
+	return requestGroup{role: defaultRole}
+}
+
+// pendingRequests returns one request per group of pending tasks, for the sum of
+// their resources, so that the number of requests does not grow with the queue.
+func pendingRequests(pending []*mesos.MesosTask) []*mesos_pb.Request {
+	scalar := func(name string, value float64, role string) *mesos_pb.Resource {
+		scalarType := mesos_pb.Value_SCALAR
+		return &mesos_pb.Resource{
+			Name:   proto.String(name),
+			Type:   &scalarType,
+			Scalar: &mesos_pb.Value_Scalar{Value: proto.Float64(value)},
+			Role:   proto.String(role),
+		}
+	}
+
+	// Groups are kept in the order their first task is pending in.
+	var groups []requestGroup
+	totals := make(map[requestGroup]*[2]float64)
+	for _, task := range pending {
+		group := requestGroupOf(task)
+		total, ok := totals[group]
+		if !ok {
+			total = new([2]float64)
+			totals[group] = total
+			groups = append(groups, group)
+		}
+		total[0] += task.Cpus
+		total[1] += task.Mem
+	}
+
+	requests := make([]*mesos_pb.Request, len(groups))
+	for i, group := range groups {
+		requests[i] = &mesos_pb.Request{
+			Resources: []*mesos_pb.Resource{
+				scalar("cpus", totals[group][0], group.role),
+				scalar("mem", totals[group][1], group.role),
+			},
+		}
+		if group.slaveId != "" {
+			requests[i].SlaveId = &mesos_pb.SlaveID{Value: proto.String(group.slaveId)}
+		}
+	}
+	return requests
+}
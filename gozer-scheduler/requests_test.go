@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/twitter/gozer/gozer"
+)
+
+func TestPendingRequests(t *testing.T) {
+	store := NewTaskStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	retry := &gozer.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 10}
+	for _, task := range []*gozer.Task{
+		{Id: "retried", Command: "false", Priority: 10, Retry: retry},
+		{Id: "big", Command: "true", Cpus: 4, Mem: 1024},
+		{Id: "default", Command: "true"},
+	} {
+		if err := store.Add(&Task{gozerTask: task}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	launch(t, store, "retried", gozer.TaskState_FAILED, "")
+
+	// The pending tasks share a role and are not tied to a slave, so a single request
+	// asks for all of their resources. One waiting to be retried asks for nothing
+	// until its backoff is over.
+	requests := pendingRequests(store.Pending())
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	want := [2]float64{4 + gozer.DefaultCpus, 1024 + gozer.DefaultMem}
+	resources := requests[0].GetResources()
+	if len(resources) != 2 {
+		t.Fatalf("got request %v, want cpus and mem", requests[0])
+	}
+	got := [2]float64{resources[0].GetScalar().GetValue(), resources[1].GetScalar().GetValue()}
+	if got != want || resources[0].GetRole() != defaultRole || requests[0].SlaveId != nil {
+		t.Errorf("got request %v, want %v for role %q on any slave", requests[0], want, defaultRole)
+	}
+
+	now = now.Add(time.Minute)
+	if pending := store.Pending(); len(pending) != 3 {
+		t.Errorf("got %d pending tasks after the backoff, want 3", len(pending))
+	}
+	requests = pendingRequests(store.Pending())
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests after the backoff, want 1", len(requests))
+	}
+	if cpus := requests[0].GetResources()[0].GetScalar().GetValue(); cpus != want[0]+gozer.DefaultCpus {
+		t.Errorf("got %v cpus after the backoff, want %v", cpus, want[0]+gozer.DefaultCpus)
+	}
+}
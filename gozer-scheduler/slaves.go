@@ -0,0 +1,66 @@
+package main
+
+import (
+	"time"
+)
+
+// slaveTracker remembers slaves that have been lost, so that tasks are not placed
+// on them until they come back. A lost slave that re-registers does so with a new
+// id, so slaves are remembered by hostname, and one is back once it is offered
+// under another id. Offers made before it was lost may still arrive under the old
+// one.
+//
+// It is only used from the main loop.
+type slaveTracker struct {
+	// How long to remember a lost slave that does not come back.
+	quarantine time.Duration
+
+	// Hostnames of the slaves we have been offered resources on, by slave id.
+	hostnames map[string]string
+	// The lost slaves, by hostname.
+	lost map[string]lostSlave
+}
+
+type lostSlave struct {
+	slaveId string
+	lost    time.Time
+}
+
+func newSlaveTracker(quarantine time.Duration) *slaveTracker {
+	return &slaveTracker{
+		quarantine: quarantine,
+		hostnames:  make(map[string]string),
+		lost:       make(map[string]lostSlave),
+	}
+}
+
+// offered records the hostname of the slave an offer came from.
+func (s *slaveTracker) offered(slaveId, hostname string) {
+	s.hostnames[slaveId] = hostname
+}
+
+// slaveLost quarantines a slave.
+func (s *slaveTracker) slaveLost(slaveId string) {
+	hostname, ok := s.hostnames[slaveId]
+	if !ok {
+		// We never used it, so there is nothing to avoid.
+		return
+	}
+	delete(s.hostnames, slaveId)
+	s.lost[hostname] = lostSlave{slaveId: slaveId, lost: time.Now()}
+}
+
+// available reports whether tasks may be placed on the given slave. A lost slave is
+// available again once it comes back under a new id, or once it has been gone for
+// longer than the quarantine.
+func (s *slaveTracker) available(slaveId, hostname string) bool {
+	lost, ok := s.lost[hostname]
+	if !ok {
+		return true
+	}
+	if lost.slaveId == slaveId && time.Since(lost.lost) < s.quarantine {
+		return false
+	}
+	delete(s.lost, hostname)
+	return true
+}
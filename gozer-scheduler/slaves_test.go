@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlaveTracker(t *testing.T) {
+	slaves := newSlaveTracker(time.Hour)
+	slaves.offered("slave-1", "host-1")
+	slaves.slaveLost("slave-1")
+	slaves.slaveLost("slave-2")
+
+	if slaves.available("slave-1", "host-1") {
+		t.Error("placed a task on a lost slave")
+	}
+	if !slaves.available("slave-2", "host-2") {
+		t.Error("a slave we never used was quarantined")
+	}
+
+	// The slave is back once it is offered under a new id.
+	if !slaves.available("slave-3", "host-1") {
+		t.Error("a slave that came back is still quarantined")
+	}
+	if !slaves.available("slave-1", "host-1") {
+		t.Error("a slave that came back is quarantined again")
+	}
+
+	// Slaves that do not come back are forgotten after the quarantine.
+	slaves = newSlaveTracker(0)
+	slaves.offered("slave-1", "host-1")
+	slaves.slaveLost("slave-1")
+	if !slaves.available("slave-1", "host-1") || len(slaves.lost) != 0 {
+		t.Error("a lost slave was kept after its quarantine")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"time"
+
+	"github.com/twitter/gozer/gozer"
+)
+
+// Store persists the tasks we manage and the framework id they were launched under,
+// so that they survive the scheduler restarting. Tasks in the history are stored
+// alongside them, marked completed.
+type Store interface {
+	// Load returns everything stored, with the tasks in the order they were first
+	// stored.
+	Load() (frameworkId string, tasks []*TaskRecord, err error)
+
+	// PutTask stores a task, replacing any earlier record of it. It returns once the
+	// record is durable.
+	PutTask(task *TaskRecord) error
+	// DeleteTask forgets a task. Deleting a task that is not stored is not an error.
+	DeleteTask(taskId string) error
+	PutFrameworkId(frameworkId string) error
+
+	Close() error
+}
+
+// TaskRecord is what is stored of a task.
+type TaskRecord struct {
+	Task gozer.Task `json:"task"`
+	// The slave the task was last launched on, if it is launched.
+	SlaveId string `json:"slave_id,omitempty"`
+	// The order the task was submitted in.
+	Sequence uint64 `json:"sequence"`
+	// When the task ended for good, and was moved into the history.
+	Completed *time.Time `json:"completed,omitempty"`
+}
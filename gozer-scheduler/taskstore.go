@@ -1,8 +1,14 @@
 package main
 
 import (
+	"container/heap"
 	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/twitter/gozer/gozer"
 	"github.com/twitter/gozer/mesos"
@@ -11,64 +17,533 @@ import (
 type Task struct {
 	gozerTask *gozer.Task
 	mesosTask *mesos.MesosTask
+	// The slave the task was last launched on.
+	slaveId string
+
+	// The order the task was submitted in, which breaks ties between pending tasks
+	// of the same priority.
+	sequence uint64
+	// The task's position in the pending queue, or -1 if it is not pending.
+	index int
+	// The task's position in the retry queue, or -1 if it is not waiting to be
+	// retried.
+	retryIndex int
 }
 
+// TaskStore holds the tasks we manage, indexed so that handling an offer or an update
+// does not depend on the number of tasks: tasks are found by id, state and slave, and
+// pending tasks are kept in launch order. Tasks waiting to be retried are kept apart,
+// in the order they may be launched again.
+//
+// Updates from Mesos name a task by the id of its current attempt, which for the
+// first attempt is the task's own id.
+//
+// Tasks that have ended for good are moved into a history, which is kept apart from
+// the tasks we manage.
+//
+// Once a Store is recovered, every change to a task is written to it before it is
+// made, so that a task is never further along than the store knows.
 type TaskStore struct {
 	sync.RWMutex
 	tasks map[string]*Task
+	store Store
+
+	byMesosId map[string]*Task
+	byState   map[gozer.TaskState]map[string]*Task
+	bySlave   map[string]map[string]*Task
+	pending   pendingQueue
+	retrying  retryQueue
+	history   taskHistory
+
+	sequence uint64
+
+	// The time, and a random number in [0, 1) for the jitter of retries.
+	now    func() time.Time
+	random func() float64
+}
+
+// TaskExistsError is returned for a task added with the id of a task we hold.
+type TaskExistsError struct {
+	TaskId string
+}
+
+func (e *TaskExistsError) Error() string {
+	return fmt.Sprintf("task Id %q already exists; addition ignored", e.TaskId)
+}
+
+// TaskNotFoundError is returned for a task we neither hold nor have in the history.
+type TaskNotFoundError struct {
+	TaskId string
+}
+
+func (e *TaskNotFoundError) Error() string {
+	return fmt.Sprintf("task Id %q not found", e.TaskId)
+}
+
+// TaskEndedError is returned for killing a task that has already ended.
+type TaskEndedError struct {
+	TaskId string
+	State  gozer.TaskState
+}
+
+func (e *TaskEndedError) Error() string {
+	return fmt.Sprintf("task Id %q has already ended %s", e.TaskId, e.State)
+}
+
+// StoreError is returned when a change to a task could not be written to the store.
+// The change was not made.
+type StoreError struct {
+	TaskId string
+	Err    error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("failed to store task %q: %+v", e.TaskId, e.Err)
 }
 
 func NewTaskStore() *TaskStore {
 	return &TaskStore{
-		tasks: make(map[string]*Task),
+		tasks:     make(map[string]*Task),
+		byMesosId: make(map[string]*Task),
+		byState:   make(map[gozer.TaskState]map[string]*Task),
+		bySlave:   make(map[string]map[string]*Task),
+		history:   taskHistory{size: *historySize, age: *historyAge},
+		now:       time.Now,
+		random:    rand.Float64,
 	}
 }
 
+// LimitHistory sets how many completed tasks the history holds, and for how long.
+// Tasks beyond the limits are dropped as the next one completes.
+func (t *TaskStore) LimitHistory(size int, age time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.history.size, t.history.age = size, age
+}
+
 func (t *TaskStore) Add(task *Task) error {
 	t.Lock()
 	defer t.Unlock()
 
+	if err := validateId(task.gozerTask.Id); err != nil {
+		return err
+	}
 	if _, ok := t.tasks[task.gozerTask.Id]; ok {
-		return fmt.Errorf("task Id %q already exists; addition ignored", task.gozerTask.Id)
+		return &TaskExistsError{TaskId: task.gozerTask.Id}
+	}
+	if retry := task.gozerTask.Retry; retry != nil {
+		if err := retry.Validate(); err != nil {
+			return fmt.Errorf("task Id %q has an invalid retry policy: %+v", task.gozerTask.Id, err)
+		}
 	}
 
-	task.gozerTask.State = gozer.TaskState_INIT
-	task.mesosTask = &mesos.MesosTask{
-		Id:      task.gozerTask.Id,
-		Command: task.gozerTask.Command,
+	// A task that is submitted again replaces its completed run in the history,
+	// which is stored under the same id.
+	if t.history.remove(task.gozerTask.Id) {
+		log.Info.Printf("Task %q submitted again; dropping it from the history", task.gozerTask.Id)
 	}
+
+	// Whatever state the task was submitted with, it starts from scratch.
+	task.gozerTask.State = ""
+	task.gozerTask.Transitions = nil
+	task.gozerTask.Attempts = nil
+	task.gozerTask.RetryAt = nil
+	task.sequence = t.sequence
+	task.index = -1
+	task.retryIndex = -1
+	t.sequence++
+
+	defaultResources(task.gozerTask)
+	task.mesosTask = newMesosTask(task.gozerTask)
 	t.tasks[task.gozerTask.Id] = task
-	log.Debug.Printf("TASK %q State * -> %s", task.gozerTask.Id, task.gozerTask.State)
+	t.byMesosId[task.mesosTask.Id] = task
+
+	for _, state := range []gozer.TaskState{gozer.TaskState_INIT, gozer.TaskState_PENDING} {
+		if err := t.transition(task, state, ""); err != nil {
+			t.remove(task)
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (t *TaskStore) Update(taskId string, state gozer.TaskState) error {
+// Recover restores tasks loaded from store, replacing any we hold, and from then on
+// writes every change to it. Tasks that were between states when they were stored
+// are moved on: those not yet queued, or lost, are queued, and those that ended are
+// moved into the history unless they are to be retried.
+func (t *TaskStore) Recover(store Store, records []*TaskRecord) error {
 	t.Lock()
 	defer t.Unlock()
 
-	task, ok := t.tasks[taskId]
+	t.store = store
+	if err := t.load(records); err != nil {
+		return err
+	}
+
+	for _, task := range t.tasks {
+		var err error
+		switch task.gozerTask.State {
+		case gozer.TaskState_INIT, gozer.TaskState_LOST:
+			err = t.transition(task, gozer.TaskState_PENDING, "")
+		default:
+			if task.gozerTask.IsTerminal() {
+				err = t.ended(task, false)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := t.trimHistory(); err != nil {
+		return err
+	}
+
+	log.Info.Printf("Recovered %d tasks, and %d in the history", len(t.tasks), len(t.history.records))
+	return nil
+}
+
+// Reload replaces the tasks we hold with records, as they are, and writes nothing. A
+// standby scheduler keeps up with what the leader stores this way.
+func (t *TaskStore) Reload(records []*TaskRecord) error {
+	t.Lock()
+	defer t.Unlock()
+
+	t.store = nil
+	return t.load(records)
+}
+
+// load replaces the tasks we hold with records. It must be called with the lock
+// held.
+func (t *TaskStore) load(records []*TaskRecord) error {
+	t.tasks = make(map[string]*Task)
+	t.byMesosId = make(map[string]*Task)
+	t.byState = make(map[gozer.TaskState]map[string]*Task)
+	t.bySlave = make(map[string]map[string]*Task)
+	t.pending = nil
+	t.retrying = nil
+	t.sequence = 0
+
+	var history []*TaskRecord
+	for _, record := range records {
+		if _, ok := t.tasks[record.Task.Id]; ok {
+			return fmt.Errorf("task Id %q recovered twice", record.Task.Id)
+		}
+		if record.Sequence >= t.sequence {
+			t.sequence = record.Sequence + 1
+		}
+		if record.Completed != nil {
+			history = append(history, record)
+			continue
+		}
+
+		gozerTask := record.Task
+		// Tasks stored before they had resources are given the defaults.
+		defaultResources(&gozerTask)
+		task := &Task{
+			gozerTask:  &gozerTask,
+			mesosTask:  newMesosTask(&gozerTask),
+			sequence:   record.Sequence,
+			index:      -1,
+			retryIndex: -1,
+		}
+		if other, ok := t.byMesosId[task.mesosTask.Id]; ok {
+			return fmt.Errorf("tasks %q and %q recovered with the same Mesos id %q", other.gozerTask.Id, gozerTask.Id, task.mesosTask.Id)
+		}
+		t.tasks[gozerTask.Id] = task
+		t.byMesosId[task.mesosTask.Id] = task
+		t.index(task)
+		t.assign(task, record.SlaveId)
+	}
+	t.history.load(history)
+	return nil
+}
+
+// record returns what is stored of task.
+func (t *TaskStore) record(task *Task) *TaskRecord {
+	record := &TaskRecord{
+		Task:     *task.gozerTask,
+		SlaveId:  task.slaveId,
+		Sequence: task.sequence,
+	}
+	record.Task.Transitions = append([]gozer.Transition(nil), task.gozerTask.Transitions...)
+	record.Task.Attempts = append([]gozer.Attempt(nil), task.gozerTask.Attempts...)
+	return record
+}
+
+// transition moves task to the given state and slave, if its lifecycle allows it,
+// and keeps the indexes up to date. It must be called with the lock held.
+func (t *TaskStore) transition(task *Task, state gozer.TaskState, slaveId string) error {
+	return t.transitionWith(task, state, slaveId, nil)
+}
+
+// transitionWith is transition, which also makes change to the task as it moves. It
+// must be called with the lock held.
+func (t *TaskStore) transitionWith(task *Task, state gozer.TaskState, slaveId string, change func(*gozer.Task)) error {
+	from := task.gozerTask.State
+	record := t.record(task)
+	if err := record.Task.Transition(state); err != nil {
+		return err
+	}
+	if from == state {
+		return nil
+	}
+
+	record.SlaveId = slaveId
+	if change != nil {
+		change(&record.Task)
+	}
+	if err := t.checkMesosId(task, mesosId(&record.Task)); err != nil {
+		return err
+	}
+	if t.store != nil {
+		if err := t.store.PutTask(record); err != nil {
+			return &StoreError{TaskId: task.gozerTask.Id, Err: err}
+		}
+	}
+
+	t.unindex(task)
+	*task.gozerTask = record.Task
+	t.index(task)
+	t.assign(task, slaveId)
+	if err := t.rename(task); err != nil {
+		return err
+	}
+
+	if from == "" {
+		from = "*"
+	}
+	log.Debug.Printf("TASK %q State %s -> %s", task.gozerTask.Id, from, state)
+	return nil
+}
+
+// index adds task to the indexes for its state. It must be called with the lock
+// held.
+func (t *TaskStore) index(task *Task) {
+	state := task.gozerTask.State
+	tasks, ok := t.byState[state]
+	if !ok {
+		tasks = make(map[string]*Task)
+		t.byState[state] = tasks
+	}
+	tasks[task.gozerTask.Id] = task
+	if state != gozer.TaskState_PENDING {
+		return
+	}
+	if retryAt := task.gozerTask.RetryAt; retryAt != nil && retryAt.After(t.now()) {
+		heap.Push(&t.retrying, task)
+	} else {
+		heap.Push(&t.pending, task)
+	}
+}
+
+// unindex removes task from the indexes for its state. It must be called with the
+// lock held.
+func (t *TaskStore) unindex(task *Task) {
+	if task.index >= 0 {
+		heap.Remove(&t.pending, task.index)
+	}
+	if task.retryIndex >= 0 {
+		heap.Remove(&t.retrying, task.retryIndex)
+	}
+	delete(t.byState[task.gozerTask.State], task.gozerTask.Id)
+}
+
+// assign records the slave a task is on, or clears it if slaveId is empty. It must
+// be called with the lock held.
+func (t *TaskStore) assign(task *Task, slaveId string) {
+	if slaveId == task.slaveId {
+		return
+	}
+
+	if task.slaveId != "" {
+		delete(t.bySlave[task.slaveId], task.gozerTask.Id)
+		if len(t.bySlave[task.slaveId]) == 0 {
+			delete(t.bySlave, task.slaveId)
+		}
+	}
+
+	task.slaveId = slaveId
+	if slaveId == "" {
+		return
+	}
+
+	tasks, ok := t.bySlave[slaveId]
+	if !ok {
+		tasks = make(map[string]*Task)
+		t.bySlave[slaveId] = tasks
+	}
+	tasks[task.gozerTask.Id] = task
+}
+
+// rename gives task the Mesos id of its current attempt, unless another task has it.
+// It must be called with the lock held.
+func (t *TaskStore) rename(task *Task) error {
+	id := mesosId(task.gozerTask)
+	if id == task.mesosTask.Id {
+		return nil
+	}
+	if err := t.checkMesosId(task, id); err != nil {
+		return err
+	}
+	delete(t.byMesosId, task.mesosTask.Id)
+	// The old attempt's task is left as it was, for whoever launched it.
+	task.mesosTask = newMesosTask(task.gozerTask)
+	t.byMesosId[id] = task
+	return nil
+}
+
+// checkMesosId returns an error if a task other than task has the Mesos id id. It
+// must be called with the lock held.
+func (t *TaskStore) checkMesosId(task *Task, id string) error {
+	if other, ok := t.byMesosId[id]; ok && other != task {
+		return fmt.Errorf("task %q can not take Mesos id %q from task %q", task.gozerTask.Id, id, other.gozerTask.Id)
+	}
+	return nil
+}
+
+// remove drops a task and its index entries. The task is dropped even if deleting it
+// from the store fails, as it is dropped again when the store is recovered. It must
+// be called with the lock held.
+func (t *TaskStore) remove(task *Task) error {
+	t.drop(task)
+	log.Debug.Printf("TASK %q removed", task.gozerTask.Id)
+
+	if t.store != nil {
+		if err := t.store.DeleteTask(task.gozerTask.Id); err != nil {
+			return fmt.Errorf("failed to delete task %q from store: %+v", task.gozerTask.Id, err)
+		}
+	}
+	return nil
+}
+
+// complete moves a task that has ended for good into the history, and drops the
+// tasks the history no longer has room for. As with remove, the task is moved even
+// if storing it fails, as it is moved again when the store is recovered. It must be
+// called with the lock held.
+func (t *TaskStore) complete(task *Task) error {
+	record := t.record(task)
+	completed := t.now()
+	record.Completed = &completed
+
+	t.drop(task)
+	t.history.add(record)
+	log.Debug.Printf("TASK %q completed", task.gozerTask.Id)
+
+	if t.store != nil {
+		if err := t.store.PutTask(record); err != nil {
+			return fmt.Errorf("failed to store completed task %q: %+v", task.gozerTask.Id, err)
+		}
+	}
+	return t.trimHistory()
+}
+
+// drop forgets a task and its index entries. It must be called with the lock held.
+func (t *TaskStore) drop(task *Task) {
+	t.unindex(task)
+	t.assign(task, "")
+	delete(t.tasks, task.gozerTask.Id)
+	delete(t.byMesosId, task.mesosTask.Id)
+}
+
+// trimHistory drops the tasks in the history beyond its limits, from the store too.
+// It must be called with the lock held.
+func (t *TaskStore) trimHistory() error {
+	for _, record := range t.history.trim(t.now()) {
+		log.Debug.Printf("TASK %q dropped from the history", record.Task.Id)
+		if t.store != nil {
+			if err := t.store.DeleteTask(record.Task.Id); err != nil {
+				return fmt.Errorf("failed to delete task %q from store: %+v", record.Task.Id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Update moves a task to the state Mesos reports for it, with the message Mesos
+// explains it with. A task that ends is queued to be retried if its retry policy says
+// so, and is otherwise moved into the history.
+func (t *TaskStore) Update(taskId string, state gozer.TaskState, message string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	task, ok := t.byMesosId[taskId]
 	if !ok {
 		return fmt.Errorf("task Id %q not found, update ignored", taskId)
 	}
 
-	log.Debug.Printf("TASK %q State %s -> %s", taskId, task.gozerTask.State, state)
-	task.gozerTask.State = state
+	killing := task.gozerTask.State == gozer.TaskState_KILLING
+	err := t.transitionWith(task, state, task.slaveId, func(gozerTask *gozer.Task) {
+		if gozerTask.IsTerminal() {
+			t.endAttempt(gozerTask, message)
+		}
+	})
+	if err != nil {
+		return err
+	}
 
 	if task.gozerTask.IsTerminal() {
-		log.Info.Printf("Removing terminal task %q", taskId)
-		delete(t.tasks, taskId)
-		log.Debug.Printf("TASK %q removed", taskId)
+		return t.ended(task, killing)
 	}
 
 	return nil
 }
 
+// exitStatus matches the message the command executor ends a task with.
+var exitStatus = regexp.MustCompile(`exited with status (-?\d+)`)
+
+// endAttempt records how the current attempt of a task ended.
+func (t *TaskStore) endAttempt(task *gozer.Task, message string) {
+	if len(task.Attempts) == 0 {
+		return
+	}
+	attempt := &task.Attempts[len(task.Attempts)-1]
+	now := t.now()
+	attempt.Ended = &now
+	attempt.Message = message
+	if match := exitStatus.FindStringSubmatch(message); match != nil {
+		var code int
+		if _, err := fmt.Sscan(match[1], &code); err == nil {
+			attempt.ExitCode = &code
+		}
+	}
+}
+
+// ended queues a task that has ended to be retried, if its policy says so and it was
+// not being killed, and otherwise moves it into the history. It must be called with
+// the lock held.
+func (t *TaskStore) ended(task *Task, killing bool) error {
+	gozerTask := task.gozerTask
+	retry := gozerTask.Retry
+	if killing || retry == nil {
+		log.Info.Printf("Task %q ended %s", gozerTask.Id, gozerTask.State)
+		return t.complete(task)
+	}
+
+	attempts := len(gozerTask.Attempts)
+	var exitCode *int
+	if attempts > 0 {
+		exitCode = gozerTask.Attempts[attempts-1].ExitCode
+	}
+	if !retry.Retryable(gozerTask.State, attempts, exitCode) {
+		log.Info.Printf("Task %q ended %s after %d of %d attempts", gozerTask.Id, gozerTask.State, attempts, retry.MaxAttempts)
+		return t.complete(task)
+	}
+
+	backoff := retry.Backoff(attempts, t.random())
+	log.Info.Printf("Retrying %s task %q in %s, after %d of %d attempts", gozerTask.State, gozerTask.Id, backoff, attempts, retry.MaxAttempts)
+	return t.transitionWith(task, gozer.TaskState_PENDING, "", func(gozerTask *gozer.Task) {
+		retryAt := t.now().Add(backoff)
+		gozerTask.RetryAt = &retryAt
+	})
+}
+
 func (t *TaskStore) Ids() []string {
 	t.RLock()
 	defer t.RUnlock()
 
-	keys := make([]string, 0)
+	keys := make([]string, 0, len(t.tasks))
 	for key := range t.tasks {
 		keys = append(keys, key)
 	}
@@ -80,7 +555,7 @@ func (t *TaskStore) State(taskId string) (gozer.TaskState, error) {
 	t.RLock()
 	defer t.RUnlock()
 
-	task, ok := t.tasks[taskId]
+	task, ok := t.byMesosId[taskId]
 	if !ok {
 		return "", fmt.Errorf("task Id %q not found", taskId)
 	}
@@ -99,3 +574,385 @@ func (t *TaskStore) MesosTask(taskId string) (*mesos.MesosTask, error) {
 
 	return task.mesosTask, nil
 }
+
+// Count returns the number of tasks in the given state.
+func (t *TaskStore) Count(state gozer.TaskState) int {
+	t.RLock()
+	defer t.RUnlock()
+
+	return len(t.byState[state])
+}
+
+// NextPending returns the pending task that should be launched next: the one with
+// the highest priority, and of those the one submitted first. Tasks waiting to be
+// retried are not pending until their backoff is over. It returns false if no task
+// is pending.
+func (t *TaskStore) NextPending() (*mesos.MesosTask, bool) {
+	return t.NextPendingFitting(func(*mesos.MesosTask) bool { return true })
+}
+
+// NextPendingFitting is NextPending, among the tasks fits accepts, so that a task no
+// offer is big enough for does not hold up the tasks behind it.
+func (t *TaskStore) NextPendingFitting(fits func(*mesos.MesosTask) bool) (*mesos.MesosTask, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.retried()
+	if len(t.pending) == 0 {
+		return nil, false
+	}
+	if fits(t.pending[0].mesosTask) {
+		return t.pending[0].mesosTask, true
+	}
+
+	// The heap is only ordered at its head, so the rest are sorted on a copy.
+	queue := append(pendingQueue(nil), t.pending[1:]...)
+	sort.Slice(queue, queue.Less)
+	for _, task := range queue {
+		if fits(task.mesosTask) {
+			return task.mesosTask, true
+		}
+	}
+	return nil, false
+}
+
+// Pending returns the tasks waiting to be launched, in no particular order. Tasks
+// waiting to be retried are not pending until their backoff is over.
+func (t *TaskStore) Pending() []*mesos.MesosTask {
+	t.Lock()
+	defer t.Unlock()
+
+	t.retried()
+	tasks := make([]*mesos.MesosTask, len(t.pending))
+	for i, task := range t.pending {
+		tasks[i] = task.mesosTask
+	}
+	return tasks
+}
+
+// retried moves the tasks whose backoff is over from the retrying tasks to the
+// pending ones. It must be called with the lock held.
+func (t *TaskStore) retried() {
+	now := t.now()
+	for len(t.retrying) > 0 && !t.retrying[0].gozerTask.RetryAt.After(now) {
+		heap.Push(&t.pending, heap.Pop(&t.retrying))
+	}
+}
+
+// Launched records that the task is being launched on the given slave, as a new
+// attempt. It is called before Mesos is asked to launch the task, so that a task
+// killed from then on is killed in Mesos too.
+func (t *TaskStore) Launched(taskId, slaveId string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	task, ok := t.byMesosId[taskId]
+	if !ok {
+		return fmt.Errorf("task Id %q not found", taskId)
+	}
+
+	return t.transitionWith(task, gozer.TaskState_ASSIGNED, slaveId, func(gozerTask *gozer.Task) {
+		gozerTask.Attempts = append(gozerTask.Attempts, gozer.Attempt{
+			MesosId:  taskId,
+			SlaveId:  slaveId,
+			State:    gozer.TaskState_ASSIGNED,
+			Launched: t.now(),
+		})
+		gozerTask.RetryAt = nil
+	})
+}
+
+// LaunchFailed undoes Launched for a task Mesos could not be asked to launch. The
+// attempt is dropped, and the task queued behind the other pending tasks of its
+// priority, or moved into the history if it was killed in the meantime.
+func (t *TaskStore) LaunchFailed(taskId string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	task, ok := t.byMesosId[taskId]
+	if !ok {
+		return fmt.Errorf("task Id %q not found, failed launch ignored", taskId)
+	}
+	dropAttempt := func(gozerTask *gozer.Task) {
+		gozerTask.Attempts = gozerTask.Attempts[:len(gozerTask.Attempts)-1]
+	}
+
+	if task.gozerTask.State == gozer.TaskState_KILLING {
+		if err := t.transitionWith(task, gozer.TaskState_KILLED, "", dropAttempt); err != nil {
+			return err
+		}
+		log.Info.Printf("Task %q killed before it was launched", task.gozerTask.Id)
+		return t.complete(task)
+	}
+	if err := t.transitionWith(task, gozer.TaskState_PENDING, "", dropAttempt); err != nil {
+		return err
+	}
+	return t.requeue(task)
+}
+
+// requeue moves a pending task behind the other pending tasks of its priority, so
+// that a task that could not be launched does not hold up the rest. It must be called
+// with the lock held.
+func (t *TaskStore) requeue(task *Task) error {
+	if task.index < 0 {
+		return fmt.Errorf("task Id %q is not pending, requeue ignored", task.mesosTask.Id)
+	}
+
+	record := t.record(task)
+	record.Sequence = t.sequence
+	if t.store != nil {
+		if err := t.store.PutTask(record); err != nil {
+			return &StoreError{TaskId: task.gozerTask.Id, Err: err}
+		}
+	}
+
+	task.sequence = t.sequence
+	t.sequence++
+	heap.Fix(&t.pending, task.index)
+	return nil
+}
+
+// OnSlave returns the Mesos ids of the tasks launched on the given slave.
+func (t *TaskStore) OnSlave(slaveId string) []string {
+	t.RLock()
+	defer t.RUnlock()
+
+	keys := make([]string, 0, len(t.bySlave[slaveId]))
+	for _, task := range t.bySlave[slaveId] {
+		keys = append(keys, task.mesosTask.Id)
+	}
+
+	return keys
+}
+
+// Lost marks a launched task as LOST, with the given message, and queues it to be
+// launched again if its retry policy says so, as for a task that failed. A task that
+// was being killed, or that has run out of attempts, is moved into the history.
+func (t *TaskStore) Lost(taskId, message string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	task, ok := t.byMesosId[taskId]
+	if !ok {
+		return fmt.Errorf("task Id %q not found, loss ignored", taskId)
+	}
+
+	killing := task.gozerTask.State == gozer.TaskState_KILLING
+	err := t.transitionWith(task, gozer.TaskState_LOST, "", func(gozerTask *gozer.Task) {
+		t.endAttempt(gozerTask, message)
+	})
+	if err != nil {
+		return err
+	}
+	return t.ended(task, killing)
+}
+
+// History returns the tasks that have ended for good, most recent first. With any
+// states given, only the tasks that ended in one of them are returned.
+func (t *TaskStore) History(states ...gozer.TaskState) []gozer.CompletedTask {
+	t.RLock()
+	defer t.RUnlock()
+
+	tasks := []gozer.CompletedTask{}
+	for _, record := range t.history.list(t.now()) {
+		if len(states) > 0 && !inStates(record.Task.State, states) {
+			continue
+		}
+		tasks = append(tasks, completedTask(record))
+	}
+	return tasks
+}
+
+func inStates(state gozer.TaskState, states []gozer.TaskState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns what is stored of a task we hold or have in the history.
+func (t *TaskStore) Get(taskId string) (*TaskRecord, error) {
+	t.RLock()
+	defer t.RUnlock()
+
+	if task, ok := t.tasks[taskId]; ok {
+		return t.record(task), nil
+	}
+	if record := t.history.get(taskId, t.now()); record != nil {
+		return record, nil
+	}
+	return nil, &TaskNotFoundError{TaskId: taskId}
+}
+
+// All returns what is stored of the tasks we hold and those in the history, in the
+// order they were submitted.
+func (t *TaskStore) All() []*TaskRecord {
+	t.RLock()
+	defer t.RUnlock()
+
+	records := t.history.list(t.now())
+	for _, task := range t.tasks {
+		records = append(records, t.record(task))
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Sequence < records[j].Sequence })
+	return records
+}
+
+// Kill kills a task. A task that has not been launched is killed at once, and moved
+// into the history. A launched task is marked KILLING until Mesos reports it killed;
+// Kill returns its Mesos id, for Mesos to be asked to kill it.
+func (t *TaskStore) Kill(taskId string) (string, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	task, ok := t.tasks[taskId]
+	if !ok {
+		if record := t.history.get(taskId, t.now()); record != nil {
+			return "", &TaskEndedError{TaskId: taskId, State: record.Task.State}
+		}
+		return "", &TaskNotFoundError{TaskId: taskId}
+	}
+
+	switch task.gozerTask.State {
+	case gozer.TaskState_INIT, gozer.TaskState_PENDING:
+		if err := t.transition(task, gozer.TaskState_KILLED, ""); err != nil {
+			return "", err
+		}
+		log.Info.Printf("Task %q killed before it was launched", taskId)
+		return "", t.complete(task)
+	default:
+		if err := t.transition(task, gozer.TaskState_KILLING, task.slaveId); err != nil {
+			return "", err
+		}
+		return task.mesosTask.Id, nil
+	}
+}
+
+// InState returns what is stored of the tasks in any of the given states.
+func (t *TaskStore) InState(states ...gozer.TaskState) []*TaskRecord {
+	t.RLock()
+	defer t.RUnlock()
+
+	var records []*TaskRecord
+	for _, state := range states {
+		for _, task := range t.byState[state] {
+			records = append(records, t.record(task))
+		}
+	}
+
+	return records
+}
+
+// pendingQueue is a heap of pending tasks, in the order they should be launched.
+type pendingQueue []*Task
+
+func (q pendingQueue) Len() int { return len(q) }
+
+func (q pendingQueue) Less(i, j int) bool {
+	if q[i].gozerTask.Priority != q[j].gozerTask.Priority {
+		return q[i].gozerTask.Priority > q[j].gozerTask.Priority
+	}
+	return q[i].sequence < q[j].sequence
+}
+
+func (q pendingQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *pendingQueue) Push(x interface{}) {
+	task := x.(*Task)
+	task.index = len(*q)
+	*q = append(*q, task)
+}
+
+func (q *pendingQueue) Pop() interface{} {
+	old := *q
+	task := old[len(old)-1]
+	old[len(old)-1] = nil
+	task.index = -1
+	*q = old[:len(old)-1]
+	return task
+}
+
+// retryQueue is a heap of tasks waiting to be retried, in the order they may be
+// launched again.
+type retryQueue []*Task
+
+func (q retryQueue) Len() int { return len(q) }
+
+func (q retryQueue) Less(i, j int) bool {
+	return q[i].gozerTask.RetryAt.Before(*q[j].gozerTask.RetryAt)
+}
+
+func (q retryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].retryIndex = i
+	q[j].retryIndex = j
+}
+
+func (q *retryQueue) Push(x interface{}) {
+	task := x.(*Task)
+	task.retryIndex = len(*q)
+	*q = append(*q, task)
+}
+
+func (q *retryQueue) Pop() interface{} {
+	old := *q
+	task := old[len(old)-1]
+	old[len(old)-1] = nil
+	task.retryIndex = -1
+	*q = old[:len(old)-1]
+	return task
+}
+
+// defaultResources gives a task the default resources for those it does not ask for.
+func defaultResources(task *gozer.Task) {
+	if task.Cpus == 0 {
+		task.Cpus = gozer.DefaultCpus
+	}
+	if task.Mem == 0 {
+		task.Mem = gozer.DefaultMem
+	}
+}
+
+// newMesosTask returns the Mesos task for the current attempt of task.
+func newMesosTask(task *gozer.Task) *mesos.MesosTask {
+	return &mesos.MesosTask{
+		Id:      mesosId(task),
+		Command: task.Command,
+		Cpus:    task.Cpus,
+		Mem:     task.Mem,
+	}
+}
+
+// attemptSeparator separates a task's id from the number of the attempt in the Mesos
+// ids of its attempts. Task ids may not contain it, so that no task has the id of an
+// attempt of another.
+const attemptSeparator = "#"
+
+// validateId returns an error if a task may not have the id id.
+func validateId(id string) error {
+	if strings.Contains(id, attemptSeparator) {
+		return fmt.Errorf("task Id %q contains %q, which is reserved for the ids of attempts", id, attemptSeparator)
+	}
+	return nil
+}
+
+// mesosId returns the Mesos id of a task's current attempt: the one it is in, or is
+// to be launched as next if it is not in one. The first attempt has the task's own id,
+// as tasks did before they were retried.
+func mesosId(task *gozer.Task) string {
+	attempt := len(task.Attempts)
+	switch task.State {
+	case "", gozer.TaskState_INIT, gozer.TaskState_PENDING:
+		attempt++
+	}
+	if attempt <= 1 {
+		return task.Id
+	}
+	return fmt.Sprintf("%s%s%d", task.Id, attemptSeparator, attempt)
+}
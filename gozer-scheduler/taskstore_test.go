@@ -0,0 +1,545 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/twitter/gozer/gozer"
+	"github.com/twitter/gozer/mesos"
+)
+
+func addTask(t testing.TB, store *TaskStore, id string, priority int) {
+	if err := store.Add(&Task{gozerTask: &gozer.Task{Id: id, Command: "true", Priority: priority}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addRetriedTask adds a task that is retried at once, up to maxAttempts times.
+func addRetriedTask(t testing.TB, store *TaskStore, id string, priority, maxAttempts int) {
+	retry := &gozer.RetryPolicy{MaxAttempts: maxAttempts}
+	if err := store.Add(&Task{gozerTask: &gozer.Task{Id: id, Command: "true", Priority: priority, Retry: retry}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPendingOrder(t *testing.T) {
+	store := NewTaskStore()
+	addRetriedTask(t, store, "low-1", 0, 2)
+	addTask(t, store, "high-1", 10)
+	addTask(t, store, "low-2", 0)
+	addTask(t, store, "high-2", 10)
+
+	for _, want := range []string{"high-1", "high-2", "low-1", "low-2"} {
+		task, ok := store.NextPending()
+		if !ok || task.Id != want {
+			t.Fatalf("got next pending %v, want %q", task, want)
+		}
+		if err := store.Launched(task.Id, "slave-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if task, ok := store.NextPending(); ok {
+		t.Errorf("got next pending %q, want none", task.Id)
+	}
+
+	// A lost task goes back to where it was in the queue, as its second attempt.
+	addTask(t, store, "low-3", 0)
+	if err := store.Lost("low-1", "Slave slave-1 removed"); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := store.NextPending(); task.Id != "low-1#2" {
+		t.Errorf("got next pending %q, want %q", task.Id, "low-1#2")
+	}
+	if record, _ := store.Get("low-1"); record.Task.Attempts[0].Message != "Slave slave-1 removed" {
+		t.Errorf("got attempts %+v, want the first lost with its message", record.Task.Attempts)
+	}
+	if got := store.OnSlave("slave-1"); len(got) != 3 {
+		t.Errorf("OnSlave: got %v, want the three tasks that were not lost", got)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	store := NewTaskStore()
+	addTask(t, store, "high-1", 10)
+	addTask(t, store, "high-2", 10)
+	addTask(t, store, "low-1", 0)
+
+	// A task that can not be launched goes behind the others of its priority only.
+	if err := store.Launched("high-1", "slave-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LaunchFailed("high-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"high-2", "high-1", "low-1"} {
+		task, ok := store.NextPending()
+		if !ok || task.Id != want {
+			t.Fatalf("got next pending %v, want %q", task, want)
+		}
+		if err := store.Launched(task.Id, "slave-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNextPendingFitting(t *testing.T) {
+	store := NewTaskStore()
+	for _, task := range []*gozer.Task{
+		{Id: "oversized", Command: "true", Priority: 10, Cpus: 64},
+		{Id: "small-low", Command: "true", Cpus: 1},
+		{Id: "small-high", Command: "true", Priority: 5, Cpus: 1},
+	} {
+		if err := store.Add(&Task{gozerTask: task}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A task no offer is big enough for does not hold up the others, which are
+	// still taken in order.
+	fits := func(task *mesos.MesosTask) bool { return task.Cpus <= 4 }
+	for _, want := range []string{"small-high", "small-low"} {
+		task, ok := store.NextPendingFitting(fits)
+		if !ok || task.Id != want {
+			t.Fatalf("got next fitting %v, want %q", task, want)
+		}
+		if err := store.Launched(task.Id, "slave-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if task, ok := store.NextPendingFitting(fits); ok {
+		t.Errorf("got next fitting %v, want none", task)
+	}
+	if task, ok := store.NextPending(); !ok || task.Id != "oversized" {
+		t.Errorf("got next pending %v, want oversized", task)
+	}
+}
+
+func TestLaunchFailed(t *testing.T) {
+	store := NewTaskStore()
+	addTask(t, store, "task-1", 0)
+	addTask(t, store, "task-2", 0)
+
+	// A task Mesos was not given goes back behind the others, with no attempt.
+	if err := store.Launched("task-1", "slave-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LaunchFailed("task-1"); err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.Get("task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Task.State != gozer.TaskState_PENDING || len(record.Task.Attempts) != 0 || record.SlaveId != "" {
+		t.Errorf("got %+v after a failed launch, want it pending with no attempts", record)
+	}
+	if task, ok := store.NextPending(); !ok || task.Id != "task-2" {
+		t.Fatalf("got next pending %v after a failed launch, want task-2", task)
+	}
+
+	// One killed while it was being launched is killed for good.
+	if err := store.Launched("task-2", "slave-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Kill("task-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LaunchFailed("task-2"); err != nil {
+		t.Fatal(err)
+	}
+	if history := store.History(); len(history) != 1 || history[0].Id != "task-2" || history[0].State != gozer.TaskState_KILLED {
+		t.Errorf("got history %+v, want task-2 killed", history)
+	}
+}
+
+func TestAttemptIds(t *testing.T) {
+	store := NewTaskStore()
+	if err := store.Add(&Task{gozerTask: &gozer.Task{Id: "task#2", Command: "true"}}); err == nil {
+		t.Error("added a task with the id of an attempt")
+	}
+
+	addRetriedTask(t, store, "task", 0, 2)
+	launch(t, store, "task", gozer.TaskState_RUNNING, "")
+	if err := store.Lost("task", ""); err != nil {
+		t.Fatal(err)
+	}
+	if task, ok := store.NextPending(); !ok || task.Id != "task#2" {
+		t.Fatalf("got next pending %v after a loss, want task#2", task)
+	}
+
+	// A task is not given a Mesos id another task has.
+	addRetriedTask(t, store, "other", 10, 2)
+	other := store.byMesosId["other"]
+	store.byMesosId["other#2"] = store.byMesosId["task#2"]
+	launch(t, store, "other", gozer.TaskState_RUNNING, "")
+	if err := store.Lost("other", ""); err == nil {
+		t.Error("gave a task the Mesos id of another")
+	}
+	if store.byMesosId["other"] != other || store.byMesosId["other#2"] == other {
+		t.Error("renamed a task over another")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	store := NewTaskStore()
+	for i := 0; i < 3; i++ {
+		addTask(t, store, fmt.Sprintf("task-%d", i), 0)
+	}
+	store.Launched("task-0", "slave-1")
+	store.Launched("task-1", "slave-1")
+	store.Update("task-1", gozer.TaskState_RUNNING, "")
+
+	counts := map[gozer.TaskState]int{
+		gozer.TaskState_PENDING:  1,
+		gozer.TaskState_ASSIGNED: 1,
+		gozer.TaskState_RUNNING:  1,
+	}
+	for state, want := range counts {
+		if got := store.Count(state); got != want {
+			t.Errorf("Count(%s): got %d, want %d", state, got, want)
+		}
+	}
+	if got := store.OnSlave("slave-1"); len(got) != 2 {
+		t.Errorf("OnSlave: got %v, want two tasks", got)
+	}
+
+	// Illegal transitions leave the indexes alone.
+	if err := store.Update("task-2", gozer.TaskState_RUNNING, ""); err == nil {
+		t.Error("pending task was allowed to start running")
+	}
+	if got := store.Count(gozer.TaskState_PENDING); got != 1 {
+		t.Errorf("Count(PENDING) after illegal update: got %d, want 1", got)
+	}
+
+	// Finished tasks are forgotten entirely.
+	if err := store.Update("task-1", gozer.TaskState_FINISHED, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Count(gozer.TaskState_FINISHED); got != 0 {
+		t.Errorf("Count(FINISHED): got %d, want 0", got)
+	}
+	if got := store.OnSlave("slave-1"); len(got) != 1 || got[0] != "task-0" {
+		t.Errorf("OnSlave after finish: got %v, want [task-0]", got)
+	}
+	if _, err := store.State("task-1"); err == nil {
+		t.Error("finished task is still in the store")
+	}
+}
+
+// The scale the scheduler needs to cope with.
+const benchmarkTasks = 100000
+
+// newBenchmarkStore returns a store of benchmarkTasks tasks, half of them running
+// and half pending.
+func newBenchmarkStore(b *testing.B) (*TaskStore, []string) {
+	store := NewTaskStore()
+	ids := make([]string, benchmarkTasks)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("task-%d", i)
+		addTask(b, store, ids[i], i%10)
+	}
+	for _, id := range ids[:benchmarkTasks/2] {
+		store.Launched(id, "slave-"+id)
+		store.Update(id, gozer.TaskState_RUNNING, "")
+	}
+
+	b.ResetTimer()
+	return store, ids
+}
+
+// BenchmarkOfferMatching measures placing the next pending task on an offer. The
+// task is lost straight away, so that the queue does not drain.
+func BenchmarkOfferMatching(b *testing.B) {
+	store, _ := newBenchmarkStore(b)
+
+	for i := 0; i < b.N; i++ {
+		task, ok := store.NextPending()
+		if !ok {
+			b.Fatal("no pending task")
+		}
+		if err := store.Launched(task.Id, "slave-1"); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		store.Lost(task.Id, "")
+		b.StartTimer()
+	}
+}
+
+// BenchmarkUpdate measures routing status updates that move tasks on: each
+// launched task is reported running and then finished, which moves it through the
+// state indexes and into the history.
+func BenchmarkUpdate(b *testing.B) {
+	store, _ := newBenchmarkStore(b)
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		id := fmt.Sprintf("update-%d", i)
+		addTask(b, store, id, 0)
+		if err := store.Launched(id, "slave-1"); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		for _, state := range []gozer.TaskState{gozer.TaskState_RUNNING, gozer.TaskState_FINISHED} {
+			if _, err := store.State(id); err != nil {
+				b.Fatal(err)
+			}
+			if err := store.Update(id, state, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taskstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := openTestStore(t, dir, 100)
+	taskstore := NewTaskStore()
+	if err := taskstore.Recover(store, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, priority := range []int{0, 10, 0, 0} {
+		addTask(t, taskstore, fmt.Sprintf("task-%d", i), priority)
+	}
+	taskstore.Launched("task-1", "slave-1")
+	taskstore.Update("task-1", gozer.TaskState_RUNNING, "")
+	taskstore.Launched("task-0", "slave-2")
+	taskstore.Update("task-0", gozer.TaskState_FINISHED, "")
+	store.Close()
+
+	// A restart finds the tasks as they were left.
+	store = openTestStore(t, dir, 100)
+	defer store.Close()
+	_, records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskstore = NewTaskStore()
+	if err := taskstore.Recover(store, records); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := taskstore.State("task-0"); err == nil {
+		t.Error("finished task was recovered")
+	}
+	if history := taskstore.History(); len(history) != 1 || history[0].Id != "task-0" || history[0].SlaveId != "slave-2" {
+		t.Errorf("got history %+v, want task-0 on slave-2", history)
+	}
+	if state, _ := taskstore.State("task-1"); state != gozer.TaskState_RUNNING {
+		t.Errorf("task-1: got state %s, want %s", state, gozer.TaskState_RUNNING)
+	}
+	if got := taskstore.OnSlave("slave-1"); len(got) != 1 || got[0] != "task-1" {
+		t.Errorf("OnSlave: got %v, want [task-1]", got)
+	}
+	launched := taskstore.InState(gozer.TaskState_RUNNING)
+	if len(launched) != 1 || len(launched[0].Task.Transitions) != 4 {
+		t.Errorf("got running tasks %+v, want task-1 with its four transitions", launched)
+	}
+
+	// Pending tasks keep their place in the queue, ahead of new ones.
+	addTask(t, taskstore, "task-4", 0)
+	for _, want := range []string{"task-2", "task-3", "task-4"} {
+		task, ok := taskstore.NextPending()
+		if !ok || task.Id != want {
+			t.Fatalf("got next pending %v, want %q", task, want)
+		}
+		taskstore.Launched(task.Id, "slave-1")
+	}
+}
+
+// launch launches the next pending task, which should be want, and moves it to the
+// given state.
+func launch(t *testing.T, store *TaskStore, want string, state gozer.TaskState, message string) {
+	task, ok := store.NextPending()
+	if !ok || task.Id != want {
+		t.Fatalf("got next pending %v, want %q", task, want)
+	}
+	if err := store.Launched(task.Id, "slave-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update(task.Id, state, message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	store := NewTaskStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	store.random = func() float64 { return 0.5 }
+
+	retry := &gozer.RetryPolicy{MaxAttempts: 3, BackoffSeconds: 10, Jitter: 0.2, ExitCodes: []int{2}}
+	if err := store.Add(&Task{gozerTask: &gozer.Task{Id: "task", Command: "false", Retry: retry}}); err != nil {
+		t.Fatal(err)
+	}
+	launch(t, store, "task", gozer.TaskState_FAILED, "Command exited with status 2")
+
+	// The task waits out its backoff, and is then launched as its next attempt.
+	if task, ok := store.NextPending(); ok {
+		t.Fatalf("got next pending %q during backoff", task.Id)
+	}
+	if _, err := store.State("task"); err == nil {
+		t.Error("first attempt is still known after the task was retried")
+	}
+	now = now.Add(11 * time.Second)
+	launch(t, store, "task#2", gozer.TaskState_LOST, "")
+
+	now = now.Add(22 * time.Second)
+	if task, ok := store.NextPending(); !ok || task.Id != "task#3" {
+		t.Fatalf("got next pending %v after the second backoff, want task#3", task)
+	}
+	records := store.InState(gozer.TaskState_PENDING)
+	if len(records) != 1 {
+		t.Fatalf("got %d pending tasks, want 1", len(records))
+	}
+	attempts := records[0].Task.Attempts
+	if len(attempts) != 2 {
+		t.Fatalf("got %d attempts, want 2", len(attempts))
+	}
+	if attempts[0].MesosId != "task" || attempts[0].State != gozer.TaskState_FAILED || attempts[0].ExitCode == nil || *attempts[0].ExitCode != 2 {
+		t.Errorf("got first attempt %+v, want task FAILED with exit code 2", attempts[0])
+	}
+	if attempts[1].MesosId != "task#2" || attempts[1].State != gozer.TaskState_LOST || attempts[1].Ended == nil {
+		t.Errorf("got second attempt %+v, want task.2 LOST", attempts[1])
+	}
+
+	// The last attempt is not retried.
+	launch(t, store, "task#3", gozer.TaskState_FAILED, "Command exited with status 2")
+	if _, err := store.State("task#3"); err == nil || store.Count(gozer.TaskState_PENDING) != 0 {
+		t.Error("task was kept after its last attempt")
+	}
+}
+
+func TestLostRetried(t *testing.T) {
+	store := NewTaskStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	store.random = func() float64 { return 0 }
+
+	// A lost task is retried after a backoff, as a failed one is, until it runs out
+	// of attempts.
+	retry := &gozer.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 10}
+	if err := store.Add(&Task{gozerTask: &gozer.Task{Id: "task", Command: "true", Retry: retry}}); err != nil {
+		t.Fatal(err)
+	}
+	addTask(t, store, "once", 0)
+	for _, id := range []string{"task", "once"} {
+		launch(t, store, id, gozer.TaskState_RUNNING, "")
+		if err := store.Lost(id, "Slave slave-1 removed"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if task, ok := store.NextPending(); ok {
+		t.Fatalf("got next pending %v during the backoff, want none", task)
+	}
+
+	now = now.Add(10 * time.Second)
+	launch(t, store, "task#2", gozer.TaskState_RUNNING, "")
+	if err := store.Lost("task#2", "Slave slave-2 removed"); err != nil {
+		t.Fatal(err)
+	}
+	if task, ok := store.NextPending(); ok {
+		t.Errorf("got next pending %v after the last attempt, want none", task)
+	}
+
+	// A task without a retry policy is launched once.
+	history := store.History(gozer.TaskState_LOST)
+	if len(history) != 2 || history[0].Id != "task" || len(history[0].Attempts) != 2 || history[1].Id != "once" {
+		t.Errorf("got lost tasks %+v, want task after two attempts, and once", history)
+	}
+}
+
+func TestNotRetried(t *testing.T) {
+	store := NewTaskStore()
+	retry := &gozer.RetryPolicy{MaxAttempts: 3, ExitCodes: []int{2}}
+	for _, id := range []string{"exit-1", "killed", "no-policy"} {
+		task := &gozer.Task{Id: id, Command: "false", Retry: retry}
+		if id == "no-policy" {
+			task.Retry = nil
+		}
+		if err := store.Add(&Task{gozerTask: task}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	launch(t, store, "exit-1", gozer.TaskState_FAILED, "Command exited with status 1")
+	launch(t, store, "killed", gozer.TaskState_RUNNING, "")
+	if err := store.Update("killed", gozer.TaskState_KILLING, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update("killed", gozer.TaskState_FAILED, ""); err != nil {
+		t.Fatal(err)
+	}
+	launch(t, store, "no-policy", gozer.TaskState_FAILED, "Command exited with status 2")
+
+	if ids := store.Ids(); len(ids) != 0 {
+		t.Errorf("got tasks %v, want none retried", ids)
+	}
+
+	invalid := &gozer.Task{Id: "invalid", Retry: &gozer.RetryPolicy{MaxAttempts: 0}}
+	if err := store.Add(&Task{gozerTask: invalid}); err == nil {
+		t.Error("added a task with an invalid retry policy")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	store := NewTaskStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	store.LimitHistory(2, time.Hour)
+
+	retry := &gozer.RetryPolicy{MaxAttempts: 2}
+	if err := store.Add(&Task{gozerTask: &gozer.Task{Id: "failed", Command: "false", Retry: retry}}); err != nil {
+		t.Fatal(err)
+	}
+	launch(t, store, "failed", gozer.TaskState_FAILED, "Command exited with status 1")
+	launch(t, store, "failed#2", gozer.TaskState_FAILED, "Command exited with status 3")
+
+	history := store.History()
+	if len(history) != 1 {
+		t.Fatalf("got history %+v, want the failed task", history)
+	}
+	got := history[0]
+	if got.Id != "failed" || got.State != gozer.TaskState_FAILED || got.SlaveId != "slave-1" || got.Message != "Command exited with status 3" || len(got.Attempts) != 2 {
+		t.Errorf("got %+v, want failed on slave-1 after two attempts, with the last one's message", got)
+	}
+	if got.Started == nil || !got.Started.Equal(now) || !got.Ended.Equal(now) {
+		t.Errorf("got %+v, want it started and ended at %s", got, now)
+	}
+
+	// The history holds the most recent tasks, up to its size.
+	now = now.Add(time.Minute)
+	for _, id := range []string{"finished-1", "finished-2"} {
+		addTask(t, store, id, 0)
+		launch(t, store, id, gozer.TaskState_FINISHED, "")
+	}
+	history = store.History()
+	if len(history) != 2 || history[0].Id != "finished-2" || history[1].Id != "finished-1" {
+		t.Errorf("got history %+v, want finished-2 and finished-1", history)
+	}
+	if history := store.History(gozer.TaskState_FAILED); len(history) != 0 {
+		t.Errorf("got failed tasks %+v, want none kept", history)
+	}
+
+	// A task submitted again leaves the history.
+	addTask(t, store, "finished-1", 0)
+	if history := store.History(); len(history) != 1 || history[0].Id != "finished-2" {
+		t.Errorf("got history %+v, want finished-2", history)
+	}
+
+	// Tasks older than the age limit are not shown, even before they are dropped.
+	now = now.Add(2 * time.Hour)
+	if history := store.History(); len(history) != 0 {
+		t.Errorf("got history %+v, want none after an hour", history)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+)
+
+var (
+	tlsCert = flag.String("tlsCert", "", "PEM certificate for the libprocess endpoint and calls to the master; enables TLS")
+	tlsKey  = flag.String("tlsKey", "", "PEM private key for -tlsCert")
+	tlsCA   = flag.String("tlsCA", "", "PEM bundle of CAs the master and its peers must be signed by")
+)
+
+// tlsConfigs returns the server and client TLS settings for the libprocess endpoint,
+// or nils if TLS is not enabled.
+func tlsConfigs() (server, client *tls.Config, err error) {
+	if *tlsCert == "" {
+		return nil, nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load TLS key pair: %+v", err)
+	}
+
+	server = &tls.Config{Certificates: []tls.Certificate{cert}}
+	client = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if *tlsCA != "" {
+		pem, err := ioutil.ReadFile(*tlsCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read CA bundle: %+v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", *tlsCA)
+		}
+
+		// Pin the master to our CAs, and require it to present a certificate when
+		// calling back to us.
+		client.RootCAs = pool
+		server.ClientCAs = pool
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return server, client, nil
+}
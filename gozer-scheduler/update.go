@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/twitter/gozer/gozer"
+	"github.com/twitter/gozer/mesos"
+)
+
+// applyUpdate applies a status update from Mesos to the task store, and reports
+// whether the update should be acknowledged. It is not if it could not be stored, so
+// that Mesos sends it again.
+func applyUpdate(update *mesos.TaskStateUpdate) bool {
+	newState, ok := gozer.TaskStateMap[update.State]
+	if !ok {
+		log.Error.Printf("Unknown mesos task state: %q", update.State)
+		return false
+	}
+
+	// Each attempt has its own id, so an update about an attempt that was lost or
+	// retried, or about a task that has gone, is for no task we hold. It is
+	// acknowledged all the same, so that it is not resent.
+	state, err := taskstore.State(update.TaskId)
+	if err != nil {
+		log.Info.Printf("Ignoring %q update for earlier attempt or unknown task %q", newState, update.TaskId)
+		return true
+	}
+
+	log.Info.Printf("Updating task state from %q to %q", state, newState)
+	if newState == gozer.TaskState_LOST {
+		// The master reports the tasks on a lost slave before the slave itself, and
+		// answers reconciliation with TASK_LOST for tasks it does not know, so they
+		// are treated as lost, and re-queued as their retry policy says.
+		err = taskstore.Lost(update.TaskId, update.Message)
+	} else {
+		err = taskstore.Update(update.TaskId, newState, update.Message)
+	}
+	if err != nil {
+		log.Error.Print(err)
+		// A change the task's lifecycle does not allow will never be made, so it
+		// is acknowledged; one we failed to store may be made yet.
+		if _, ok := err.(*StoreError); ok {
+			return false
+		}
+	}
+	return true
+}
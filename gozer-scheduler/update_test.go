@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/twitter/gozer/gozer"
+	"github.com/twitter/gozer/mesos"
+	mesos_pb "github.com/twitter/gozer/proto/mesos.pb"
+)
+
+// failingStore is a Store whose task writes fail while fail is set.
+type failingStore struct {
+	Store
+	fail bool
+}
+
+func (s *failingStore) PutTask(task *TaskRecord) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.Store.PutTask(task)
+}
+
+func TestApplyUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &failingStore{Store: openTestStore(t, dir, 100)}
+	defer store.Close()
+	saved := taskstore
+	defer func() { taskstore = saved }()
+	taskstore = NewTaskStore()
+	if err := taskstore.Recover(store, nil); err != nil {
+		t.Fatal(err)
+	}
+	addRetriedTask(t, taskstore, "task-1", 0, 2)
+	if err := taskstore.Launched("task-1", "slave-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	running := &mesos.TaskStateUpdate{TaskId: "task-1", State: mesos_pb.TaskState_TASK_RUNNING}
+
+	// An update that could not be stored is not acknowledged, so that it is resent.
+	store.fail = true
+	if applyUpdate(running) {
+		t.Error("acknowledged an update that was not stored")
+	}
+	if state, _ := taskstore.State("task-1"); state != gozer.TaskState_ASSIGNED {
+		t.Errorf("got %s, want the task left ASSIGNED", state)
+	}
+	store.fail = false
+	if !applyUpdate(running) {
+		t.Error("did not acknowledge an update that was stored")
+	}
+
+	// Updates that can never be applied are acknowledged all the same.
+	starting := &mesos.TaskStateUpdate{TaskId: "task-1", State: mesos_pb.TaskState_TASK_STAGING}
+	unknown := &mesos.TaskStateUpdate{TaskId: "unknown", State: mesos_pb.TaskState_TASK_RUNNING}
+	for _, update := range []*mesos.TaskStateUpdate{starting, unknown} {
+		if !applyUpdate(update) {
+			t.Errorf("did not acknowledge %s", update)
+		}
+	}
+
+	// A task the master answers reconciliation for with TASK_LOST is launched again,
+	// as its retry policy says.
+	lost := &mesos.TaskStateUpdate{TaskId: "task-1", State: mesos_pb.TaskState_TASK_LOST, Message: "Reconciliation: Task is unknown"}
+	if !applyUpdate(lost) {
+		t.Error("did not acknowledge a lost task")
+	}
+	if task, ok := taskstore.NextPending(); !ok || task.Id != mesosId(&gozer.Task{Id: "task-1", Attempts: make([]gozer.Attempt, 1)}) {
+		t.Errorf("got next pending %v, want task-1 again", task)
+	}
+}
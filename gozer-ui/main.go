@@ -1,15 +1,14 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 
 	"github.com/twitter/gozer/gozer"
+	"github.com/twitter/gozer/gozer/client"
 )
 
 const (
@@ -31,13 +30,26 @@ const (
 					<tr>
 						<th>id</th><th>command</th><th>state</th>
 					</tr>
-					{{range $task := .}}
+					{{range $task := .Tasks}}
 					<!-- TODO(dhamon): use table row css matched to state. -->
 					<tr>
 						<td>{{$task.Id}}</td><td>{{$task.Command}}</td><td>{{$task.State}}</td>
 					</tr>
 					{{end}}
 				</table>
+				<h2>history</h2>
+				<table class="table">
+					<tr>
+						<th>id</th><th>command</th><th>state</th><th>slave</th><th>started</th><th>ended</th><th>attempts</th><th>message</th>
+					</tr>
+					{{range $task := .History}}
+					<tr>
+						<td>{{$task.Id}}</td><td>{{$task.Command}}</td><td>{{$task.State}}</td><td>{{$task.SlaveId}}</td>
+						<td>{{with $task.Started}}{{.Format "2006-01-02 15:04:05"}}{{end}}</td><td>{{$task.Ended.Format "2006-01-02 15:04:05"}}</td>
+						<td>{{len $task.Attempts}}</td><td>{{$task.Message}}</td>
+					</tr>
+					{{end}}
+				</table>
 			</div>
 		</body>
 		<script src="https://ajax.googleapis.com/ajax/libs/jquery/1.11.1/jquery.min.js"></script>
@@ -53,11 +65,19 @@ var (
 	gozerPort     = flag.Int("gozerPort", 4343, "Port Gozer's API is listening on")
 
 	rootTemplate = template.Must(template.New("root").Parse(rootHTML))
+
+	gozerClient *client.Client
 )
 
 func main() {
 	flag.Parse()
 
+	var err error
+	gozerClient, err = client.New(client.Config{URL: fmt.Sprintf("http://%s:%d", *gozerHostname, *gozerPort)})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	http.HandleFunc("/", rootHandler)
 	log.Printf("Listening on port %d", *port)
 	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
@@ -65,36 +85,37 @@ func main() {
 	}
 }
 
-func makeGozerUrl(path string) string {
-	return fmt.Sprintf("http://%s:%d/%s", *gozerHostname, *gozerPort, path)
-}
-
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
-	tasksUrl := makeGozerUrl("tasks")
-	resp, err := http.Get(tasksUrl)
+	var page struct {
+		Tasks   []gozer.Task
+		History []gozer.CompletedTask
+	}
+	// Tasks that have ended are shown in the history, so only the others are listed,
+	// in as few pages as the scheduler allows.
+	var err error
+	page.Tasks, err = gozerClient.ListAll(r.Context(), client.ListOptions{
+		States: []gozer.TaskState{
+			gozer.TaskState_INIT,
+			gozer.TaskState_PENDING,
+			gozer.TaskState_ASSIGNED,
+			gozer.TaskState_STARTING,
+			gozer.TaskState_RUNNING,
+			gozer.TaskState_KILLING,
+		},
+		Limit: 1000,
+	})
 	if err != nil {
-		log.Printf("Failed to get task information from gozer at %q", tasksUrl)
+		log.Printf("Failed to get tasks from gozer: %+v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	var taskStore []gozer.Task
-
-	dec := json.NewDecoder(resp.Body)
-
-	err = dec.Decode(&taskStore)
-	if err != nil {
-		if err == io.EOF {
-			log.Printf("No task store found in response %+v", resp.Body)
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			log.Printf("Failed to decode %+v into task store: %+v", resp.Body, err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+	if page.History, err = gozerClient.History(r.Context()); err != nil {
+		log.Printf("Failed to get task history from gozer: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	rootTemplate.Execute(w, taskStore)
+	rootTemplate.Execute(w, page)
 }
@@ -0,0 +1,25 @@
+package gozer
+
+import (
+	"fmt"
+)
+
+// APIError is the body of every error the scheduler's API responds with.
+type APIError struct {
+	// The HTTP status of the response.
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Status, e.Message)
+}
+
+// TaskList is a page of the tasks the scheduler's API lists.
+type TaskList struct {
+	Tasks []Task `json:"tasks"`
+	// How many tasks match, on every page.
+	Total int `json:"total"`
+	// The offset of the next page, or 0 if this is the last.
+	NextOffset int `json:"next_offset,omitempty"`
+}
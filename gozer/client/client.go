@@ -0,0 +1,341 @@
+// Package client talks to the gozer scheduler's API: it submits, finds, lists,
+// kills and watches tasks.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twitter/gozer/gozer"
+)
+
+// The path of the task resources the scheduler serves.
+const tasksPath = "/api/v1/tasks"
+
+// Config configures a Client. Only URL is required.
+type Config struct {
+	// The scheduler's API, such as http://localhost:4343. Any scheduler will do, as
+	// standbys pass on requests to the leader.
+	URL string
+	// The client to send requests with; http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// How long each attempt at a request may take. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// How many times to try a request again after it fails in a way that may pass,
+	// and how long to wait before the first retry. Each retry waits twice as long as
+	// the one before.
+	Retries   int
+	RetryWait time.Duration
+	// How often Watch asks for the task. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultRetryWait    = 100 * time.Millisecond
+	DefaultPollInterval = time.Second
+)
+
+// Client is a client of the scheduler's API. It is safe for concurrent use.
+type Client struct {
+	config Config
+	base   *url.URL
+}
+
+// New returns a Client for the scheduler config names.
+func New(config Config) (*Client, error) {
+	base, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scheduler URL %q: %+v", config.URL, err)
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid scheduler URL %q: want scheme://host:port", config.URL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.RetryWait == 0 {
+		config.RetryWait = DefaultRetryWait
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	return &Client{config: config, base: base}, nil
+}
+
+// NotFoundError is returned for a task the scheduler does not know of, or no
+// longer has in its history.
+type NotFoundError struct {
+	*gozer.APIError
+}
+
+// ConflictError is returned for submitting a task with an id that is taken, or
+// killing a task that has already ended.
+type ConflictError struct {
+	*gozer.APIError
+}
+
+// UnavailableError is returned when no scheduler leads, such as while one is being
+// elected, or while the leader recovers its tasks.
+type UnavailableError struct {
+	*gozer.APIError
+}
+
+// responseError returns the error an error response stands for. The scheduler
+// responds with a gozer.APIError, but whatever is in the way may not.
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	apiErr := &gozer.APIError{}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Status == 0 {
+		apiErr = &gozer.APIError{Status: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return &NotFoundError{apiErr}
+	case http.StatusConflict:
+		return &ConflictError{apiErr}
+	case http.StatusServiceUnavailable:
+		return &UnavailableError{apiErr}
+	default:
+		return apiErr
+	}
+}
+
+// retryable reports whether a request that failed with err may succeed if it is
+// sent again. A submission is only sent again if no scheduler acted on it, as it is
+// not known whether one that got no response was added.
+func retryable(method string, err error) bool {
+	switch err := err.(type) {
+	case *UnavailableError:
+		return true
+	case *NotFoundError, *ConflictError:
+		return false
+	case *gozer.APIError:
+		return method != "POST" && err.Status >= 500
+	default:
+		// The request got no response.
+		return method != "POST"
+	}
+}
+
+// do sends a request with in as its JSON body, if it is not nil, and decodes the
+// JSON response into out, if it is not nil. It tries again after failures that may
+// pass, until it runs out of retries or ctx is done.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	_, err := c.attempt(ctx, method, path, query, in, out)
+	return err
+}
+
+// attempt is do, and also returns how many times the request was sent.
+func (c *Client) attempt(ctx context.Context, method, path string, query url.Values, in, out interface{}) (int, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return 0, fmt.Errorf("failed to marshal %+v: %+v", in, err)
+		}
+	}
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	wait := c.config.RetryWait
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, u.String(), body, out)
+		if err == nil || attempt >= c.config.Retries || !retryable(method, err) {
+			return attempt + 1, err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return attempt + 1, err
+		}
+		wait *= 2
+	}
+}
+
+// send makes one attempt at a request.
+func (c *Client) send(ctx context.Context, method, target string, body []byte, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %+v", method, target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse response to %s %s: %+v", method, target, err)
+	}
+	return nil
+}
+
+func taskPath(taskId string) string {
+	return tasksPath + "/" + url.PathEscape(taskId)
+}
+
+// Submit submits a task, and returns it as the scheduler added it. A task without an
+// id is given one.
+func (c *Client) Submit(ctx context.Context, task *gozer.Task) (*gozer.Task, error) {
+	var added gozer.Task
+	if err := c.do(ctx, "POST", tasksPath, nil, task, &added); err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+// Get returns a task the scheduler holds, or has in its history.
+func (c *Client) Get(ctx context.Context, taskId string) (*gozer.Task, error) {
+	var task gozer.Task
+	if err := c.do(ctx, "GET", taskPath(taskId), nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListOptions picks the tasks List returns.
+type ListOptions struct {
+	// Only tasks in any of these states, if any are given.
+	States []gozer.TaskState
+	// Only tasks with all of these labels. An empty value matches any value.
+	Labels map[string]string
+	// The page of the tasks to return. The scheduler picks the limit if it is 0.
+	Offset, Limit int
+}
+
+// List returns a page of the tasks the scheduler holds and has in its history, in
+// the order they were submitted.
+func (c *Client) List(ctx context.Context, options ListOptions) (*gozer.TaskList, error) {
+	query := url.Values{}
+	for _, state := range options.States {
+		query.Add("state", string(state))
+	}
+	for key, value := range options.Labels {
+		if value == "" {
+			query.Add("label", key)
+		} else {
+			query.Add("label", key+"="+value)
+		}
+	}
+	if options.Offset > 0 {
+		query.Set("offset", strconv.Itoa(options.Offset))
+	}
+	if options.Limit > 0 {
+		query.Set("limit", strconv.Itoa(options.Limit))
+	}
+
+	var list gozer.TaskList
+	if err := c.do(ctx, "GET", tasksPath, query, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ListAll returns every task List would, reading every page.
+func (c *Client) ListAll(ctx context.Context, options ListOptions) ([]gozer.Task, error) {
+	var tasks []gozer.Task
+	for {
+		list, err := c.List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, list.Tasks...)
+		if list.NextOffset == 0 {
+			return tasks, nil
+		}
+		options.Offset = list.NextOffset
+	}
+}
+
+// History returns the tasks that have ended for good, most recent first. With any
+// states given, only the tasks that ended in one of them are returned.
+func (c *Client) History(ctx context.Context, states ...gozer.TaskState) ([]gozer.CompletedTask, error) {
+	query := url.Values{}
+	for _, state := range states {
+		query.Add("state", string(state))
+	}
+
+	var tasks []gozer.CompletedTask
+	if err := c.do(ctx, "GET", "/history", query, nil, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Kill kills a task, and returns it as it was left: KILLED if it had not been
+// launched, and otherwise KILLING until Mesos kills it.
+func (c *Client) Kill(ctx context.Context, taskId string) (*gozer.Task, error) {
+	var task gozer.Task
+	attempts, err := c.attempt(ctx, "DELETE", taskPath(taskId), nil, nil, &task)
+	if _, conflict := err.(*ConflictError); conflict && attempts > 1 {
+		// The task has ended, perhaps killed by an earlier attempt that we did not
+		// hear back from, so it is returned as it ended.
+		return c.Get(ctx, taskId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// Watch calls changed with a task as it is now, and again every time its state
+// changes, until it ends. It returns nil once the task has ended, or with the error
+// that stopped it watching, such as ctx being done.
+func (c *Client) Watch(ctx context.Context, taskId string, changed func(*gozer.Task)) error {
+	ticker := time.NewTicker(c.config.PollInterval)
+	defer ticker.Stop()
+
+	var last gozer.TaskState
+	for {
+		task, err := c.Get(ctx, taskId)
+		if err != nil {
+			return err
+		}
+		if task.State != last {
+			last = task.State
+			changed(task)
+		}
+		if task.IsTerminal() {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twitter/gozer/gozer"
+)
+
+// newTestClient returns a Client of a server that responds with handler.
+func newTestClient(t *testing.T, config Config, handler http.HandlerFunc) (*Client, func()) {
+	server := httptest.NewServer(handler)
+	config.URL = server.URL
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, server.Close
+}
+
+func TestNew(t *testing.T) {
+	for _, u := range []string{"", "localhost:4343", "http://"} {
+		if _, err := New(Config{URL: u}); err == nil {
+			t.Errorf("made a client of %q", u)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var status int32
+	c, done := newTestClient(t, Config{}, func(w http.ResponseWriter, r *http.Request) {
+		status := int(atomic.LoadInt32(&status))
+		if status == http.StatusBadGateway {
+			// Not every error comes from the scheduler.
+			http.Error(w, "bad gateway", status)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"status": 0, "message": "from the scheduler"}`))
+	})
+	defer done()
+
+	for _, test := range []struct {
+		status int
+		check  func(error) bool
+	}{
+		{http.StatusNotFound, func(err error) bool { _, ok := err.(*NotFoundError); return ok }},
+		{http.StatusConflict, func(err error) bool { _, ok := err.(*ConflictError); return ok }},
+		{http.StatusServiceUnavailable, func(err error) bool { _, ok := err.(*UnavailableError); return ok }},
+		{http.StatusBadRequest, func(err error) bool { e, ok := err.(*gozer.APIError); return ok && e.Status == http.StatusBadRequest }},
+		{http.StatusBadGateway, func(err error) bool {
+			e, ok := err.(*gozer.APIError)
+			return ok && e.Status == http.StatusBadGateway && e.Message == "bad gateway"
+		}},
+	} {
+		atomic.StoreInt32(&status, int32(test.status))
+		if _, err := c.Get(context.Background(), "task-1"); !test.check(err) {
+			t.Errorf("%d: got %T %v", test.status, err, err)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var requests int32
+	c, done := newTestClient(t, Config{Retries: 3, RetryWait: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"id": "task-1", "state": "RUNNING"}`))
+	})
+	defer done()
+
+	task, err := c.Get(context.Background(), "task-1")
+	if err != nil || task.State != gozer.TaskState_RUNNING {
+		t.Fatalf("got %+v (%v), want task-1 RUNNING", task, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("sent %d requests, want 3", n)
+	}
+
+	// A submission that may have been acted on is not sent again.
+	if _, err := c.Submit(context.Background(), &gozer.Task{Command: "true"}); err == nil {
+		t.Fatal("submitted a task to a failing scheduler")
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("sent %d requests, want 4", n)
+	}
+}
+
+func TestKillRetried(t *testing.T) {
+	var deletes int32
+	c, done := newTestClient(t, Config{Retries: 3, RetryWait: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`{"id": "task-1", "state": "KILLED"}`))
+			return
+		}
+		// The first kill is acted on, but its response is lost.
+		if atomic.AddInt32(&deletes, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"status": 409, "message": "task has already ended"}`))
+	})
+	defer done()
+
+	task, err := c.Kill(context.Background(), "task-1")
+	if err != nil || task.State != gozer.TaskState_KILLED {
+		t.Fatalf("got %+v (%v), want task-1 KILLED", task, err)
+	}
+
+	// A task that had already ended when it was first asked to be killed was not
+	// killed by us.
+	if _, err := c.Kill(context.Background(), "task-1"); err == nil {
+		t.Error("killed a task that had already ended")
+	} else if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("got %T %v, want a conflict", err, err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	c, done := newTestClient(t, Config{Timeout: 10 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer done()
+	defer close(release)
+
+	if _, err := c.Get(context.Background(), "task-1"); err == nil {
+		t.Error("got a task from a server that does not answer")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	states := []gozer.TaskState{gozer.TaskState_PENDING, gozer.TaskState_PENDING, gozer.TaskState_RUNNING, gozer.TaskState_FINISHED}
+	var polls int32
+	c, done := newTestClient(t, Config{PollInterval: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		state := states[atomic.AddInt32(&polls, 1)-1]
+		w.Write([]byte(`{"id": "task-1", "state": "` + string(state) + `"}`))
+	})
+	defer done()
+
+	var got []gozer.TaskState
+	err := c.Watch(context.Background(), "task-1", func(task *gozer.Task) {
+		got = append(got, task.State)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] != gozer.TaskState_PENDING || got[1] != gozer.TaskState_RUNNING || got[2] != gozer.TaskState_FINISHED {
+		t.Errorf("watched %v, want PENDING, RUNNING, FINISHED", got)
+	}
+}
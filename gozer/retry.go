@@ -0,0 +1,99 @@
+package gozer
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RetryPolicy says whether, and when, to launch a task again after it ends without
+// finishing.
+type RetryPolicy struct {
+	// How many times the task is launched at most, the first time included.
+	MaxAttempts int `json:"max_attempts"`
+	// How long to wait before the first retry, in seconds. Each retry after it waits
+	// twice as long as the one before, up to MaxBackoffSeconds if that is set.
+	BackoffSeconds    float64 `json:"backoff_seconds,omitempty"`
+	MaxBackoffSeconds float64 `json:"max_backoff_seconds,omitempty"`
+	// Up to this fraction of each wait is added at random, so that tasks that failed
+	// together are not all retried together.
+	Jitter float64 `json:"jitter,omitempty"`
+	// The states the task is retried after: FAILED, LOST or both. If empty, both.
+	States []TaskState `json:"states,omitempty"`
+	// If not empty, a task that FAILED is only retried if its command exited with one
+	// of these codes.
+	ExitCodes []int `json:"exit_codes,omitempty"`
+}
+
+// defaultRetryStates are the states a task is retried after if its policy does not
+// say.
+var defaultRetryStates = []TaskState{TaskState_FAILED, TaskState_LOST}
+
+// Validate returns an error if the policy makes no sense.
+func (p *RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1, not %d", p.MaxAttempts)
+	}
+	if p.BackoffSeconds < 0 || p.MaxBackoffSeconds < 0 {
+		return fmt.Errorf("backoff can not be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1, not %v", p.Jitter)
+	}
+	for _, state := range p.States {
+		if state != TaskState_FAILED && state != TaskState_LOST {
+			return fmt.Errorf("tasks can only be retried after %s or %s, not %s", TaskState_FAILED, TaskState_LOST, state)
+		}
+	}
+	return nil
+}
+
+// Retryable reports whether a task that ended in state after the given number of
+// attempts is to be launched again. exitCode is nil if it is not known.
+func (p *RetryPolicy) Retryable(state TaskState, attempts int, exitCode *int) bool {
+	if attempts >= p.MaxAttempts {
+		return false
+	}
+
+	states := p.States
+	if len(states) == 0 {
+		states = defaultRetryStates
+	}
+	retryable := false
+	for _, s := range states {
+		if s == state {
+			retryable = true
+		}
+	}
+	if !retryable || state != TaskState_FAILED || len(p.ExitCodes) == 0 {
+		return retryable
+	}
+
+	if exitCode == nil {
+		return false
+	}
+	for _, code := range p.ExitCodes {
+		if code == *exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns how long to wait before launching a task again after the given
+// number of attempts. random is a number in [0, 1), which picks the jitter.
+func (p *RetryPolicy) Backoff(attempts int, random float64) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	seconds := p.BackoffSeconds * math.Pow(2, float64(attempts-1))
+	if p.MaxBackoffSeconds > 0 && seconds > p.MaxBackoffSeconds {
+		seconds = p.MaxBackoffSeconds
+	}
+	// Leave room for the jitter in the longest wait a Duration holds.
+	if longest := (time.Duration(math.MaxInt64) / 2).Seconds(); seconds > longest {
+		seconds = longest
+	}
+	seconds += seconds * p.Jitter * random
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,79 @@
+package gozer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	one, two := 1, 2
+	tests := []struct {
+		policy   RetryPolicy
+		state    TaskState
+		attempts int
+		exitCode *int
+		want     bool
+	}{
+		{RetryPolicy{MaxAttempts: 3}, TaskState_FAILED, 1, nil, true},
+		{RetryPolicy{MaxAttempts: 3}, TaskState_LOST, 2, nil, true},
+		{RetryPolicy{MaxAttempts: 3}, TaskState_FAILED, 3, nil, false},
+		{RetryPolicy{MaxAttempts: 3}, TaskState_KILLED, 1, nil, false},
+		{RetryPolicy{MaxAttempts: 3}, TaskState_FINISHED, 1, nil, false},
+		{RetryPolicy{MaxAttempts: 3, States: []TaskState{TaskState_LOST}}, TaskState_FAILED, 1, nil, false},
+		{RetryPolicy{MaxAttempts: 3, States: []TaskState{TaskState_LOST}}, TaskState_LOST, 1, nil, true},
+		{RetryPolicy{MaxAttempts: 3, ExitCodes: []int{2}}, TaskState_FAILED, 1, &two, true},
+		{RetryPolicy{MaxAttempts: 3, ExitCodes: []int{2}}, TaskState_FAILED, 1, &one, false},
+		{RetryPolicy{MaxAttempts: 3, ExitCodes: []int{2}}, TaskState_FAILED, 1, nil, false},
+		// Exit codes do not apply to lost tasks.
+		{RetryPolicy{MaxAttempts: 3, ExitCodes: []int{2}}, TaskState_LOST, 1, nil, true},
+	}
+
+	for i, test := range tests {
+		if got := test.policy.Retryable(test.state, test.attempts, test.exitCode); got != test.want {
+			t.Errorf("%d: %+v: got retryable %v after %s on attempt %d, want %v", i, test.policy, got, test.state, test.attempts, test.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BackoffSeconds: 1, MaxBackoffSeconds: 5, Jitter: 0.5}
+	tests := []struct {
+		attempts int
+		random   float64
+		want     time.Duration
+	}{
+		{1, 0, time.Second},
+		{2, 0, 2 * time.Second},
+		{3, 0, 4 * time.Second},
+		{4, 0, 5 * time.Second},
+		{100, 0, 5 * time.Second},
+		{2, 0.5, 2500 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := policy.Backoff(test.attempts, test.random); got != test.want {
+			t.Errorf("got backoff %s after %d attempts with random %v, want %s", got, test.attempts, test.random, test.want)
+		}
+	}
+
+	unbounded := RetryPolicy{MaxAttempts: 10000, BackoffSeconds: 1}
+	if got := unbounded.Backoff(5000, 0); got <= 0 {
+		t.Errorf("got backoff %s after 5000 attempts, want it to saturate", got)
+	}
+}
+
+func TestValidateRetryPolicy(t *testing.T) {
+	for _, policy := range []RetryPolicy{
+		{MaxAttempts: 0},
+		{MaxAttempts: 2, BackoffSeconds: -1},
+		{MaxAttempts: 2, Jitter: 2},
+		{MaxAttempts: 2, States: []TaskState{TaskState_KILLED}},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("%+v is valid", policy)
+		}
+	}
+	policy := RetryPolicy{MaxAttempts: 2, BackoffSeconds: 10, Jitter: 0.2, States: []TaskState{TaskState_FAILED}}
+	if err := policy.Validate(); err != nil {
+		t.Error(err)
+	}
+}
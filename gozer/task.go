@@ -2,6 +2,7 @@ package gozer
 
 import (
 	"fmt"
+	"time"
 
 	mesos_pb "github.com/twitter/gozer/proto/mesos.pb"
 )
@@ -9,9 +10,16 @@ import (
 type TaskState string
 
 const (
-	TaskState_INIT     TaskState = "INIT"
+	// Accepted, but not yet queued for launch.
+	TaskState_INIT TaskState = "INIT"
+	// Waiting for an offer to launch on.
+	TaskState_PENDING TaskState = "PENDING"
+	// Launched on an offer; Mesos has not confirmed it yet.
+	TaskState_ASSIGNED TaskState = "ASSIGNED"
 	TaskState_STARTING TaskState = "STARTING"
 	TaskState_RUNNING  TaskState = "RUNNING"
+	// Asked to be killed; Mesos has not confirmed it yet.
+	TaskState_KILLING  TaskState = "KILLING"
 	TaskState_FINISHED TaskState = "FINISHED"
 	TaskState_FAILED   TaskState = "FAILED"
 	TaskState_KILLED   TaskState = "KILLED"
@@ -28,11 +36,109 @@ var TaskStateMap = map[mesos_pb.TaskState]TaskState{
 	mesos_pb.TaskState_TASK_LOST:     TaskState_LOST,
 }
 
+// terminal holds the states a task does not leave, other than to be re-queued.
+var terminal = []TaskState{
+	TaskState_FINISHED,
+	TaskState_FAILED,
+	TaskState_KILLED,
+	TaskState_LOST,
+}
+
+// transitions lists the states a task may move to from each state. Updates from
+// Mesos may skip states, and may be repeated.
+var transitions = map[TaskState][]TaskState{
+	// A new task has no state.
+	"": {TaskState_INIT},
+
+	TaskState_INIT: {TaskState_PENDING, TaskState_KILLED},
+	// A pending task that is killed has nothing to wait for.
+	TaskState_PENDING: {TaskState_ASSIGNED, TaskState_KILLED},
+	// Launching may fail before Mesos hears of the task, which is then pending again.
+	TaskState_ASSIGNED: append([]TaskState{TaskState_PENDING, TaskState_STARTING, TaskState_RUNNING, TaskState_KILLING}, terminal...),
+	TaskState_STARTING: append([]TaskState{TaskState_STARTING, TaskState_RUNNING, TaskState_KILLING}, terminal...),
+	TaskState_RUNNING:  append([]TaskState{TaskState_RUNNING, TaskState_KILLING}, terminal...),
+	TaskState_KILLING:  append([]TaskState{TaskState_KILLING}, terminal...),
+
+	// Tasks that did not finish may be queued to run again.
+	TaskState_FAILED: {TaskState_PENDING},
+	TaskState_LOST:   {TaskState_PENDING},
+}
+
+// TransitionError is returned for a change of state the task lifecycle does not allow.
+type TransitionError struct {
+	TaskId   string
+	From, To TaskState
+}
+
+func (e *TransitionError) Error() string {
+	from := e.From
+	if from == "" {
+		from = "*"
+	}
+	return fmt.Sprintf("task %q can not move from state %s to %s", e.TaskId, from, e.To)
+}
+
+// Transition records a task entering a state.
+type Transition struct {
+	State TaskState `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+// Attempt is one launch of a task.
+type Attempt struct {
+	// The id Mesos knows the attempt by.
+	MesosId string `json:"mesos_id"`
+	SlaveId string `json:"slave_id,omitempty"`
+	// The last state the attempt was in.
+	State    TaskState `json:"state"`
+	Launched time.Time `json:"launched"`
+	// When and how the attempt ended, once it has.
+	Ended    *time.Time `json:"ended,omitempty"`
+	ExitCode *int       `json:"exit_code,omitempty"`
+	Message  string     `json:"message,omitempty"`
+}
+
 type Task struct {
 	Id      string    `json:"id"`
 	Command string    `json:"command"`
 	State   TaskState `json:"state"`
-	// TODO(dhamon): resource requirements
+	// Pending tasks with a higher priority are launched first.
+	Priority int `json:"priority,omitempty"`
+	// Labels are for whoever submits the task to find it by; the scheduler does not
+	// look at them.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Every state the task has been in, oldest first.
+	Transitions []Transition `json:"transitions,omitempty"`
+	// How to retry the task if it does not finish. Without a policy, it is launched
+	// once.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Every launch of the task, oldest first.
+	Attempts []Attempt `json:"attempts,omitempty"`
+	// When a task waiting to be retried may be launched again.
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+	// The cpus and memory, in MB, the task is launched with. A task submitted without
+	// them is given DefaultCpus and DefaultMem.
+	Cpus float64 `json:"cpus,omitempty"`
+	Mem  float64 `json:"mem,omitempty"`
+}
+
+// The resources a task is given if it is submitted without them.
+const (
+	DefaultCpus = 1
+	DefaultMem  = 128
+)
+
+// CompletedTask is a task that has ended for good: it finished or was killed, or it
+// failed or was lost and is not to be retried.
+type CompletedTask struct {
+	Task
+	// The slave the task last ran on, if it was launched.
+	SlaveId string `json:"slave_id,omitempty"`
+	// How its last attempt ended, as Mesos explained it.
+	Message string `json:"message,omitempty"`
+	// When the task was first launched, if it was, and when it ended for good.
+	Started *time.Time `json:"started,omitempty"`
+	Ended   time.Time  `json:"ended"`
 }
 
 func (t Task) String() string {
@@ -40,8 +146,44 @@ func (t Task) String() string {
 }
 
 func (t Task) IsTerminal() bool {
-	return t.State == TaskState_FAILED ||
-		t.State == TaskState_FINISHED ||
-		t.State == TaskState_KILLED ||
-		t.State == TaskState_LOST
+	for _, state := range terminal {
+		if t.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+// CanTransition reports whether the task may move to the given state.
+func (t Task) CanTransition(to TaskState) bool {
+	for _, state := range transitions[t.State] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the task to the given state, recording when it did so, and on
+// the attempt it is in, if it is in one. It returns a *TransitionError if the
+// lifecycle does not allow the move. A repeated update leaves the task as it was.
+func (t *Task) Transition(to TaskState) error {
+	if !t.CanTransition(to) {
+		return &TransitionError{TaskId: t.Id, From: t.State, To: to}
+	}
+	if to == t.State {
+		return nil
+	}
+
+	t.State = to
+	t.Transitions = append(t.Transitions, Transition{State: to, Time: time.Now()})
+	switch to {
+	case TaskState_INIT, TaskState_PENDING, TaskState_ASSIGNED:
+		// Not in an attempt yet; ASSIGNED starts the next one.
+	default:
+		if len(t.Attempts) > 0 {
+			t.Attempts[len(t.Attempts)-1].State = to
+		}
+	}
+	return nil
 }
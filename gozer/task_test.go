@@ -0,0 +1,73 @@
+package gozer
+
+import (
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	task := &Task{Id: "task"}
+
+	for _, state := range []TaskState{
+		TaskState_INIT,
+		TaskState_PENDING,
+		TaskState_ASSIGNED,
+		TaskState_STARTING,
+		TaskState_STARTING,
+		TaskState_RUNNING,
+		TaskState_LOST,
+		TaskState_PENDING,
+		TaskState_ASSIGNED,
+		TaskState_RUNNING,
+		TaskState_KILLING,
+		TaskState_KILLED,
+	} {
+		if err := task.Transition(state); err != nil {
+			t.Fatalf("%s -> %s: %v", task.State, state, err)
+		}
+	}
+
+	// The repeated STARTING update is not recorded.
+	if len(task.Transitions) != 11 {
+		t.Fatalf("got %d transitions, want 11", len(task.Transitions))
+	}
+	for i := 1; i < len(task.Transitions); i++ {
+		if task.Transitions[i].Time.Before(task.Transitions[i-1].Time) {
+			t.Errorf("transition %d is timestamped before the one it follows", i)
+		}
+	}
+	if !task.IsTerminal() {
+		t.Errorf("%s is not terminal", task.State)
+	}
+}
+
+func TestIllegalTransition(t *testing.T) {
+	tests := []struct {
+		from, to TaskState
+	}{
+		{"", TaskState_RUNNING},
+		{TaskState_INIT, TaskState_RUNNING},
+		{TaskState_PENDING, TaskState_RUNNING},
+		{TaskState_RUNNING, TaskState_STARTING},
+		{TaskState_FINISHED, TaskState_RUNNING},
+		{TaskState_KILLED, TaskState_PENDING},
+		{TaskState_KILLING, TaskState_RUNNING},
+		{TaskState_RUNNING, "BOGUS"},
+	}
+
+	for _, test := range tests {
+		task := &Task{Id: "task", State: test.from}
+		err := task.Transition(test.to)
+
+		transitionErr, ok := err.(*TransitionError)
+		if !ok {
+			t.Errorf("%s -> %s: got %v, want a *TransitionError", test.from, test.to, err)
+			continue
+		}
+		if transitionErr.From != test.from || transitionErr.To != test.to || transitionErr.TaskId != "task" {
+			t.Errorf("%s -> %s: got %+v", test.from, test.to, transitionErr)
+		}
+		if task.State != test.from || len(task.Transitions) != 0 {
+			t.Errorf("%s -> %s: rejected transition changed the task to %s", test.from, test.to, task.State)
+		}
+	}
+}
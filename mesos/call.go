@@ -1,7 +1,7 @@
 package mesos
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 
@@ -19,7 +19,7 @@ var (
 		mesos_scheduler.Call_UNREGISTER: "mesos.internal.UnregisterFrameworkMessage",
 		mesos_scheduler.Call_REQUEST:    "mesos.internal.ResourceRequestMessage",
 		// Decline is implemented as a call to LaunchTasks with no tasks.
-		mesos_scheduler.Call_DECLINE:	 "mesos.internal.LaunchTasksMessage",
+		mesos_scheduler.Call_DECLINE: "mesos.internal.LaunchTasksMessage",
 		// mesos_scheduler.Call_REVIVE
 		mesos_scheduler.Call_LAUNCH:      "mesos.internal.LaunchTasksMessage",
 		mesos_scheduler.Call_KILL:        "mesos.internal.KillTaskMessage",
@@ -45,8 +45,11 @@ func callToMessage(m *mesos_scheduler.Call) (proto.Message, error) {
 		}, nil
 
 	case mesos_scheduler.Call_REREGISTER:
+		// Failing over replaces whichever instance of the framework the master knows
+		// of with us, which is what we want whether that instance was us or not.
 		return &mesos_internal.ReregisterFrameworkMessage{
 			Framework: m.FrameworkInfo,
+			Failover:  proto.Bool(true),
 		}, nil
 
 	case mesos_scheduler.Call_UNREGISTER:
@@ -104,43 +107,43 @@ func callToMessage(m *mesos_scheduler.Call) (proto.Message, error) {
 		}, nil
 	}
 
-
 	return nil, fmt.Errorf("unimplemented call type %q", *m.Type)
 }
 
-func (d *Driver) send(m *mesos_scheduler.Call) error {
-	// TODO(dhamon): Remove this call when mesos listens for Call directly.
-	msg, err := callToMessage(m)
-	if err != nil {
-		return fmt.Errorf("failed to convert Call %+v: %+v", m, err)
-	}
-
-	buffer, err := proto.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal Message %+v: %+v", msg, err)
-	}
-
-	path, err := path(m)
-	if err != nil {
-		return fmt.Errorf("failed to get path for Call %+v: %+v", m, err)
-	}
-
-	registerUrl := "http://" + fmt.Sprintf("%s:%d/master", d.config.Masters[0].Hostname, d.config.Masters[0].Port) + "/" + path
-
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", registerUrl, bytes.NewReader(buffer))
-	req.Header.Add("Connection", "keep-alive")
-	req.Header.Add("Content-type", "application/octet-stream")
-	req.Header.Add("Libprocess-From", fmt.Sprintf("%s@%s:%d", d.config.FrameworkName, d.pidIp, d.pidPort))
+// masterURL returns the URL of path on the master.
+func masterURL(scheme string, masters []MasterAddress, path string) string {
+	return scheme + "://" + fmt.Sprintf("%s:%d", masters[0].Hostname, masters[0].Port) + path
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to post register call to %s: %+v", registerUrl, err)
+// sendError classifies a failure to get any response from the master.
+func sendError(ctx context.Context, url string, err error) error {
+	if ctx.Err() != nil {
+		// The caller gave up; that says nothing about the master.
+		return newDriverError(errorNone, "failed to post %s: %+v", url, ctx.Err())
 	}
+	return newDriverError(errorTransient, "failed to post %s: %+v", url, err)
+}
 
-	if resp != nil && resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("unexpected response status. want %d got %d", http.StatusAccepted, resp.StatusCode)
+// statusError classifies the master's response status; it returns nil if the
+// status is the one wanted.
+func statusError(url string, want, got int) error {
+	switch {
+	case got == want:
+		return nil
+	case got >= http.StatusInternalServerError:
+		return newDriverError(errorTransient, "unexpected response status from %s. want %d got %d",
+			url, want, got)
+	case got == http.StatusForbidden:
+		// The master no longer counts us as subscribed.
+		return newDriverError(errorNotReady, "unexpected response status from %s. want %d got %d",
+			url, want, got)
+	case got >= http.StatusBadRequest:
+		// The master refused this call, such as for naming an offer or task that
+		// is gone; that says nothing about the connection.
+		return newDriverError(errorNone, "unexpected response status from %s. want %d got %d",
+			url, want, got)
+	default:
+		return newDriverError(errorNotReady, "unexpected response status from %s. want %d got %d",
+			url, want, got)
 	}
-
-	return nil
 }
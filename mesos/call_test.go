@@ -1,8 +1,11 @@
 package mesos
 
-import "testing"
+import (
+	"net/http"
+	"testing"
 
-import "github.com/twitter/gozer/proto/scheduler.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
 
 func TestPath(t *testing.T) {
 	in := mesos_scheduler.Call_REGISTER
@@ -17,3 +20,26 @@ func TestPath(t *testing.T) {
 		t.Errorf("path(%v): got %v, want %v", in, got, out)
 	}
 }
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		status int
+		want   stateErrorId
+	}{
+		{http.StatusAccepted, errorNone},
+		{http.StatusBadRequest, errorNone},
+		{http.StatusNotFound, errorNone},
+		{http.StatusForbidden, errorNotReady},
+		{http.StatusTemporaryRedirect, errorNotReady},
+		{http.StatusServiceUnavailable, errorTransient},
+	}
+	for _, test := range tests {
+		err := statusError("http://master/api", http.StatusAccepted, test.status)
+		if got := errorIdOf(err); got != test.want {
+			t.Errorf("status %d: got a %s error, want %s", test.status, got, test.want)
+		}
+		if (err != nil) != (test.status != http.StatusAccepted) {
+			t.Errorf("status %d: got error %v", test.status, err)
+		}
+	}
+}
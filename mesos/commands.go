@@ -1,6 +1,8 @@
 package mesos
 
 import (
+	"context"
+
 	"github.com/twitter/gozer/proto/mesos.pb"
 	"github.com/twitter/gozer/proto/scheduler.pb"
 )
@@ -8,10 +10,51 @@ import (
 type MesosTask struct {
 	Id      string
 	Command string
+	// The cpus and memory, in MB, the task is launched with.
+	Cpus float64
+	Mem  float64
+}
+
+// A command is run on the driver goroutine. Its final result, after any retries, is
+// delivered on result.
+type command struct {
+	run    func(*Driver) error
+	result chan error
+}
+
+func newCommand(run func(*Driver) error) *command {
+	return &command{
+		run:    run,
+		result: make(chan error, 1),
+	}
+}
+
+// do runs a command on the driver goroutine and waits for its result. It returns
+// early with the context's error if ctx is done before the command completes, or
+// with ErrStopped if the driver has stopped.
+func (d *Driver) do(ctx context.Context, run func(*Driver) error) error {
+	c := newCommand(run)
+
+	select {
+	case d.command <- c:
+	case <-d.done:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-c.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (d *Driver) LaunchTask(offer *Offer, task *MesosTask) error {
-	d.command <- func(fm *Driver) error {
+// LaunchTask launches task using the resources of offer. It returns once the master
+// has accepted the launch request, or with the reason it could not be sent.
+func (d *Driver) LaunchTask(ctx context.Context, offer *Offer, task *MesosTask) error {
+	return d.do(ctx, func(fm *Driver) error {
 		launchType := mesos_scheduler.Call_LAUNCH
 		launchCall := &mesos_scheduler.Call{
 			FrameworkInfo: &mesos.FrameworkInfo{
@@ -28,7 +71,7 @@ func (d *Driver) LaunchTask(offer *Offer, task *MesosTask) error {
 							Value: &task.Id,
 						},
 						SlaveId:   offer.mesosOffer.SlaveId,
-						Resources: offer.mesosOffer.Resources,
+						Resources: offer.resources(task),
 						Command: &mesos.CommandInfo{
 							Value: &task.Command,
 						},
@@ -40,8 +83,72 @@ func (d *Driver) LaunchTask(offer *Offer, task *MesosTask) error {
 			},
 		}
 
-		return fm.send(launchCall)
-	}
+		return fm.transport.Send(ctx, launchCall)
+	})
+}
+
+// KillTask asks the master to kill a task. It returns once the master has been sent
+// the request; the task's final state arrives as an update.
+func (d *Driver) KillTask(ctx context.Context, taskId string) error {
+	return d.do(ctx, func(fm *Driver) error {
+		killType := mesos_scheduler.Call_KILL
+		killCall := &mesos_scheduler.Call{
+			FrameworkInfo: &mesos.FrameworkInfo{
+				User: &fm.config.RegisteredUser,
+				Name: &fm.config.FrameworkName,
+				Id:   &fm.frameworkId,
+			},
+			Type: &killType,
+			Kill: &mesos_scheduler.Call_Kill{
+				TaskId: &mesos.TaskID{
+					Value: &taskId,
+				},
+			},
+		}
+
+		return fm.transport.Send(ctx, killCall)
+	})
+}
+
+// RequestResources asks the master's allocator for resources, optionally on particular
+// slaves. The requests are only a hint; allocators are free to ignore them.
+func (d *Driver) RequestResources(ctx context.Context, requests []*mesos.Request) error {
+	return d.do(ctx, func(fm *Driver) error {
+		requestType := mesos_scheduler.Call_REQUEST
+		requestCall := &mesos_scheduler.Call{
+			FrameworkInfo: &mesos.FrameworkInfo{
+				User: &fm.config.RegisteredUser,
+				Name: &fm.config.FrameworkName,
+				Id:   &fm.frameworkId,
+			},
+			Type: &requestType,
+			Request: &mesos_scheduler.Call_Request{
+				Requests: requests,
+			},
+		}
+
+		return fm.transport.Send(ctx, requestCall)
+	})
+}
+
+// ReconcileTasks asks the master for the state of the given tasks. The master sends
+// an update for each task it knows to be in a different state, and TASK_LOST for
+// those it has no record of.
+func (d *Driver) ReconcileTasks(ctx context.Context, statuses []*mesos.TaskStatus) error {
+	return d.do(ctx, func(fm *Driver) error {
+		reconcileType := mesos_scheduler.Call_RECONCILE
+		reconcileCall := &mesos_scheduler.Call{
+			FrameworkInfo: &mesos.FrameworkInfo{
+				User: &fm.config.RegisteredUser,
+				Name: &fm.config.FrameworkName,
+				Id:   &fm.frameworkId,
+			},
+			Type: &reconcileType,
+			Reconcile: &mesos_scheduler.Call_Reconcile{
+				Statuses: statuses,
+			},
+		}
 
-	return nil
+		return fm.transport.Send(ctx, reconcileCall)
+	})
 }
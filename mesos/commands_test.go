@@ -0,0 +1,195 @@
+package mesos
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+	"github.com/twitter/gozer/proto/messages.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+func TestDoReturnsCommandError(t *testing.T) {
+	d := &Driver{command: make(chan *command)}
+	want := errors.New("send failed")
+
+	go func() {
+		c := <-d.command
+		c.result <- c.run(d)
+	}()
+
+	if got := d.do(context.Background(), func(*Driver) error { return want }); got != want {
+		t.Errorf("do: got %v, want %v", got, want)
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	// Nothing is reading commands, so do can only return through the context.
+	d := &Driver{command: make(chan *command)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if got := d.do(ctx, func(*Driver) error { return nil }); got != context.Canceled {
+		t.Errorf("do: got %v, want %v", got, context.Canceled)
+	}
+}
+
+func TestRequestResources(t *testing.T) {
+	driver, transport := newRegisteredDriver(t)
+	defer driver.Stop(true)
+
+	cpus := "cpus"
+	scalar := mesos.Value_SCALAR
+	requests := []*mesos.Request{{
+		Resources: []*mesos.Resource{{
+			Name:   &cpus,
+			Type:   &scalar,
+			Scalar: &mesos.Value_Scalar{Value: proto.Float64(4)},
+		}},
+	}}
+	if err := driver.RequestResources(context.Background(), requests); err != nil {
+		t.Fatal(err)
+	}
+
+	call := <-transport.Calls
+	if call.GetType() != mesos_scheduler.Call_REQUEST {
+		t.Fatalf("got call %v, want %v", call.GetType(), mesos_scheduler.Call_REQUEST)
+	}
+	if !proto.Equal(call.GetRequest().GetRequests()[0], requests[0]) {
+		t.Errorf("got request %v, want %v", call.GetRequest().GetRequests()[0], requests[0])
+	}
+	if id := call.GetFrameworkInfo().GetId().GetValue(); id != "framework-1" {
+		t.Errorf("request from framework %q, want %q", id, "framework-1")
+	}
+
+	// The message the master gets carries the same requests.
+	message, err := callToMessage(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := message.(*mesos_internal.ResourceRequestMessage).GetRequests(); len(got) != 1 || !proto.Equal(got[0], requests[0]) {
+		t.Errorf("got message requests %v, want %v", got, requests)
+	}
+}
+
+func TestLaunchTask(t *testing.T) {
+	driver, transport := newRegisteredDriver(t)
+	defer driver.Stop(true)
+
+	scalar := func(name, role string, value float64) *mesos.Resource {
+		return &mesos.Resource{
+			Name:   proto.String(name),
+			Type:   mesos.Value_SCALAR.Enum(),
+			Role:   proto.String(role),
+			Scalar: &mesos.Value_Scalar{Value: proto.Float64(value)},
+		}
+	}
+	offer := &Offer{driver: driver, mesosOffer: &mesos.Offer{
+		Id:      &mesos.OfferID{Value: proto.String("offer-1")},
+		SlaveId: &mesos.SlaveID{Value: proto.String("slave-1")},
+		Resources: []*mesos.Resource{
+			scalar("cpus", "*", 2),
+			scalar("cpus", "prod", 1),
+			scalar("mem", "*", 512),
+		},
+	}}
+	if offer.Fits(&MesosTask{Id: "big", Cpus: 4, Mem: 256}) {
+		t.Error("a task needing 4 cpus fits an offer of 3")
+	}
+
+	// The task is launched with what it needs of the offer, and no more.
+	task := &MesosTask{Id: "task-1", Command: "true", Cpus: 2.5, Mem: 256}
+	if !offer.Fits(task) {
+		t.Fatal("a task needing 2.5 cpus does not fit an offer of 3")
+	}
+	if err := driver.LaunchTask(context.Background(), offer, task); err != nil {
+		t.Fatal(err)
+	}
+	call := <-transport.Calls
+	if call.GetType() != mesos_scheduler.Call_LAUNCH {
+		t.Fatalf("got call %v, want %v", call.GetType(), mesos_scheduler.Call_LAUNCH)
+	}
+	want := []*mesos.Resource{scalar("cpus", "*", 2), scalar("cpus", "prod", 0.5), scalar("mem", "*", 256)}
+	got := call.GetLaunch().GetTaskInfos()[0].GetResources()
+	if len(got) != len(want) {
+		t.Fatalf("launched with %v, want %v", got, want)
+	}
+	for i := range want {
+		if !proto.Equal(got[i], want[i]) {
+			t.Errorf("launched with %v, want %v", got[i], want[i])
+		}
+	}
+}
+
+func TestKillTask(t *testing.T) {
+	driver, transport := newRegisteredDriver(t)
+	defer driver.Stop(true)
+
+	if err := driver.KillTask(context.Background(), "task-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	call := <-transport.Calls
+	if call.GetType() != mesos_scheduler.Call_KILL {
+		t.Fatalf("got call %v, want %v", call.GetType(), mesos_scheduler.Call_KILL)
+	}
+	message, err := callToMessage(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kill := message.(*mesos_internal.KillTaskMessage)
+	if id := kill.GetFrameworkId().GetValue(); id != "framework-1" {
+		t.Errorf("kill for framework %q, want %q", id, "framework-1")
+	}
+	if id := kill.GetTaskId().GetValue(); id != "task-1" {
+		t.Errorf("kill for task %q, want %q", id, "task-1")
+	}
+}
+
+func TestReconcileTasks(t *testing.T) {
+	driver, transport := newRegisteredDriver(t)
+	defer driver.Stop(true)
+
+	if id := driver.FrameworkId(); id != "framework-1" {
+		t.Errorf("got framework id %q, want %q", id, "framework-1")
+	}
+
+	running := mesos.TaskState_TASK_RUNNING
+	statuses := []*mesos.TaskStatus{{
+		TaskId:  &mesos.TaskID{Value: proto.String("task-1")},
+		State:   &running,
+		SlaveId: &mesos.SlaveID{Value: proto.String("slave-1")},
+	}}
+	if err := driver.ReconcileTasks(context.Background(), statuses); err != nil {
+		t.Fatal(err)
+	}
+
+	call := <-transport.Calls
+	if call.GetType() != mesos_scheduler.Call_RECONCILE {
+		t.Fatalf("got call %v, want %v", call.GetType(), mesos_scheduler.Call_RECONCILE)
+	}
+	message, err := callToMessage(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcile := message.(*mesos_internal.ReconcileTasksMessage)
+	if id := reconcile.GetFrameworkId().GetValue(); id != "framework-1" {
+		t.Errorf("reconcile for framework %q, want %q", id, "framework-1")
+	}
+	if got := reconcile.GetStatuses(); len(got) != 1 || !proto.Equal(got[0], statuses[0]) {
+		t.Errorf("got statuses %v, want %v", got, statuses)
+	}
+
+	// The master's answers carry no UUID, and are not acknowledged.
+	update := &TaskStateUpdate{TaskId: "task-1", SlaveId: "slave-1", State: running, driver: driver}
+	if err := update.Ack(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(transport.Calls) != 0 {
+		t.Errorf("update without a UUID was acknowledged: %v", <-transport.Calls)
+	}
+}
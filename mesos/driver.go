@@ -1,11 +1,14 @@
 package mesos
 
 import (
-	"net"
+	"errors"
 	"os"
+	"sync"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
 
 	"github.com/twitter/gozer/proto/mesos.pb"
-	"github.com/twitter/gozer/proto/scheduler.pb"
 )
 
 type MasterAddress struct {
@@ -13,71 +16,186 @@ type MasterAddress struct {
 	Port     int
 }
 
-type driverConfig struct {
+// DriverConfig holds the settings used to create a Driver with NewWithConfig.
+type DriverConfig struct {
 	FrameworkName  string
 	RegisteredUser string
 	Masters        []MasterAddress
-	Log            Log
+	// FrameworkId, if set, is the id a previous instance of the framework registered
+	// with. The driver re-registers with it, taking over that instance's tasks.
+	FrameworkId string
+	// FailoverTimeout is how long the master keeps the framework's tasks running
+	// while it has no scheduler connected. If zero, they are killed straight away.
+	FailoverTimeout time.Duration
+	// If Log has no loggers set, the driver logs to stdout and stderr.
+	Log Log
+
+	// Protocol is how the driver talks to the master; libprocess by default.
+	Protocol Protocol
+
+	// Router is the libprocess endpoint the driver registers with. If nil, the
+	// driver creates its own from Endpoint and closes it when it stops.
+	Router   *Router
+	Endpoint RouterConfig
+
+	// Transport, if set, is used to talk to the master instead of the transport
+	// for Protocol. The driver closes it when it stops.
+	Transport Transport
 }
 
 type Driver struct {
-	config  driverConfig
-	pidIp   string
-	pidPort int
+	config    DriverConfig
+	transport Transport
 
+	// The framework id is only written on the driver goroutine, with idLock held so
+	// that FrameworkId can read it.
+	idLock      sync.RWMutex
 	frameworkId mesos.FrameworkID
+	masterInfo  *mesos.MasterInfo
+
+	metrics metrics
 
-	command chan func(*Driver) error
-	// TODO(weingart): move to internal type to handle master disconnect, error events/etc.
-	events chan *mesos_scheduler.Event
+	command chan *command
+	// Stop requests, carrying whether to fail over.
+	stop chan bool
+	// Closed once the driver has stopped.
+	done chan struct{}
+	// Why unregistering failed, if it did; only accessed from the driver goroutine
+	// until done is closed.
+	stopErr error
 
+	// Set by RunScheduler; only accessed from the driver goroutine.
+	callbacks *callbackQueue
+
+	// Error handling state; only accessed from the driver goroutine.
+	err          error
+	pending      *command
+	retries      int
+	retryBackoff time.Duration
+
+	// The reason the driver stopped, if it was stopped by a fatal error.
+	fatalLock sync.Mutex
+	fatal     error
+
+	// When the driver stops, it closes Updates, Offers and Lost, in that order. By
+	// then Err reports why it stopped.
 	Offers  chan *Offer
 	Updates chan *TaskStateUpdate
+	// Lost slaves and executors are published here unless a Scheduler is attached.
+	Lost chan *Lost
 }
 
-func newDriver(mc *driverConfig) (d *Driver, err error) {
-	name, err := os.Hostname()
-	if err != nil {
-		return
-	}
-
-	addrs, err := net.LookupHost(name)
-	if err != nil {
-		return
+func newDriver(mc *DriverConfig) (d *Driver, err error) {
+	config := *mc
+	if config.Log.Info == nil {
+		config.Log = defaultLog()
 	}
 
 	d = &Driver{
-		config:  *mc,
-		pidIp:   addrs[0],
-		pidPort: 8888, // TODO(weingart): use ephemeral port
-		command: make(chan func(*Driver) error),
-		events:  make(chan *mesos_scheduler.Event, 100),
-		Offers:  make(chan *Offer, 100),
-		Updates: make(chan *TaskStateUpdate),
+		config:  config,
+		command: make(chan *command),
+		stop:    make(chan bool),
+		done:    make(chan struct{}),
+
+		retryBackoff: retryBackoff,
+		Offers:       make(chan *Offer, 100),
+		Updates:      make(chan *TaskStateUpdate),
+		Lost:         make(chan *Lost, 100),
+	}
+	if config.FrameworkId != "" {
+		d.frameworkId.Value = proto.String(config.FrameworkId)
+	}
+	if d.transport, err = newTransport(&config, &d.metrics); err != nil {
+		return nil, err
 	}
 
 	return
 }
 
+// New creates a driver for the given framework and master and starts it. The driver
+// serves its own libprocess endpoint, configured from the LIBPROCESS_* environment
+// variables.
 func New(framework, user, master string, port int) (d *Driver, err error) {
-	cf := &driverConfig{
+	return NewWithConfig(DriverConfig{
 		FrameworkName:  framework,
 		RegisteredUser: user,
 		Masters: []MasterAddress{
 			MasterAddress{Hostname: master, Port: port},
 		},
-		// TODO(dhamon): set channel filters based on log level
-		Log: NewLog(LogConfig{
-			Prefix: "driver",
-			Info:   os.Stdout,
-			Warn:   os.Stdout,
-			Error:  os.Stderr},
-		),
-	}
+	})
+}
 
-	if d, err = newDriver(cf); err == nil {
+// NewWithConfig creates a driver from config and starts it.
+func NewWithConfig(config DriverConfig) (d *Driver, err error) {
+	if d, err = newDriver(&config); err == nil {
 		go d.Run()
 	}
 
 	return
 }
+
+func defaultLog() Log {
+	// TODO(dhamon): set channel filters based on log level
+	return NewLog(LogConfig{
+		Prefix: "driver",
+		Info:   os.Stdout,
+		Warn:   os.Stdout,
+		Error:  os.Stderr},
+	)
+}
+
+// ErrStopped is returned by calls made on a driver that has stopped.
+var ErrStopped = errors.New("driver is stopped")
+
+// ErrNotReady is returned by calls made while the driver recovers from an error,
+// such as while it reconnects to the master.
+var ErrNotReady = errors.New("driver is not ready")
+
+// Stop stops the driver and waits for it to finish. Without failover, the framework
+// is unregistered and the master kills its tasks. With failover, the driver just
+// disconnects, leaving the tasks running for another instance of the framework to
+// take over.
+//
+// The driver may be blocked delivering an update, so Updates must still be read
+// until Stop returns. The error is the reason unregistering failed, if it did.
+func (d *Driver) Stop(failover bool) error {
+	if failover && d.config.FailoverTimeout == 0 {
+		d.config.Log.Warn.Println("Failing over without a failover timeout; the master kills our tasks straight away")
+	}
+
+	select {
+	case d.stop <- failover:
+	case <-d.done:
+		return nil
+	}
+
+	<-d.done
+	return d.stopErr
+}
+
+// FrameworkId returns the id the framework is registered with, or the empty string
+// if it has not registered yet.
+func (d *Driver) FrameworkId() string {
+	d.idLock.RLock()
+	defer d.idLock.RUnlock()
+	return d.frameworkId.GetValue()
+}
+
+// FrameworkError is the error that stops the driver when the master rejects or
+// removes the framework.
+type FrameworkError struct {
+	Message string
+}
+
+func (e *FrameworkError) Error() string {
+	return "framework error from master: " + e.Message
+}
+
+// Err returns the fatal error that stopped the driver, or nil if the driver is still
+// running or was stopped cleanly. If the master removed the framework, it is a
+// *FrameworkError.
+func (d *Driver) Err() error {
+	d.fatalLock.Lock()
+	defer d.fatalLock.Unlock()
+	return d.fatal
+}
@@ -0,0 +1,101 @@
+package mesos
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Environment variables understood by libprocess, honoured here for compatibility
+// with frameworks using the C++ bindings.
+const (
+	envIP            = "LIBPROCESS_IP"
+	envPort          = "LIBPROCESS_PORT"
+	envAdvertiseIP   = "LIBPROCESS_ADVERTISE_IP"
+	envAdvertisePort = "LIBPROCESS_ADVERTISE_PORT"
+)
+
+// applyEnvironment fills in any unset endpoint settings from the environment.
+func (c *RouterConfig) applyEnvironment() error {
+	if c.BindAddress == "" {
+		c.BindAddress = os.Getenv(envIP)
+	}
+	if c.AdvertiseIP == "" {
+		c.AdvertiseIP = os.Getenv(envAdvertiseIP)
+	}
+
+	var err error
+	if c.Port == 0 {
+		if c.Port, err = portFromEnv(envPort); err != nil {
+			return err
+		}
+	}
+	if c.AdvertisePort == 0 {
+		if c.AdvertisePort, err = portFromEnv(envAdvertisePort); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func portFromEnv(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q in $%s", value, name)
+	}
+	return port, nil
+}
+
+// listen binds the libprocess endpoint described by config, and returns the listener
+// along with the IP and port to advertise to peers.
+func listen(config *RouterConfig) (listener net.Listener, ip string, port int, err error) {
+	if err = config.applyEnvironment(); err != nil {
+		return
+	}
+
+	listener, err = net.Listen("tcp", net.JoinHostPort(config.BindAddress, strconv.Itoa(config.Port)))
+	if err != nil {
+		err = fmt.Errorf("failed to listen on %s:%d: %+v", config.BindAddress, config.Port, err)
+		return
+	}
+
+	port = listener.Addr().(*net.TCPAddr).Port
+	if config.AdvertisePort != 0 {
+		port = config.AdvertisePort
+	}
+
+	if ip, err = advertiseIP(config); err != nil {
+		listener.Close()
+		listener = nil
+	}
+	return
+}
+
+func advertiseIP(config *RouterConfig) (string, error) {
+	if config.AdvertiseIP != "" {
+		return config.AdvertiseIP, nil
+	}
+
+	if bind := net.ParseIP(config.BindAddress); bind != nil && !bind.IsUnspecified() {
+		return config.BindAddress, nil
+	}
+
+	name, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := net.LookupHost(name)
+	if err != nil {
+		return "", err
+	}
+
+	return addrs[0], nil
+}
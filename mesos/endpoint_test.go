@@ -0,0 +1,53 @@
+package mesos
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestListenEphemeralPort(t *testing.T) {
+	config := &RouterConfig{BindAddress: "127.0.0.1"}
+	listener, ip, port, err := listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	bound := listener.Addr().(*net.TCPAddr).Port
+	if bound == 0 || port != bound {
+		t.Errorf("got advertised port %d, bound port %d; want the same non-zero port", port, bound)
+	}
+	if ip != "127.0.0.1" {
+		t.Errorf("got advertised ip %q, want the bind address", ip)
+	}
+}
+
+func TestListenAdvertise(t *testing.T) {
+	defer os.Unsetenv(envAdvertiseIP)
+	defer os.Unsetenv(envAdvertisePort)
+	os.Setenv(envAdvertiseIP, "10.0.0.1")
+	os.Setenv(envAdvertisePort, "31000")
+
+	config := &RouterConfig{BindAddress: "127.0.0.1"}
+	listener, ip, port, err := listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if ip != "10.0.0.1" || port != 31000 {
+		t.Errorf("got advertised %s:%d, want 10.0.0.1:31000", ip, port)
+	}
+}
+
+func TestListenBadPort(t *testing.T) {
+	defer os.Unsetenv(envPort)
+	os.Setenv(envPort, strconv.Itoa(70000))
+
+	if listener, _, _, err := listen(&RouterConfig{}); err == nil {
+		listener.Close()
+		t.Errorf("listen with $%s=70000 succeeded, want error", envPort)
+	}
+}
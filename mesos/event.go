@@ -1,6 +1,7 @@
 package mesos
 
 import (
+	"errors"
 	"fmt"
 
 	"code.google.com/p/goprotobuf/proto"
@@ -9,127 +10,211 @@ import (
 	"github.com/twitter/gozer/proto/scheduler.pb"
 )
 
-func bytesToEvent(protoType string, data []byte) (*mesos_scheduler.Event, error) {
-	switch protoType {
-	case "mesos.internal.FrameworkRegisteredMessage":
-		message := new(mesos_internal.FrameworkRegisteredMessage)
-		err := proto.Unmarshal(data, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %q into message of type %q: %+v", string(data), protoType, err)
-		}
-		eventType := mesos_scheduler.Event_REGISTERED
-		return &mesos_scheduler.Event{
-			Type: &eventType,
-			Registered: &mesos_scheduler.Event_Registered{
-				FrameworkId: message.FrameworkId,
-				MasterInfo:  message.MasterInfo,
-			},
-		}, nil
+// errUnknownMessage is returned by bytesToEvent for messages the driver does not act on.
+var errUnknownMessage = errors.New("unknown message type")
 
-	case "mesos.internal.FrameworkReregisteredMessage":
-		message := new(mesos_internal.FrameworkReregisteredMessage)
-		err := proto.Unmarshal(data, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %q into message of type %q: %+v", string(data), protoType, err)
-		}
-		eventType := mesos_scheduler.Event_REREGISTERED
-		return &mesos_scheduler.Event{
-			Type: &eventType,
-			Reregistered: &mesos_scheduler.Event_Reregistered{
-				FrameworkId: message.FrameworkId,
-				MasterInfo:  message.MasterInfo,
-			},
-		}, nil
-
-	case "mesos.internal.ResourceOffersMessage":
-		message := new(mesos_internal.ResourceOffersMessage)
-		err := proto.Unmarshal(data, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %q into message of type %q: %+v", string(data), protoType, err)
-		}
-		eventType := mesos_scheduler.Event_OFFERS
-		return &mesos_scheduler.Event{
-			Type: &eventType,
-			Offers: &mesos_scheduler.Event_Offers{
-				Offers: message.Offers,
-			},
-		}, nil
+// A messageType describes a libprocess message the driver knows how to decode.
+type messageType struct {
+	// new returns an empty message to unmarshal into.
+	new func() proto.Message
+	// toEvent converts a message into the event it tells the scheduler about. It is
+	// nil for messages the driver does not act on.
+	toEvent func(proto.Message) *mesos_scheduler.Event
+}
 
-	case "mesos.internal.RescindResourceOfferMessage":
-		message := new(mesos_internal.RescindResourceOfferMessage)
-		err := proto.Unmarshal(data, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %q into message of type %q: %+v", string(data), protoType, err)
-		}
-		eventType := mesos_scheduler.Event_RESCIND
-		return &mesos_scheduler.Event{
-			Type: &eventType,
-			Rescind: &mesos_scheduler.Event_Rescind{
-				OfferId: message.OfferId,
-			},
-		}, nil
+// messageTypes holds every message in messages.pb, keyed by libprocess message name.
+var messageTypes = map[string]messageType{
+	"mesos.internal.ExecutorToFrameworkMessage": {
+		new: func() proto.Message { return new(mesos_internal.ExecutorToFrameworkMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.ExecutorToFrameworkMessage)
+			eventType := mesos_scheduler.Event_MESSAGE
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Message: &mesos_scheduler.Event_Message{
+					SlaveId:    message.SlaveId,
+					ExecutorId: message.ExecutorId,
+					Data:       message.Data,
+				},
+			}
+		},
+	},
+	"mesos.internal.FrameworkRegisteredMessage": {
+		new: func() proto.Message { return new(mesos_internal.FrameworkRegisteredMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.FrameworkRegisteredMessage)
+			eventType := mesos_scheduler.Event_REGISTERED
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Registered: &mesos_scheduler.Event_Registered{
+					FrameworkId: message.FrameworkId,
+					MasterInfo:  message.MasterInfo,
+				},
+			}
+		},
+	},
+	"mesos.internal.FrameworkReregisteredMessage": {
+		new: func() proto.Message { return new(mesos_internal.FrameworkReregisteredMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.FrameworkReregisteredMessage)
+			eventType := mesos_scheduler.Event_REREGISTERED
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Reregistered: &mesos_scheduler.Event_Reregistered{
+					FrameworkId: message.FrameworkId,
+					MasterInfo:  message.MasterInfo,
+				},
+			}
+		},
+	},
+	"mesos.internal.ResourceOffersMessage": {
+		new: func() proto.Message { return new(mesos_internal.ResourceOffersMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.ResourceOffersMessage)
+			eventType := mesos_scheduler.Event_OFFERS
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Offers: &mesos_scheduler.Event_Offers{
+					Offers: message.Offers,
+				},
+			}
+		},
+	},
+	"mesos.internal.RescindResourceOfferMessage": {
+		new: func() proto.Message { return new(mesos_internal.RescindResourceOfferMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.RescindResourceOfferMessage)
+			eventType := mesos_scheduler.Event_RESCIND
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Rescind: &mesos_scheduler.Event_Rescind{
+					OfferId: message.OfferId,
+				},
+			}
+		},
+	},
+	"mesos.internal.StatusUpdateMessage": {
+		new: func() proto.Message { return new(mesos_internal.StatusUpdateMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.StatusUpdateMessage)
+			eventType := mesos_scheduler.Event_UPDATE
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Update: &mesos_scheduler.Event_Update{
+					Uuid:   message.GetUpdate().Uuid,
+					Status: message.GetUpdate().Status,
+				},
+			}
+		},
+	},
+	"mesos.internal.FrameworkErrorMessage": {
+		new: func() proto.Message { return new(mesos_internal.FrameworkErrorMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.FrameworkErrorMessage)
+			eventType := mesos_scheduler.Event_ERROR
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Error: &mesos_scheduler.Event_Error{
+					Message: message.Message,
+				},
+			}
+		},
+	},
+	"mesos.internal.ExitedExecutorMessage": {
+		new: func() proto.Message { return new(mesos_internal.ExitedExecutorMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.ExitedExecutorMessage)
+			eventType := mesos_scheduler.Event_FAILURE
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Failure: &mesos_scheduler.Event_Failure{
+					SlaveId:    message.SlaveId,
+					ExecutorId: message.ExecutorId,
+					Status:     message.Status,
+				},
+			}
+		},
+	},
+	"mesos.internal.LostSlaveMessage": {
+		new: func() proto.Message { return new(mesos_internal.LostSlaveMessage) },
+		toEvent: func(m proto.Message) *mesos_scheduler.Event {
+			message := m.(*mesos_internal.LostSlaveMessage)
+			eventType := mesos_scheduler.Event_FAILURE
+			return &mesos_scheduler.Event{
+				Type: &eventType,
+				Failure: &mesos_scheduler.Event_Failure{
+					SlaveId: message.SlaveId,
+				},
+			}
+		},
+	},
 
-	case "mesos.internal.StatusUpdateMessage":
-		message := new(mesos_internal.StatusUpdateMessage)
-		err := proto.Unmarshal(data, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %q into message of type %q: %+v", string(data), protoType, err)
-		}
-		eventType := mesos_scheduler.Event_UPDATE
-		return &mesos_scheduler.Event{
-			Type: &eventType,
-			Update: &mesos_scheduler.Event_Update{
-				Uuid:   message.Update.Uuid,
-				Status: message.Update.Status,
-			},
-		}, nil
+	// Messages that are not sent to schedulers, or that the driver ignores.
+	"mesos.internal.FrameworkToExecutorMessage":         {new: func() proto.Message { return new(mesos_internal.FrameworkToExecutorMessage) }},
+	"mesos.internal.RegisterFrameworkMessage":           {new: func() proto.Message { return new(mesos_internal.RegisterFrameworkMessage) }},
+	"mesos.internal.ReregisterFrameworkMessage":         {new: func() proto.Message { return new(mesos_internal.ReregisterFrameworkMessage) }},
+	"mesos.internal.UnregisterFrameworkMessage":         {new: func() proto.Message { return new(mesos_internal.UnregisterFrameworkMessage) }},
+	"mesos.internal.DeactivateFrameworkMessage":         {new: func() proto.Message { return new(mesos_internal.DeactivateFrameworkMessage) }},
+	"mesos.internal.ResourceRequestMessage":             {new: func() proto.Message { return new(mesos_internal.ResourceRequestMessage) }},
+	"mesos.internal.LaunchTasksMessage":                 {new: func() proto.Message { return new(mesos_internal.LaunchTasksMessage) }},
+	"mesos.internal.ReviveOffersMessage":                {new: func() proto.Message { return new(mesos_internal.ReviveOffersMessage) }},
+	"mesos.internal.RunTaskMessage":                     {new: func() proto.Message { return new(mesos_internal.RunTaskMessage) }},
+	"mesos.internal.KillTaskMessage":                    {new: func() proto.Message { return new(mesos_internal.KillTaskMessage) }},
+	"mesos.internal.StatusUpdateAcknowledgementMessage": {new: func() proto.Message { return new(mesos_internal.StatusUpdateAcknowledgementMessage) }},
+	"mesos.internal.ReconcileTasksMessage":              {new: func() proto.Message { return new(mesos_internal.ReconcileTasksMessage) }},
+	"mesos.internal.RegisterSlaveMessage":               {new: func() proto.Message { return new(mesos_internal.RegisterSlaveMessage) }},
+	"mesos.internal.ReregisterSlaveMessage":             {new: func() proto.Message { return new(mesos_internal.ReregisterSlaveMessage) }},
+	"mesos.internal.SlaveRegisteredMessage":             {new: func() proto.Message { return new(mesos_internal.SlaveRegisteredMessage) }},
+	"mesos.internal.SlaveReregisteredMessage":           {new: func() proto.Message { return new(mesos_internal.SlaveReregisteredMessage) }},
+	"mesos.internal.UnregisterSlaveMessage":             {new: func() proto.Message { return new(mesos_internal.UnregisterSlaveMessage) }},
+	"mesos.internal.HeartbeatMessage":                   {new: func() proto.Message { return new(mesos_internal.HeartbeatMessage) }},
+	"mesos.internal.ShutdownFrameworkMessage":           {new: func() proto.Message { return new(mesos_internal.ShutdownFrameworkMessage) }},
+	"mesos.internal.ShutdownExecutorMessage":            {new: func() proto.Message { return new(mesos_internal.ShutdownExecutorMessage) }},
+	"mesos.internal.UpdateFrameworkMessage":             {new: func() proto.Message { return new(mesos_internal.UpdateFrameworkMessage) }},
+	"mesos.internal.RegisterExecutorMessage":            {new: func() proto.Message { return new(mesos_internal.RegisterExecutorMessage) }},
+	"mesos.internal.ExecutorRegisteredMessage":          {new: func() proto.Message { return new(mesos_internal.ExecutorRegisteredMessage) }},
+	"mesos.internal.ExecutorReregisteredMessage":        {new: func() proto.Message { return new(mesos_internal.ExecutorReregisteredMessage) }},
+	"mesos.internal.ReconnectExecutorMessage":           {new: func() proto.Message { return new(mesos_internal.ReconnectExecutorMessage) }},
+	"mesos.internal.ReregisterExecutorMessage":          {new: func() proto.Message { return new(mesos_internal.ReregisterExecutorMessage) }},
+	"mesos.internal.RegisterProjdMessage":               {new: func() proto.Message { return new(mesos_internal.RegisterProjdMessage) }},
+	"mesos.internal.ProjdReadyMessage":                  {new: func() proto.Message { return new(mesos_internal.ProjdReadyMessage) }},
+	"mesos.internal.ProjdUpdateResourcesMessage":        {new: func() proto.Message { return new(mesos_internal.ProjdUpdateResourcesMessage) }},
+	"mesos.internal.FrameworkExpiredMessage":            {new: func() proto.Message { return new(mesos_internal.FrameworkExpiredMessage) }},
+	"mesos.internal.ShutdownMessage":                    {new: func() proto.Message { return new(mesos_internal.ShutdownMessage) }},
+	"mesos.internal.AuthenticateMessage":                {new: func() proto.Message { return new(mesos_internal.AuthenticateMessage) }},
+	"mesos.internal.AuthenticationMechanismsMessage":    {new: func() proto.Message { return new(mesos_internal.AuthenticationMechanismsMessage) }},
+	"mesos.internal.AuthenticationStartMessage":         {new: func() proto.Message { return new(mesos_internal.AuthenticationStartMessage) }},
+	"mesos.internal.AuthenticationStepMessage":          {new: func() proto.Message { return new(mesos_internal.AuthenticationStepMessage) }},
+	"mesos.internal.AuthenticationCompletedMessage":     {new: func() proto.Message { return new(mesos_internal.AuthenticationCompletedMessage) }},
+	"mesos.internal.AuthenticationFailedMessage":        {new: func() proto.Message { return new(mesos_internal.AuthenticationFailedMessage) }},
+	"mesos.internal.AuthenticationErrorMessage":         {new: func() proto.Message { return new(mesos_internal.AuthenticationErrorMessage) }},
+}
 
-	case "mesos.internal.ExitedExecutorMessage":
-		message := new(mesos_internal.ExitedExecutorMessage)
-		err := proto.Unmarshal(data, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %q into message of type %q: %+v", string(data), protoType, err)
-		}
-		eventType := mesos_scheduler.Event_FAILURE
-		return &mesos_scheduler.Event{
-			Type: &eventType,
-			Failure: &mesos_scheduler.Event_Failure{
-				SlaveId: message.SlaveId,
-				ExecutorId: message.ExecutorId,
-				Status: message.Status,
-			},
-		}, nil
+// decodeMessage unmarshals a libprocess message of the named type.
+func decodeMessage(name string, data []byte) (proto.Message, error) {
+	messageType, ok := messageTypes[name]
+	if !ok {
+		return nil, errUnknownMessage
+	}
 
-	case "mesos.internal.ExecutorToFrameworkMessage":
-		message := new(mesos_internal.ExecutorToFrameworkMessage)
-		err := proto.Unmarshal(data, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %q into message of type %q: %+v", string(data), protoType, err)
-		}
-		eventType := mesos_scheduler.Event_MESSAGE
-		return &mesos_scheduler.Event{
-			Type: &eventType,
-			Message: &mesos_scheduler.Event_Message{
-				SlaveId: message.SlaveId,
-				ExecutorId: message.ExecutorId,
-				Data: data,
-			},
-		}, nil
+	message := messageType.new()
+	if err := proto.Unmarshal(data, message); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %q into message of type %q: %+v", string(data), name, err)
+	}
+	return message, nil
+}
 
-	case "mesos.internal.FrameworkErrorMessage":
-		message := new(mesos_internal.FrameworkErrorMessage)
-		err := proto.Unmarshal(data, message)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %q into message of type %q: %+v", string(data), protoType, err)
-		}
-		eventType := mesos_scheduler.Event_ERROR
-		return &mesos_scheduler.Event{
-			Type: &eventType,
-			Error: &mesos_scheduler.Event_Error{
-				Message: message.Message,
-			},
-		}, nil
+// bytesToEvent decodes a libprocess message of the named type into an event. It
+// returns errUnknownMessage if the driver does not act on messages of that type.
+func bytesToEvent(name string, data []byte) (*mesos_scheduler.Event, error) {
+	messageType, ok := messageTypes[name]
+	if !ok || messageType.toEvent == nil {
+		return nil, errUnknownMessage
 	}
 
-	return nil, fmt.Errorf("unimplemented event type %q", protoType)
+	message, err := decodeMessage(name, data)
+	if err != nil {
+		return nil, err
+	}
+	return messageType.toEvent(message), nil
 }
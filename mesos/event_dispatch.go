@@ -14,8 +14,13 @@ func (d *Driver) eventDispatch(event *mesos_scheduler.Event) error {
 
 	case mesos_scheduler.Event_REREGISTERED:
 		d.config.Log.Info.Println("Event REREGISTERED:", event)
+		d.masterInfo = event.Reregistered.MasterInfo
+
+		masterInfo := d.masterInfo
+		d.schedule(func(s Scheduler) { s.Reregistered(d, masterInfo) })
 
 	case mesos_scheduler.Event_OFFERS:
+		var offers []*Offer
 		for _, offer := range event.Offers.Offers {
 			if *offer.FrameworkId.Value != *d.frameworkId.Value {
 				d.config.Log.Warn.Printf("unexpected framework in offer: want %q, got %q",
@@ -23,12 +28,22 @@ func (d *Driver) eventDispatch(event *mesos_scheduler.Event) error {
 				continue
 			}
 
+			offers = append(offers, &Offer{
+				Id:         *offer.Id.Value,
+				SlaveId:    offer.GetSlaveId().GetValue(),
+				Hostname:   offer.GetHostname(),
+				driver:     d,
+				mesosOffer: offer,
+			})
+		}
+
+		if d.schedule(func(s Scheduler) { s.ResourceOffers(d, offers) }) {
+			break
+		}
+
+		for _, offer := range offers {
 			if len(d.Offers) < cap(d.Offers) {
-				d.Offers <- &Offer{
-					Id:	    *offer.Id.Value,
-					driver:	    d,
-					mesosOffer: offer,
-				}
+				d.Offers <- offer
 			} else {
 				// TODO(weingart): how to ignore/return offer?
 				d.config.Log.Warn.Println("ignoring offer that we have no capacity for:", offer)
@@ -38,6 +53,9 @@ func (d *Driver) eventDispatch(event *mesos_scheduler.Event) error {
 	case mesos_scheduler.Event_RESCIND:
 		d.config.Log.Info.Printf("Event RESCIND: %+v", event)
 
+		offerId := event.Rescind.GetOfferId().GetValue()
+		d.schedule(func(s Scheduler) { s.OfferRescinded(d, offerId) })
+
 	case mesos_scheduler.Event_UPDATE:
 		d.config.Log.Info.Printf("Event UPDATE: %+v", event)
 
@@ -50,13 +68,17 @@ func (d *Driver) eventDispatch(event *mesos_scheduler.Event) error {
 			mesos.TaskState_TASK_KILLED,
 			mesos.TaskState_TASK_LOST:
 
-			d.Updates <- &TaskStateUpdate{
+			update := &TaskStateUpdate{
 				TaskId:  event.Update.Status.GetTaskId().GetValue(),
 				SlaveId: event.Update.Status.GetSlaveId().GetValue(),
 				State:   event.Update.Status.GetState(),
+				Message: event.Update.Status.GetMessage(),
 				uuid:    event.Update.GetUuid(),
 				driver:  d,
 			}
+			if !d.schedule(func(s Scheduler) { s.StatusUpdate(d, update) }) {
+				d.Updates <- update
+			}
 		default:
 			d.config.Log.Error.Printf("Unknown Event_UPDATE: %+v", event)
 		}
@@ -64,11 +86,45 @@ func (d *Driver) eventDispatch(event *mesos_scheduler.Event) error {
 	case mesos_scheduler.Event_MESSAGE:
 		d.config.Log.Info.Printf("Event MESSAGE: %+v", event)
 
+		executorId := event.Message.GetExecutorId().GetValue()
+		slaveId := event.Message.GetSlaveId().GetValue()
+		data := event.Message.GetData()
+		d.schedule(func(s Scheduler) { s.FrameworkMessage(d, executorId, slaveId, data) })
+
 	case mesos_scheduler.Event_FAILURE:
 		d.config.Log.Info.Printf("Event FAILURE: %+v", event)
 
+		lost := &Lost{
+			SlaveId:    event.Failure.GetSlaveId().GetValue(),
+			ExecutorId: event.Failure.GetExecutorId().GetValue(),
+			Status:     int(event.Failure.GetStatus()),
+		}
+		if lost.SlaveId == "" && lost.ExecutorId == "" {
+			d.config.Log.Warn.Println("Ignoring FAILURE that names no slave or executor:", event)
+			break
+		}
+		if lost.SlaveLost() {
+			if d.schedule(func(s Scheduler) { s.SlaveLost(d, lost.SlaveId) }) {
+				break
+			}
+		} else if d.schedule(func(s Scheduler) { s.ExecutorLost(d, lost.ExecutorId, lost.SlaveId, lost.Status) }) {
+			break
+		}
+
+		if len(d.Lost) < cap(d.Lost) {
+			d.Lost <- lost
+		} else {
+			d.config.Log.Warn.Println("dropping lost event that we have no capacity for:", lost)
+		}
+
 	case mesos_scheduler.Event_ERROR:
-		d.config.Log.Info.Printf("Event ERROR: %+v", event)
+		d.config.Log.Error.Printf("Event ERROR: %+v", event)
+
+		message := event.Error.GetMessage()
+		d.schedule(func(s Scheduler) { s.Error(d, message) })
+
+		// The master has rejected or removed us; there is nothing left to do.
+		return &driverError{id: errorFatal, err: &FrameworkError{Message: message}}
 
 	default:
 		err := fmt.Errorf("unexpected event type: %q", event.Type)
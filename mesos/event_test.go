@@ -0,0 +1,318 @@
+package mesos
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+	"github.com/twitter/gozer/proto/messages.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+// Messages in messages.pb that are only ever sent inside other messages or stored,
+// and so are not registered as libprocess messages.
+var payloadMessages = map[string]func() proto.Message{
+	"Task":                    func() proto.Message { return new(mesos_internal.Task) },
+	"RoleInfo":                func() proto.Message { return new(mesos_internal.RoleInfo) },
+	"StatusUpdate":            func() proto.Message { return new(mesos_internal.StatusUpdate) },
+	"StatusUpdateRecord":      func() proto.Message { return new(mesos_internal.StatusUpdateRecord) },
+	"SubmitSchedulerRequest":  func() proto.Message { return new(mesos_internal.SubmitSchedulerRequest) },
+	"SubmitSchedulerResponse": func() proto.Message { return new(mesos_internal.SubmitSchedulerResponse) },
+	"Archive":                 func() proto.Message { return new(mesos_internal.Archive) },
+	"Archive_Framework":       func() proto.Message { return new(mesos_internal.Archive_Framework) },
+	"TaskHealthStatus":        func() proto.Message { return new(mesos_internal.TaskHealthStatus) },
+}
+
+// fill sets every field of v, a message struct, so that a round trip exercises all
+// of them. Optional messages are left out past a certain depth in case of cycles.
+func fill(v reflect.Value, depth int) {
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get("protobuf")
+		if tag == "" {
+			continue
+		}
+		required := strings.Contains(tag, ",req,")
+
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Ptr:
+			if field.Type().Elem().Kind() == reflect.Struct && depth > 5 && !required {
+				continue
+			}
+			field.Set(reflect.New(field.Type().Elem()))
+			fillValue(field.Elem(), depth+1)
+
+		case reflect.Slice:
+			if field.Type().Elem().Kind() == reflect.Uint8 {
+				field.SetBytes([]byte("data"))
+				continue
+			}
+			if depth > 5 {
+				continue
+			}
+			element := reflect.New(field.Type().Elem()).Elem()
+			if element.Kind() == reflect.Ptr {
+				element.Set(reflect.New(element.Type().Elem()))
+				fillValue(element.Elem(), depth+1)
+			} else {
+				fillValue(element, depth+1)
+			}
+			field.Set(reflect.Append(field, element))
+		}
+	}
+}
+
+func fillValue(v reflect.Value, depth int) {
+	switch v.Kind() {
+	case reflect.Struct:
+		fill(v, depth)
+	case reflect.String:
+		v.SetString("value")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Int32, reflect.Int64:
+		if _, ok := v.Interface().(fmt.Stringer); !ok {
+			v.SetInt(1)
+			return
+		}
+		// An enum: find a value it has a name for.
+		for n := 0; n < 100; n++ {
+			v.SetInt(int64(n))
+			if v.Interface().(fmt.Stringer).String() != strconv.Itoa(n) {
+				return
+			}
+		}
+	}
+}
+
+func TestMessageTypesCoverMessagesPb(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "../proto/messages.pb/messages.pb.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.TypeSpec)
+			if _, ok := spec.Type.(*ast.StructType); !ok {
+				continue
+			}
+			count++
+			_, registered := messageTypes["mesos.internal."+spec.Name.Name]
+			_, payload := payloadMessages[spec.Name.Name]
+			if !registered && !payload {
+				t.Errorf("message %s is not registered", spec.Name.Name)
+			}
+		}
+	}
+	if count != len(messageTypes)+len(payloadMessages) {
+		t.Errorf("found %d messages, but %d are registered and %d are payloads",
+			count, len(messageTypes), len(payloadMessages))
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	roundTrip := func(name string, decode func([]byte) (proto.Message, error), message proto.Message) {
+		fill(reflect.ValueOf(message).Elem(), 0)
+		data, err := proto.Marshal(message)
+		if err != nil {
+			t.Errorf("%s: marshal: %v", name, err)
+			return
+		}
+		decoded, err := decode(data)
+		if err != nil {
+			t.Errorf("%s: decode: %v", name, err)
+			return
+		}
+		if !proto.Equal(message, decoded) {
+			t.Errorf("%s: round trip changed the message:\n%v\n%v", name, message, decoded)
+		}
+	}
+
+	for name, messageType := range messageTypes {
+		roundTrip(name, func(data []byte) (proto.Message, error) {
+			return decodeMessage(name, data)
+		}, messageType.new())
+	}
+	for name, newMessage := range payloadMessages {
+		roundTrip(name, func(data []byte) (proto.Message, error) {
+			message := newMessage()
+			return message, proto.Unmarshal(data, message)
+		}, newMessage())
+	}
+}
+
+func TestBytesToEvent(t *testing.T) {
+	tests := []struct {
+		message proto.Message
+		name    string
+		want    mesos_scheduler.Event_Type
+	}{
+		{new(mesos_internal.FrameworkRegisteredMessage), "FrameworkRegisteredMessage", mesos_scheduler.Event_REGISTERED},
+		{new(mesos_internal.FrameworkReregisteredMessage), "FrameworkReregisteredMessage", mesos_scheduler.Event_REREGISTERED},
+		{new(mesos_internal.ResourceOffersMessage), "ResourceOffersMessage", mesos_scheduler.Event_OFFERS},
+		{new(mesos_internal.RescindResourceOfferMessage), "RescindResourceOfferMessage", mesos_scheduler.Event_RESCIND},
+		{new(mesos_internal.StatusUpdateMessage), "StatusUpdateMessage", mesos_scheduler.Event_UPDATE},
+		{new(mesos_internal.ExitedExecutorMessage), "ExitedExecutorMessage", mesos_scheduler.Event_FAILURE},
+		{new(mesos_internal.LostSlaveMessage), "LostSlaveMessage", mesos_scheduler.Event_FAILURE},
+		{new(mesos_internal.ExecutorToFrameworkMessage), "ExecutorToFrameworkMessage", mesos_scheduler.Event_MESSAGE},
+		{new(mesos_internal.FrameworkErrorMessage), "FrameworkErrorMessage", mesos_scheduler.Event_ERROR},
+	}
+
+	for _, test := range tests {
+		fill(reflect.ValueOf(test.message).Elem(), 0)
+		data, err := proto.Marshal(test.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, err := bytesToEvent("mesos.internal."+test.name, data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if event.GetType() != test.want {
+			t.Errorf("%s: got event %v, want %v", test.name, event.GetType(), test.want)
+		}
+	}
+
+	message := &mesos_internal.ExecutorToFrameworkMessage{}
+	fill(reflect.ValueOf(message).Elem(), 0)
+	message.Data = []byte("hello")
+	data, _ := proto.Marshal(message)
+	if event, _ := bytesToEvent("mesos.internal.ExecutorToFrameworkMessage", data); string(event.GetMessage().GetData()) != "hello" {
+		t.Errorf("framework message data: got %q, want %q", event.GetMessage().GetData(), "hello")
+	}
+
+	for _, name := range []string{"mesos.internal.NoSuchMessage", "mesos.internal.RegisterSlaveMessage"} {
+		if _, err := bytesToEvent(name, nil); err != errUnknownMessage {
+			t.Errorf("%s: got %v, want %v", name, err, errUnknownMessage)
+		}
+	}
+}
+
+func TestLostPublished(t *testing.T) {
+	d := newTestDriver()
+	d.Lost = make(chan *Lost, 2)
+
+	failure := mesos_scheduler.Event_FAILURE
+	status := int32(137)
+	for _, event := range []*mesos_scheduler.Event{
+		{Type: &failure, Failure: &mesos_scheduler.Event_Failure{
+			SlaveId: &mesos.SlaveID{Value: proto.String("slave-1")},
+		}},
+		{Type: &failure, Failure: &mesos_scheduler.Event_Failure{
+			SlaveId:    &mesos.SlaveID{Value: proto.String("slave-2")},
+			ExecutorId: &mesos.ExecutorID{Value: proto.String("task-1")},
+			Status:     &status,
+		}},
+	} {
+		if err := d.eventDispatch(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lost := <-d.Lost; !lost.SlaveLost() || lost.SlaveId != "slave-1" {
+		t.Errorf("got %v, want slave-1 lost", lost)
+	}
+	want := Lost{SlaveId: "slave-2", ExecutorId: "task-1", Status: 137}
+	if lost := <-d.Lost; lost.SlaveLost() || *lost != want {
+		t.Errorf("got %v, want %v", lost, &want)
+	}
+}
+
+func TestUnknownMessageIsCounted(t *testing.T) {
+	transport := &libprocessTransport{
+		log:     NewLog(LogConfig{}),
+		masters: []MasterAddress{{Hostname: "127.0.0.1", Port: 5050}},
+		metrics: &metrics{},
+		name:    "framework",
+		events:  make(chan *mesos_scheduler.Event, 1),
+		done:    make(chan struct{}),
+	}
+
+	r, err := http.NewRequest("POST", "/framework/mesos.internal.NoSuchMessage", bytes.NewReader([]byte("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Libprocess-From", "master@127.0.0.1:5050")
+
+	w := httptest.NewRecorder()
+	transport.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := transport.metrics.snapshot(); got.MessagesUnknown != 1 || got.MessagesReceived != 0 {
+		t.Errorf("got metrics %+v, want one unknown message", got)
+	}
+	if len(transport.events) != 0 {
+		t.Errorf("unknown message was delivered as an event")
+	}
+}
+
+// TestCloseWhileDelivering checks that messages still being delivered when the
+// transport closes are refused, rather than sent on to a driver that has stopped.
+func TestCloseWhileDelivering(t *testing.T) {
+	transport := &libprocessTransport{
+		log:     NewLog(LogConfig{}),
+		masters: []MasterAddress{{Hostname: "127.0.0.1", Port: 5050}},
+		metrics: &metrics{},
+		router:  &Router{processes: map[string]http.Handler{}},
+		name:    "framework",
+		events:  make(chan *mesos_scheduler.Event),
+		done:    make(chan struct{}),
+	}
+	data, err := proto.Marshal(&mesos_internal.RescindResourceOfferMessage{
+		OfferId: &mesos.OfferID{Value: proto.String("offer-1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	codes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			r := httptest.NewRequest("POST", "/framework/mesos.internal.RescindResourceOfferMessage", bytes.NewReader(data))
+			r.Header.Set("Libprocess-From", "master@127.0.0.1:5050")
+			w := httptest.NewRecorder()
+			transport.ServeHTTP(w, r)
+			codes <- w.Code
+		}()
+	}
+
+	// Nobody reads the events, so every handler is left waiting to deliver.
+	for atomic.LoadUint64(&transport.metrics.messagesReceived) < n {
+		time.Sleep(time.Millisecond)
+	}
+	if err := transport.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if code := <-codes; code != http.StatusServiceUnavailable {
+			t.Errorf("got status %d, want %d", code, http.StatusServiceUnavailable)
+		}
+	}
+}
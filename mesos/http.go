@@ -5,57 +5,74 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
-)
-
-func startServing(d *Driver) {
+	"sync/atomic"
 
-	// TODO(weingart): Grab an ephemeral port for this instead and toss it into MesosMaster
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", func(rw http.ResponseWriter, req *http.Request) {
-		fmt.Fprint(rw, "OK\r\n")
-	})
-	mux.Handle("/", d)
-
-	d.config.Log.Info.Println("Listening on port", d.pidPort)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", d.pidPort), mux); err != nil {
-		d.config.Log.Error.Fatal("failed to start listening on port", d.pidPort)
-	}
-}
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
 
-func (d *Driver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (t *libprocessTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		w.Header().Add("Allow", "POST")
 		w.WriteHeader(http.StatusMethodNotAllowed)
-		d.config.Log.Error.Println("received request with unexpected method. want \"POST\", got", r.Method)
+		t.log.Error.Println("received request with unexpected method. want \"POST\", got", r.Method)
 		return
 	}
 
 	pathElements := strings.Split(r.URL.Path, "/")
 
-	if pathElements[1] != d.config.FrameworkName {
+	if len(pathElements) != 3 || pathElements[1] != t.name {
 		w.WriteHeader(http.StatusNotFound)
-		errStr := fmt.Sprintf("unexpected path. want %q, got %q", d.config.FrameworkName, pathElements[1])
-		d.config.Log.Error.Println(errStr)
+		errStr := fmt.Sprintf("unexpected path. want /%s/<message>, got %q", t.name, r.URL.Path)
+		t.log.Error.Println(errStr)
 		w.Write([]byte(errStr))
 		return
 	}
 
+	if err := t.verifySender(r, pathElements[2]); err != nil {
+		atomic.AddUint64(&t.metrics.messagesRejected, 1)
+		t.log.Warn.Println("rejecting message:", err)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	defer r.Body.Close()
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		d.config.Log.Error.Printf("failed to read body from request %+v: %+v", r, err)
+		t.log.Error.Printf("failed to read body from request %+v: %+v", r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	event, err := bytesToEvent(pathElements[2], body)
+	if err == errUnknownMessage {
+		// Newer masters send messages we know nothing about; that is not their fault.
+		atomic.AddUint64(&t.metrics.messagesUnknown, 1)
+		t.log.Warn.Println("ignoring message of unknown type", pathElements[2])
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 	if err != nil {
-		d.config.Log.Error.Println(err)
+		t.log.Error.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	d.events <- event
+	switch event.GetType() {
+	case mesos_scheduler.Event_REGISTERED:
+		t.setLeader(event.Registered.MasterInfo)
+	case mesos_scheduler.Event_REREGISTERED:
+		t.setLeader(event.Reregistered.MasterInfo)
+	case mesos_scheduler.Event_OFFERS:
+		t.addSlaves(event.Offers.Offers)
+	}
+
+	atomic.AddUint64(&t.metrics.messagesReceived, 1)
+	select {
+	case t.events <- event:
+	case <-t.done:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,241 @@
+package mesos
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+// Protocol selects how the driver talks to the master.
+type Protocol int
+
+const (
+	// ProtocolLibprocess converts each Call into the equivalent internal message, and
+	// receives events as libprocess messages posted to the driver's endpoint.
+	ProtocolLibprocess Protocol = iota
+	// ProtocolHTTP posts each Call to the master's scheduler HTTP API, and reads
+	// events from the stream returned in response to registration.
+	ProtocolHTTP
+)
+
+const (
+	schedulerAPIPath    = "/api/v1/scheduler"
+	protobufContentType = "application/x-protobuf"
+)
+
+// An eventStream is a registration call whose response body is still being read.
+type eventStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newStreamClient returns a client for long-lived event streams. Unlike the sender
+// it has no overall timeout, only timeouts for establishing the stream.
+func newStreamClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			DialContext: (&net.Dialer{
+				Timeout:   dialTimeout,
+				KeepAlive: keepAlive,
+			}).DialContext,
+			ResponseHeaderTimeout: responseTimeout,
+		},
+	}
+}
+
+// httpTransport posts each Call to the master's scheduler HTTP API, and reads events
+// from the stream returned in response to registration.
+type httpTransport struct {
+	log     Log
+	masters []MasterAddress
+	metrics *metrics
+	scheme  string
+
+	sender       *sender
+	streamClient *http.Client
+	// The stream is only accessed from the driver goroutine.
+	stream *eventStream
+
+	events   chan *mesos_scheduler.Event
+	failures chan error
+}
+
+// newHTTPTransport creates a transport using the client TLS settings of
+// config.Router, if set, or otherwise of config.Endpoint.
+func newHTTPTransport(config *DriverConfig, m *metrics) *httpTransport {
+	tlsConfig := config.Endpoint.ClientTLS
+	if config.Router != nil {
+		tlsConfig = config.Router.clientTLS
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	return &httpTransport{
+		log:          config.Log,
+		masters:      config.Masters,
+		metrics:      m,
+		scheme:       scheme,
+		sender:       newSender(tlsConfig),
+		streamClient: newStreamClient(tlsConfig),
+		events:       make(chan *mesos_scheduler.Event, 100),
+		failures:     make(chan error, 1),
+	}
+}
+
+func (t *httpTransport) Start() error {
+	return nil
+}
+
+func (t *httpTransport) Send(ctx context.Context, m *mesos_scheduler.Call) error {
+	switch m.GetType() {
+	case mesos_scheduler.Call_REGISTER, mesos_scheduler.Call_REREGISTER:
+		return t.subscribe(m)
+	}
+
+	req, err := t.newAPIRequest(m)
+	if err != nil {
+		return err
+	}
+
+	status, err := t.sender.send(ctx, req)
+	if err != nil {
+		return sendError(ctx, req.URL.String(), err)
+	}
+	return statusError(req.URL.String(), http.StatusAccepted, status)
+}
+
+func (t *httpTransport) Events() <-chan *mesos_scheduler.Event {
+	return t.events
+}
+
+func (t *httpTransport) Failures() <-chan error {
+	return t.failures
+}
+
+func (t *httpTransport) Close() error {
+	t.Disconnect()
+	t.sender.close()
+	return nil
+}
+
+func (t *httpTransport) newAPIRequest(m *mesos_scheduler.Call) (*http.Request, error) {
+	body, err := proto.Marshal(m)
+	if err != nil {
+		return nil, newDriverError(errorFatal, "failed to marshal Call %+v: %+v", m, err)
+	}
+
+	url := masterURL(t.scheme, t.masters, schedulerAPIPath)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, newDriverError(errorFatal, "failed to create request for %s: %+v", url, err)
+	}
+	req.Header.Set("Content-Type", protobufContentType)
+	req.Header.Set("Accept", protobufContentType)
+
+	return req, nil
+}
+
+// subscribe sends a registration call and starts reading events from its response.
+// While an earlier registration's stream is still open, it does nothing.
+func (t *httpTransport) subscribe(m *mesos_scheduler.Call) error {
+	if t.stream != nil {
+		select {
+		case <-t.stream.done:
+			t.stream = nil
+		default:
+			return nil
+		}
+	}
+
+	req, err := t.newAPIRequest(m)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := t.streamClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return sendError(ctx, req.URL.String(), err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+		resp.Body.Close()
+		cancel()
+		return statusError(req.URL.String(), http.StatusOK, resp.StatusCode)
+	}
+
+	t.stream = &eventStream{
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go t.readEvents(resp.Body, t.stream)
+
+	return nil
+}
+
+// readEvents delivers the events read from body until the stream ends, which it
+// reports as a lost connection unless the stream was closed deliberately.
+func (t *httpTransport) readEvents(body io.ReadCloser, stream *eventStream) {
+	defer close(stream.done)
+	defer body.Close()
+
+	records := newRecordReader(body)
+	for {
+		record, err := records.next()
+		if err != nil {
+			if stream.ctx.Err() == nil {
+				t.fail(newDriverError(errorNotConnected, "event stream ended: %+v", err))
+			}
+			return
+		}
+
+		event := new(mesos_scheduler.Event)
+		if err := proto.Unmarshal(record, event); err != nil {
+			t.log.Error.Printf("failed to unmarshal event %q: %+v", record, err)
+			continue
+		}
+
+		atomic.AddUint64(&t.metrics.messagesReceived, 1)
+		select {
+		case t.events <- event:
+		case <-stream.ctx.Done():
+			return
+		}
+	}
+}
+
+// Disconnect closes the event stream, if any, and waits for its reader to finish.
+func (t *httpTransport) Disconnect() {
+	if t.stream == nil {
+		return
+	}
+	t.stream.cancel()
+	<-t.stream.done
+	t.stream = nil
+}
+
+// fail reports an error noticed outside the driver goroutine. Only the first of
+// several errors reported before the driver gets to them is kept.
+func (t *httpTransport) fail(err error) {
+	select {
+	case t.failures <- err:
+	default:
+	}
+}
@@ -0,0 +1,149 @@
+package mesos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+func writeRecord(w io.Writer, record []byte) {
+	fmt.Fprintf(w, "%d\n", len(record))
+	w.Write(record)
+}
+
+func TestRecordReader(t *testing.T) {
+	var buffer bytes.Buffer
+	writeRecord(&buffer, []byte("first"))
+	writeRecord(&buffer, []byte{})
+	writeRecord(&buffer, []byte("third\nrecord"))
+
+	records := newRecordReader(&buffer)
+	for _, want := range []string{"first", "", "third\nrecord"} {
+		got, err := records.next()
+		if err != nil || string(got) != want {
+			t.Errorf("next(): got (%q, %v), want (%q, nil)", got, err, want)
+		}
+	}
+	if _, err := records.next(); err != io.EOF {
+		t.Errorf("next() at end: got %v, want EOF", err)
+	}
+
+	for _, bad := range []string{"x\n", "5\nabc", "3"} {
+		if _, err := newRecordReader(strings.NewReader(bad)).next(); err == nil || err == io.EOF {
+			t.Errorf("next() on %q: got %v, want an error", bad, err)
+		}
+	}
+}
+
+// offeringScheduler declines every offer it is sent.
+type offeringScheduler struct {
+	recordingScheduler
+	declined chan error
+}
+
+func (s *offeringScheduler) Registered(*Driver, string, *mesos.MasterInfo) {}
+
+func (s *offeringScheduler) ResourceOffers(d *Driver, offers []*Offer) {
+	for _, offer := range offers {
+		s.declined <- offer.Decline(context.Background())
+	}
+}
+
+func TestHTTPProtocol(t *testing.T) {
+	calls := make(chan *mesos_scheduler.Call, 10)
+
+	// A stand-in master that streams a registration and an offer to the subscriber.
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != schedulerAPIPath || r.Header.Get("Content-Type") != protobufContentType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		call := new(mesos_scheduler.Call)
+		if err := proto.Unmarshal(body, call); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		calls <- call
+
+		if call.GetType() != mesos_scheduler.Call_REGISTER {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		frameworkId := &mesos.FrameworkID{Value: proto.String("framework-1")}
+		registered := mesos_scheduler.Event_REGISTERED
+		offers := mesos_scheduler.Event_OFFERS
+		for _, event := range []*mesos_scheduler.Event{
+			{
+				Type:       &registered,
+				Registered: &mesos_scheduler.Event_Registered{FrameworkId: frameworkId},
+			},
+			{
+				Type: &offers,
+				Offers: &mesos_scheduler.Event_Offers{Offers: []*mesos.Offer{{
+					Id:          &mesos.OfferID{Value: proto.String("offer-1")},
+					FrameworkId: frameworkId,
+					SlaveId:     &mesos.SlaveID{Value: proto.String("slave-1")},
+					Hostname:    proto.String("slave-1"),
+				}}},
+			},
+		} {
+			record, _ := proto.Marshal(event)
+			writeRecord(w, record)
+		}
+		w.(http.Flusher).Flush()
+
+		// Hold the stream open, like a real master.
+		<-r.Context().Done()
+	}))
+	defer master.Close()
+	// Ends the event stream, which the driver would otherwise keep open.
+	defer master.CloseClientConnections()
+
+	masterAddr := master.Listener.Addr().(*net.TCPAddr)
+	driver, err := NewWithConfig(DriverConfig{
+		FrameworkName: "framework",
+		Masters:       []MasterAddress{{Hostname: "127.0.0.1", Port: masterAddr.Port}},
+		Log:           NewLog(LogConfig{}),
+		Protocol:      ProtocolHTTP,
+		Endpoint:      RouterConfig{BindAddress: "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Stop(true)
+
+	sched := &offeringScheduler{declined: make(chan error, 1)}
+	go RunScheduler(driver, sched)
+
+	select {
+	case err := <-sched.declined:
+		if err != nil {
+			t.Fatalf("decline: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no offer received over the event stream")
+	}
+
+	want := []mesos_scheduler.Call_Type{mesos_scheduler.Call_REGISTER, mesos_scheduler.Call_DECLINE}
+	for _, callType := range want {
+		call := <-calls
+		if call.GetType() != callType {
+			t.Errorf("got call %v, want %v", call.GetType(), callType)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package mesos
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+// libprocessTransport converts each Call into the equivalent internal message and
+// posts it to the master, and receives events as libprocess messages the master
+// posts to a process registered with a Router.
+type libprocessTransport struct {
+	log     Log
+	masters []MasterAddress
+	metrics *metrics
+
+	router     *Router
+	ownsRouter bool
+	// The process name we are registered with the router under.
+	name string
+
+	events chan *mesos_scheduler.Event
+	done   chan struct{}
+
+	// The leading master, which incoming messages are verified against.
+	masterLock sync.RWMutex
+	masterPid  *UPID
+
+	// The hosts of the slaves we have been offered, which executors message us from.
+	slaveLock  sync.RWMutex
+	slaveHosts map[string]bool
+
+	hosts hostCache
+}
+
+// newLibprocessTransport registers a process with config.Router, or, if that is nil,
+// with a router of its own created from config.Endpoint.
+func newLibprocessTransport(config *DriverConfig, m *metrics) (*libprocessTransport, error) {
+	router, ownsRouter := config.Router, false
+	if router == nil {
+		endpoint := config.Endpoint
+		if endpoint.Log.Info == nil {
+			endpoint.Log = config.Log
+		}
+		var err error
+		if router, err = NewRouter(endpoint); err != nil {
+			return nil, err
+		}
+		ownsRouter = true
+	}
+
+	t := &libprocessTransport{
+		log:        config.Log,
+		masters:    config.Masters,
+		metrics:    m,
+		router:     router,
+		ownsRouter: ownsRouter,
+		events:     make(chan *mesos_scheduler.Event, 100),
+		done:       make(chan struct{}),
+	}
+	t.name = router.register(config.FrameworkName, t)
+
+	return t, nil
+}
+
+// pid returns the libprocess PID the master knows us by.
+func (t *libprocessTransport) pid() string {
+	return t.router.pid(t.name)
+}
+
+// Start checks that the router's endpoint is up, since the master cannot answer
+// us otherwise.
+func (t *libprocessTransport) Start() error {
+	if err := t.router.checkHealth(); err != nil {
+		return newDriverError(errorNotInitialized, "endpoint %s not healthy: %+v", t.router.localAddr(), err)
+	}
+	return nil
+}
+
+func (t *libprocessTransport) Send(ctx context.Context, m *mesos_scheduler.Call) error {
+	// TODO(dhamon): Remove this call when mesos listens for Call directly.
+	msg, err := callToMessage(m)
+	if err != nil {
+		return newDriverError(errorFatal, "failed to convert Call %+v: %+v", m, err)
+	}
+
+	buffer, err := proto.Marshal(msg)
+	if err != nil {
+		return newDriverError(errorFatal, "failed to marshal Message %+v: %+v", msg, err)
+	}
+
+	path, err := path(m)
+	if err != nil {
+		return newDriverError(errorFatal, "failed to get path for Call %+v: %+v", m, err)
+	}
+
+	registerUrl := masterURL(t.router.scheme(), t.masters, "/master/"+path)
+
+	req, err := http.NewRequest("POST", registerUrl, bytes.NewReader(buffer))
+	if err != nil {
+		return newDriverError(errorFatal, "failed to create request for %s: %+v", registerUrl, err)
+	}
+	req.Header.Add("Connection", "keep-alive")
+	req.Header.Add("Content-type", "application/octet-stream")
+	req.Header.Add("Libprocess-From", t.pid())
+
+	status, err := t.router.sender.send(ctx, req)
+	if err != nil {
+		return sendError(ctx, registerUrl, err)
+	}
+	return statusError(registerUrl, http.StatusAccepted, status)
+}
+
+func (t *libprocessTransport) Events() <-chan *mesos_scheduler.Event {
+	return t.events
+}
+
+// Failures returns nil; the master tells us about anything that goes wrong.
+func (t *libprocessTransport) Failures() <-chan error {
+	return nil
+}
+
+// Disconnect does nothing, as there is no connection to drop.
+func (t *libprocessTransport) Disconnect() {}
+
+// Close unregisters from the router, and closes the router if it is our own.
+func (t *libprocessTransport) Close() error {
+	close(t.done)
+	t.router.unregister(t.name)
+	if t.ownsRouter {
+		return t.router.Close()
+	}
+	return nil
+}
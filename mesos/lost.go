@@ -0,0 +1,27 @@
+package mesos
+
+import (
+	"fmt"
+)
+
+// Lost reports that a slave, or an executor running on it, has gone away. Tasks it
+// was running will not finish.
+type Lost struct {
+	SlaveId string
+	// ExecutorId is empty when the whole slave was lost.
+	ExecutorId string
+	// Status is the exit status of a lost executor.
+	Status int
+}
+
+// SlaveLost reports whether the whole slave was lost, rather than one executor.
+func (l *Lost) SlaveLost() bool {
+	return l.ExecutorId == ""
+}
+
+func (l *Lost) String() string {
+	if l.SlaveLost() {
+		return fmt.Sprintf("slave %q lost", l.SlaveId)
+	}
+	return fmt.Sprintf("executor %q on slave %q lost with status %d", l.ExecutorId, l.SlaveId, l.Status)
+}
@@ -0,0 +1,35 @@
+package mesos
+
+import (
+	"sync/atomic"
+)
+
+// Metrics is a snapshot of the driver's counters.
+type Metrics struct {
+	// Messages accepted from the master or slaves.
+	MessagesReceived uint64 `json:"messages_received"`
+	// Messages dropped because their sender could not be verified.
+	MessagesRejected uint64 `json:"messages_rejected"`
+	// Messages of a type the driver does not act on.
+	MessagesUnknown uint64 `json:"messages_unknown"`
+}
+
+// metrics are updated atomically from the HTTP handlers and the driver goroutine.
+type metrics struct {
+	messagesReceived uint64
+	messagesRejected uint64
+	messagesUnknown  uint64
+}
+
+func (m *metrics) snapshot() Metrics {
+	return Metrics{
+		MessagesReceived: atomic.LoadUint64(&m.messagesReceived),
+		MessagesRejected: atomic.LoadUint64(&m.messagesRejected),
+		MessagesUnknown:  atomic.LoadUint64(&m.messagesUnknown),
+	}
+}
+
+// Metrics returns the current values of the driver's counters.
+func (d *Driver) Metrics() Metrics {
+	return d.metrics.snapshot()
+}
@@ -1,16 +1,22 @@
 package mesos
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"strings"
 
+	"code.google.com/p/goprotobuf/proto"
+
 	"github.com/twitter/gozer/proto/mesos.pb"
 	"github.com/twitter/gozer/proto/scheduler.pb"
 )
 
 type Offer struct {
-	Id	   string
-	driver	   *Driver
+	Id         string
+	SlaveId    string
+	Hostname   string
+	driver     *Driver
 	mesosOffer *mesos.Offer
 }
 
@@ -42,8 +48,57 @@ func (o *Offer) String() string {
 		*o.mesosOffer.SlaveId.Value)
 }
 
-func (o *Offer) Decline() {
-	o.driver.command <- func(d *Driver) error {
+// Fits reports whether the offer holds the cpus and memory task needs.
+func (o *Offer) Fits(task *MesosTask) bool {
+	return o.scalar("cpus") >= task.Cpus && o.scalar("mem") >= task.Mem
+}
+
+// scalar returns how much of the named scalar resource the offer holds, in any role.
+func (o *Offer) scalar(name string) float64 {
+	var total float64
+	for _, resource := range o.mesosOffer.Resources {
+		if resource.GetName() == name && resource.GetType() == mesos.Value_SCALAR {
+			total += resource.GetScalar().GetValue()
+		}
+	}
+	return total
+}
+
+// resources returns the part of the offer task is launched with: as much cpus and
+// memory as it needs, taken from whichever roles the offer holds them in. The rest of
+// the offer is left to the master.
+func (o *Offer) resources(task *MesosTask) []*mesos.Resource {
+	var resources []*mesos.Resource
+	for _, need := range []struct {
+		name   string
+		amount float64
+	}{{"cpus", task.Cpus}, {"mem", task.Mem}} {
+		for _, resource := range o.mesosOffer.Resources {
+			if need.amount <= 0 {
+				break
+			}
+			if resource.GetName() != need.name || resource.GetType() != mesos.Value_SCALAR {
+				continue
+			}
+			amount := math.Min(need.amount, resource.GetScalar().GetValue())
+			if amount <= 0 {
+				continue
+			}
+			resources = append(resources, &mesos.Resource{
+				Name:   resource.Name,
+				Type:   resource.Type,
+				Role:   resource.Role,
+				Scalar: &mesos.Value_Scalar{Value: proto.Float64(amount)},
+			})
+			need.amount -= amount
+		}
+	}
+	return resources
+}
+
+// Decline returns the offer's resources to the master unused.
+func (o *Offer) Decline(ctx context.Context) error {
+	return o.driver.do(ctx, func(d *Driver) error {
 		declineType := mesos_scheduler.Call_DECLINE
 		declineCall := &mesos_scheduler.Call{
 			FrameworkInfo: &mesos.FrameworkInfo{
@@ -59,7 +114,6 @@ func (o *Offer) Decline() {
 			},
 		}
 
-		return d.send(declineCall)
-	}
+		return d.transport.Send(ctx, declineCall)
+	})
 }
-
@@ -0,0 +1,50 @@
+package mesos
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Records larger than this are assumed to be corruption rather than real events.
+const maxRecordSize = 64 << 20
+
+// recordReader reads RecordIO framed records: each record is its length in bytes
+// as a decimal string, followed by a newline and the record itself.
+type recordReader struct {
+	reader *bufio.Reader
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{reader: bufio.NewReader(r)}
+}
+
+// next returns the next record, or io.EOF once the stream ends cleanly.
+func (r *recordReader) next() ([]byte, error) {
+	header, err := r.reader.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && header != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	size, err := strconv.ParseUint(strings.TrimSpace(header), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record header %q: %+v", header, err)
+	}
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("record of %d bytes exceeds maximum of %d", size, maxRecordSize)
+	}
+
+	record := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,239 @@
+package mesos
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RouterConfig describes the libprocess endpoint served by a Router.
+type RouterConfig struct {
+	// If Log has no loggers set, the router logs to stdout and stderr.
+	Log Log
+
+	// BindAddress is the interface the libprocess endpoint listens on. Empty means
+	// all interfaces. Defaults to $LIBPROCESS_IP.
+	BindAddress string
+	// Port is the port the libprocess endpoint listens on. Zero picks an ephemeral
+	// port. Defaults to $LIBPROCESS_PORT.
+	Port int
+
+	// AdvertiseIP is the address peers are told to reach us on, for hosts that are
+	// multi-homed or behind NAT. Defaults to $LIBPROCESS_ADVERTISE_IP, then
+	// BindAddress, then the first address of the local hostname.
+	AdvertiseIP string
+	// AdvertisePort is the port peers are told to reach us on. Defaults to
+	// $LIBPROCESS_ADVERTISE_PORT, then the port actually bound.
+	AdvertisePort int
+
+	// ServerTLS, if set, serves the endpoint over TLS. It must hold the endpoint's
+	// certificate; set ClientAuth and ClientCAs to require mutual TLS.
+	ServerTLS *tls.Config
+	// ClientTLS, if set, sends messages to peers over TLS. Set RootCAs to pin the
+	// CAs that peer certificates must be signed by, and Certificates to present a
+	// client certificate.
+	ClientTLS *tls.Config
+}
+
+// A Router is a libprocess endpoint that can be shared by several drivers in one
+// process. Each driver registers under a process name, and incoming messages, which
+// are posted to /<process name>/<message type>, are routed on that name.
+type Router struct {
+	log       Log
+	listener  net.Listener
+	ip        string
+	port      int
+	sender    *sender
+	serverTLS *tls.Config
+	clientTLS *tls.Config
+
+	lock      sync.RWMutex
+	processes map[string]http.Handler
+	closed    bool
+}
+
+// routerStatus is reported by the /status endpoint.
+type routerStatus struct {
+	Listen    string   `json:"listen"`
+	Processes []string `json:"processes"`
+}
+
+// NewRouter binds the endpoint described by config and starts serving it.
+func NewRouter(config RouterConfig) (*Router, error) {
+	if config.Log.Info == nil {
+		config.Log = defaultLog()
+	}
+
+	listener, ip, port, err := listen(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ServerTLS != nil {
+		listener = tls.NewListener(listener, config.ServerTLS)
+	}
+
+	r := &Router{
+		log:       config.Log,
+		listener:  listener,
+		ip:        ip,
+		port:      port,
+		sender:    newSender(config.ClientTLS),
+		serverTLS: config.ServerTLS,
+		clientTLS: config.ClientTLS,
+		processes: make(map[string]http.Handler),
+	}
+
+	r.log.Info.Printf("Listening on %s, advertising %s", listener.Addr(), r.Addr())
+	go r.serve()
+
+	return r, nil
+}
+
+func (r *Router) serve() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, "OK\r\n")
+	})
+	mux.HandleFunc("/status", r.statusHandler)
+	mux.Handle("/", r)
+
+	err := http.Serve(r.listener, mux)
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if !r.closed {
+		r.log.Error.Fatal("failed to serve on ", r.listener.Addr(), ": ", err)
+	}
+}
+
+// Addr returns the advertised address of the endpoint as ip:port.
+func (r *Router) Addr() string {
+	return net.JoinHostPort(r.ip, strconv.Itoa(r.port))
+}
+
+// pid returns the libprocess PID of the named process.
+func (r *Router) pid(name string) string {
+	return name + "@" + r.Addr()
+}
+
+// register routes messages for a process named base, or base(1), base(2), ... if
+// that name is already taken. It returns the name that was registered.
+func (r *Router) register(base string, handler http.Handler) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	name := base
+	for i := 1; r.processes[name] != nil; i++ {
+		name = fmt.Sprintf("%s(%d)", base, i)
+	}
+	r.processes[name] = handler
+
+	r.log.Info.Println("Registered process", r.pid(name))
+	return name
+}
+
+func (r *Router) unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.processes, name)
+}
+
+// Close stops serving and releases the endpoint. Drivers using the router must be
+// stopped first.
+func (r *Router) Close() error {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.lock.Unlock()
+
+	r.sender.close()
+	return r.listener.Close()
+}
+
+// localAddr returns an address the router can be reached on from this host.
+func (r *Router) localAddr() string {
+	addr := r.listener.Addr().(*net.TCPAddr)
+	if addr.IP.IsUnspecified() {
+		return net.JoinHostPort("localhost", strconv.Itoa(addr.Port))
+	}
+	return addr.String()
+}
+
+// scheme returns the URL scheme used to send messages to peers.
+func (r *Router) scheme() string {
+	if r.clientTLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// checkHealth queries the router's own /health endpoint.
+func (r *Router) checkHealth() error {
+	scheme := "http"
+	transport := &http.Transport{}
+	if r.serverTLS != nil {
+		scheme = "https"
+		// We are only checking that we can reach ourselves, so the certificate need
+		// not be verified, but we may have to present our own to get through.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		if r.clientTLS != nil {
+			transport.TLSClientConfig.Certificates = r.clientTLS.Certificates
+		}
+	}
+	defer transport.CloseIdleConnections()
+
+	client := &http.Client{Transport: transport, Timeout: responseTimeout}
+	resp, err := client.Get(fmt.Sprintf("%s://%s/health", scheme, r.localAddr()))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected health status. want %d got %d", http.StatusOK, resp.StatusCode)
+	}
+	return nil
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	pathElements := strings.SplitN(req.URL.Path, "/", 3)
+
+	r.lock.RLock()
+	handler := r.processes[pathElements[1]]
+	r.lock.RUnlock()
+
+	if handler == nil {
+		errStr := fmt.Sprintf("no process named %q", pathElements[1])
+		r.log.Error.Println(errStr)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(errStr))
+		return
+	}
+
+	handler.ServeHTTP(w, req)
+}
+
+func (r *Router) statusHandler(w http.ResponseWriter, req *http.Request) {
+	status := routerStatus{Listen: r.listener.Addr().String()}
+
+	r.lock.RLock()
+	for name := range r.processes {
+		status.Processes = append(status.Processes, r.pid(name))
+	}
+	r.lock.RUnlock()
+	sort.Strings(status.Processes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,78 @@
+package mesos
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/messages.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+func TestRouterSharedByDrivers(t *testing.T) {
+	router, err := NewRouter(RouterConfig{Log: NewLog(LogConfig{}), BindAddress: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+
+	config := &DriverConfig{
+		FrameworkName: "framework",
+		Masters:       []MasterAddress{{Hostname: "127.0.0.1", Port: 5050}},
+		Log:           NewLog(LogConfig{}),
+		Router:        router,
+	}
+	first, err := newDriver(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newDriver(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstTransport := first.transport.(*libprocessTransport)
+	secondTransport := second.transport.(*libprocessTransport)
+	if firstTransport.name != "framework" || secondTransport.name != "framework(1)" {
+		t.Fatalf("got process names %q and %q, want %q and %q",
+			firstTransport.name, secondTransport.name, "framework", "framework(1)")
+	}
+
+	message := "removed"
+	body, err := proto.Marshal(&mesos_internal.FrameworkErrorMessage{Message: &message})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(process string) int {
+		url := "http://" + router.localAddr() + "/" + process + "/mesos.internal.FrameworkErrorMessage"
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Libprocess-From", "master@127.0.0.1:5050")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(secondTransport.name); status != http.StatusOK {
+		t.Fatalf("post to %q: got status %d, want %d", secondTransport.name, status, http.StatusOK)
+	}
+	if len(firstTransport.events) != 0 || len(secondTransport.events) != 1 {
+		t.Fatalf("got %d and %d events, want 0 and 1", len(firstTransport.events), len(secondTransport.events))
+	}
+	if event := <-secondTransport.events; event.GetType() != mesos_scheduler.Event_ERROR {
+		t.Errorf("got event %v, want %v", event.GetType(), mesos_scheduler.Event_ERROR)
+	}
+
+	if status := post("unknown"); status != http.StatusNotFound {
+		t.Errorf("post to unknown process: got status %d, want %d", status, http.StatusNotFound)
+	}
+}
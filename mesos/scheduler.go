@@ -0,0 +1,184 @@
+package mesos
+
+import (
+	"sync"
+	"time"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+)
+
+// Scheduler is the callback-style alternative to reading the Offers and Updates
+// channels directly. It mirrors the classic Mesos scheduler API so that frameworks
+// written against other bindings can be ported mechanically.
+//
+// Callbacks are invoked one at a time from the goroutine running RunScheduler, never
+// from the driver itself, so it is safe to call back into the driver (LaunchTask,
+// Offer.Decline, TaskStateUpdate.Ack, ...) from within a callback.
+type Scheduler interface {
+	// Registered is invoked once the framework has been registered with a master.
+	Registered(d *Driver, frameworkId string, masterInfo *mesos.MasterInfo)
+
+	// Reregistered is invoked when the framework has re-registered with a newly
+	// elected master.
+	Reregistered(d *Driver, masterInfo *mesos.MasterInfo)
+
+	// Disconnected is invoked when the driver loses its connection to the master.
+	Disconnected(d *Driver)
+
+	// ResourceOffers is invoked with every batch of offers sent by the master.
+	ResourceOffers(d *Driver, offers []*Offer)
+
+	// OfferRescinded is invoked when a previously sent offer is no longer valid.
+	OfferRescinded(d *Driver, offerId string)
+
+	// StatusUpdate is invoked for every task state change. The update must be
+	// acknowledged with Ack.
+	StatusUpdate(d *Driver, update *TaskStateUpdate)
+
+	// FrameworkMessage is invoked with any data sent by an executor.
+	FrameworkMessage(d *Driver, executorId, slaveId string, data []byte)
+
+	// SlaveLost is invoked when a slave has been determined unreachable.
+	SlaveLost(d *Driver, slaveId string)
+
+	// ExecutorLost is invoked when an executor has exited or terminated.
+	ExecutorLost(d *Driver, executorId, slaveId string, status int)
+
+	// Error is invoked when the master reports an error for the framework.
+	Error(d *Driver, message string)
+}
+
+// A callback is a Scheduler method call that has been bound to its arguments.
+type callback func(Scheduler)
+
+// callbackQueue holds the callbacks waiting for the attached scheduler. Queueing never
+// blocks, so the driver goroutine carries on running the commands callbacks send it,
+// however far behind the scheduler is.
+type callbackQueue struct {
+	sync.Mutex
+	callbacks []callback
+	closed    bool
+	// Signalled when a callback is queued or the queue is closed.
+	ready chan struct{}
+}
+
+func newCallbackQueue() *callbackQueue {
+	return &callbackQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *callbackQueue) push(cb callback) {
+	q.Lock()
+	q.callbacks = append(q.callbacks, cb)
+	q.Unlock()
+	q.signal()
+}
+
+// close lets the callbacks already queued be taken, and then ends the queue.
+func (q *callbackQueue) close() {
+	q.Lock()
+	q.closed = true
+	q.Unlock()
+	q.signal()
+}
+
+func (q *callbackQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop waits for the next callback. It returns false once the queue is closed and
+// empty.
+func (q *callbackQueue) pop() (callback, bool) {
+	for {
+		q.Lock()
+		if len(q.callbacks) > 0 {
+			cb := q.callbacks[0]
+			q.callbacks[0] = nil
+			q.callbacks = q.callbacks[1:]
+			q.Unlock()
+			return cb, true
+		}
+		closed := q.closed
+		q.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
+
+// RunScheduler attaches sched to the driver and dispatches driver events to it until
+// the driver stops. Once a scheduler is attached, offers, updates and lost slaves and
+// executors are no longer published on the Offers, Updates and Lost channels.
+//
+// RunScheduler blocks until the driver has registered, at which point the Registered
+// callback is replayed, along with any offers that were queued on the Offers channel.
+// If the driver is recovering from an error it attaches once the driver is ready.
+func RunScheduler(d *Driver, sched Scheduler) {
+	callbacks := newCallbackQueue()
+
+	attach := func(fm *Driver) error {
+		fm.callbacks = callbacks
+
+		frameworkId := fm.frameworkId.GetValue()
+		masterInfo := fm.masterInfo
+		callbacks.push(func(s Scheduler) { s.Registered(fm, frameworkId, masterInfo) })
+
+		var offers []*Offer
+		for len(fm.Offers) > 0 {
+			offers = append(offers, <-fm.Offers)
+		}
+		if len(offers) > 0 {
+			callbacks.push(func(s Scheduler) { s.ResourceOffers(fm, offers) })
+		}
+
+		return nil
+	}
+
+	// A driver that is waiting to recover turns commands away with ErrNotReady;
+	// keep asking until it is ready again.
+	for {
+		c := newCommand(attach)
+		select {
+		case d.command <- c:
+		case <-d.done:
+			return
+		}
+
+		var err error
+		select {
+		case err = <-c.result:
+		case <-d.done:
+			return
+		}
+		if err != ErrNotReady {
+			break
+		}
+
+		select {
+		case <-time.After(retryBackoff):
+		case <-d.done:
+			return
+		}
+	}
+
+	for {
+		cb, ok := callbacks.pop()
+		if !ok {
+			return
+		}
+		cb(sched)
+	}
+}
+
+// schedule queues a callback for the attached scheduler. It returns false if no
+// scheduler is attached.
+func (d *Driver) schedule(cb callback) bool {
+	if d.callbacks == nil {
+		return false
+	}
+	d.callbacks.push(cb)
+	return true
+}
@@ -0,0 +1,214 @@
+package mesos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+type recordingScheduler struct {
+	calls []string
+}
+
+func (r *recordingScheduler) Registered(*Driver, string, *mesos.MasterInfo) {
+	r.calls = append(r.calls, "Registered")
+}
+func (r *recordingScheduler) Reregistered(*Driver, *mesos.MasterInfo) {
+	r.calls = append(r.calls, "Reregistered")
+}
+func (r *recordingScheduler) Disconnected(*Driver) {
+	r.calls = append(r.calls, "Disconnected")
+}
+func (r *recordingScheduler) ResourceOffers(*Driver, []*Offer) {
+	r.calls = append(r.calls, "ResourceOffers")
+}
+func (r *recordingScheduler) OfferRescinded(*Driver, string) {
+	r.calls = append(r.calls, "OfferRescinded")
+}
+func (r *recordingScheduler) StatusUpdate(*Driver, *TaskStateUpdate) {
+	r.calls = append(r.calls, "StatusUpdate")
+}
+func (r *recordingScheduler) FrameworkMessage(*Driver, string, string, []byte) {
+	r.calls = append(r.calls, "FrameworkMessage")
+}
+func (r *recordingScheduler) SlaveLost(*Driver, string) {
+	r.calls = append(r.calls, "SlaveLost")
+}
+func (r *recordingScheduler) ExecutorLost(*Driver, string, string, int) {
+	r.calls = append(r.calls, "ExecutorLost")
+}
+func (r *recordingScheduler) Error(*Driver, string) {
+	r.calls = append(r.calls, "Error")
+}
+
+func TestRunScheduler(t *testing.T) {
+	frameworkId := "framework"
+	d := &Driver{
+		config:      DriverConfig{Log: NewLog(LogConfig{})},
+		frameworkId: mesos.FrameworkID{Value: &frameworkId},
+		command:     make(chan *command),
+		transport:   NewChannelTransport(),
+		Offers:      make(chan *Offer, 100),
+		Updates:     make(chan *TaskStateUpdate),
+	}
+
+	rescind := mesos_scheduler.Event_RESCIND
+	failure := mesos_scheduler.Event_FAILURE
+	errorType := mesos_scheduler.Event_ERROR
+	message := "framework removed"
+	events := []*mesos_scheduler.Event{
+		{Type: &rescind, Rescind: &mesos_scheduler.Event_Rescind{}},
+		{Type: &failure, Failure: &mesos_scheduler.Event_Failure{
+			SlaveId: &mesos.SlaveID{Value: proto.String("slave-1")},
+		}},
+		// A failure that names neither a slave nor an executor is dropped.
+		{Type: &failure, Failure: &mesos_scheduler.Event_Failure{}},
+		{Type: &errorType, Error: &mesos_scheduler.Event_Error{Message: &message}},
+	}
+
+	// Stand in for the driver goroutine: run the attach command, dispatch and stop.
+	go func() {
+		c := <-d.command
+		c.result <- c.run(d)
+		for _, event := range events {
+			err := d.eventDispatch(event)
+			if event.GetType() == mesos_scheduler.Event_ERROR {
+				// A framework error stops the driver, after telling the scheduler.
+				if errorIdOf(err) != errorFatal {
+					t.Errorf("eventDispatch(%v): got %v, want a fatal error", event, err)
+				}
+			} else if err != nil {
+				t.Errorf("eventDispatch(%v): %v", event, err)
+			}
+		}
+		d.callbacks.close()
+	}()
+
+	sched := &recordingScheduler{}
+	RunScheduler(d, sched)
+
+	want := []string{"Registered", "OfferRescinded", "SlaveLost", "Error"}
+	if len(sched.calls) != len(want) {
+		t.Fatalf("got calls %v, want %v", sched.calls, want)
+	}
+	for i := range want {
+		if sched.calls[i] != want[i] {
+			t.Errorf("call %d: got %q, want %q", i, sched.calls[i], want[i])
+		}
+	}
+}
+
+// TestRunSchedulerWhileRetrying checks that a scheduler attached while the driver
+// waits to retry a command is attached once the retry succeeds.
+func TestRunSchedulerWhileRetrying(t *testing.T) {
+	frameworkId := "framework"
+	d := newTestDriver()
+	d.frameworkId = mesos.FrameworkID{Value: &frameworkId}
+	d.command = make(chan *command)
+	d.retryBackoff = 50 * time.Millisecond
+	d.pending = newCommand(func(*Driver) error { return nil })
+	pending := d.pending
+
+	// Stand in for the driver goroutine: retry, then serve the next command.
+	go func() {
+		if next := stateRetry(d); !sameState(next, stateReady) {
+			t.Error("the retry did not succeed")
+		}
+		if err := <-pending.result; err != nil {
+			t.Errorf("pending command: %v", err)
+		}
+		stateReady(d)(d)
+		d.callbacks.close()
+	}()
+
+	sched := &recordingScheduler{}
+	done := make(chan bool)
+	go func() {
+		RunScheduler(d, sched)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the scheduler was never attached")
+	}
+	if len(sched.calls) != 1 || sched.calls[0] != "Registered" {
+		t.Errorf("got calls %v, want [Registered]", sched.calls)
+	}
+}
+
+// ackingScheduler calls back into the driver for every rescinded offer, as a
+// scheduler acknowledging updates or declining offers does.
+type ackingScheduler struct {
+	recordingScheduler
+	acked int
+}
+
+func (a *ackingScheduler) OfferRescinded(d *Driver, offerId string) {
+	if err := d.do(context.Background(), func(*Driver) error { return nil }); err != nil {
+		panic(err)
+	}
+	a.acked++
+}
+
+// TestCallbacksDoNotBlock checks that however many callbacks are queued, the driver
+// goroutine carries on serving the commands they send it.
+func TestCallbacksDoNotBlock(t *testing.T) {
+	d := &Driver{
+		config:    DriverConfig{Log: NewLog(LogConfig{})},
+		command:   make(chan *command),
+		transport: NewChannelTransport(),
+		Offers:    make(chan *Offer, 100),
+	}
+
+	const n = 500
+	rescind := mesos_scheduler.Event_RESCIND
+	events := make(chan *mesos_scheduler.Event, n)
+	for i := 0; i < n; i++ {
+		events <- &mesos_scheduler.Event{Type: &rescind, Rescind: &mesos_scheduler.Event_Rescind{}}
+	}
+	close(events)
+
+	// Stand in for the driver goroutine, which serves commands between events.
+	go func() {
+		c := <-d.command
+		c.result <- c.run(d)
+		for served := 0; served < n; {
+			select {
+			case c := <-d.command:
+				c.result <- c.run(d)
+				served++
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				if err := d.eventDispatch(event); err != nil {
+					t.Errorf("eventDispatch(%v): %v", event, err)
+				}
+			}
+		}
+		d.callbacks.close()
+	}()
+
+	sched := &ackingScheduler{}
+	done := make(chan struct{})
+	go func() {
+		RunScheduler(d, sched)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the driver and scheduler deadlocked")
+	}
+	if sched.acked != n {
+		t.Errorf("acked %d offers, want %d", sched.acked, n)
+	}
+}
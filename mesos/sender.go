@@ -0,0 +1,174 @@
+package mesos
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout     = 5 * time.Second
+	responseTimeout = 10 * time.Second
+	keepAlive       = 30 * time.Second
+	idleConnTimeout = 90 * time.Second
+
+	// Upper bound on the number of sends in flight across all destinations.
+	maxConcurrentSends = 8
+
+	// Depth of each destination's queue before enqueue blocks.
+	sendQueueDepth = 100
+
+	// Response bodies are drained so that connections can be reused, but there is no
+	// point reading an unbounded amount of data from a misbehaving peer.
+	maxDrainBytes = 64 << 10
+)
+
+var errSenderClosed = errors.New("sender is closed")
+
+// sendResult is the outcome of a single request.
+type sendResult struct {
+	status int
+	err    error
+}
+
+type sendRequest struct {
+	req    *http.Request
+	result chan sendResult
+}
+
+// A sender posts libprocess messages over a pool of keep-alive connections. Requests
+// to the same destination are sent one at a time in the order they were queued, while
+// requests to different destinations proceed concurrently, up to maxConcurrentSends.
+type sender struct {
+	client *http.Client
+	slots  chan struct{}
+
+	lock   sync.Mutex
+	queues map[string]chan *sendRequest
+	done   chan struct{}
+}
+
+// newSender creates a sender. If tlsConfig is not nil it is used for https requests.
+func newSender(tlsConfig *tls.Config) *sender {
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		ResponseHeaderTimeout: responseTimeout,
+		MaxIdleConnsPerHost:   maxConcurrentSends,
+		IdleConnTimeout:       idleConnTimeout,
+	}
+
+	return &sender{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   dialTimeout + responseTimeout,
+		},
+		slots:  make(chan struct{}, maxConcurrentSends),
+		queues: make(map[string]chan *sendRequest),
+		done:   make(chan struct{}),
+	}
+}
+
+// enqueue queues req behind any earlier requests to the same host. The result is
+// delivered on the returned channel.
+func (s *sender) enqueue(req *http.Request) <-chan sendResult {
+	r := &sendRequest{
+		req:    req,
+		result: make(chan sendResult, 1),
+	}
+
+	s.lock.Lock()
+	select {
+	case <-s.done:
+		s.lock.Unlock()
+		r.result <- sendResult{err: errSenderClosed}
+		return r.result
+	default:
+	}
+	queue, ok := s.queues[req.URL.Host]
+	if !ok {
+		queue = make(chan *sendRequest, sendQueueDepth)
+		s.queues[req.URL.Host] = queue
+		go s.work(queue)
+	}
+	s.lock.Unlock()
+
+	select {
+	case queue <- r:
+	case <-s.done:
+		r.result <- sendResult{err: errSenderClosed}
+	}
+	return r.result
+}
+
+// send posts req and waits for the response status.
+func (s *sender) send(ctx context.Context, req *http.Request) (int, error) {
+	select {
+	case result := <-s.enqueue(req.WithContext(ctx)):
+		return result.status, result.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// close stops all destination workers. Requests that have not been sent yet fail
+// with errSenderClosed.
+func (s *sender) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// work sends the requests for a single destination in order.
+func (s *sender) work(queue chan *sendRequest) {
+	for {
+		select {
+		case r := <-queue:
+			if err := r.req.Context().Err(); err != nil {
+				r.result <- sendResult{err: err}
+				continue
+			}
+
+			s.slots <- struct{}{}
+			r.result <- s.do(r.req)
+			<-s.slots
+
+		case <-s.done:
+			for {
+				select {
+				case r := <-queue:
+					r.result <- sendResult{err: errSenderClosed}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *sender) do(req *http.Request) sendResult {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return sendResult{err: err}
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection goes back into the pool.
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+
+	return sendResult{status: resp.StatusCode}
+}
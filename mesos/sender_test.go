@@ -0,0 +1,67 @@
+package mesos
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSenderPreservesOrder(t *testing.T) {
+	var lock sync.Mutex
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		got = append(got, string(body))
+		lock.Unlock()
+
+		// Leave something behind for the sender to drain.
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(bytes.Repeat([]byte("x"), 1024))
+	}))
+	defer server.Close()
+
+	s := newSender(nil)
+	defer s.close()
+
+	const n = 50
+	var results []<-chan sendResult
+	for i := 0; i < n; i++ {
+		req, err := http.NewRequest("POST", server.URL, bytes.NewBufferString(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, s.enqueue(req))
+	}
+
+	for i, result := range results {
+		r := <-result
+		if r.err != nil || r.status != http.StatusAccepted {
+			t.Errorf("request %d: got (%d, %v), want (%d, nil)", i, r.status, r.err, http.StatusAccepted)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		if got[i] != strconv.Itoa(i) {
+			t.Fatalf("requests arrived out of order: %v", got)
+		}
+	}
+}
+
+func TestSenderClosed(t *testing.T) {
+	s := newSender(nil)
+	s.close()
+
+	req, err := http.NewRequest("POST", "http://localhost:1/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.send(context.Background(), req); err != errSenderClosed {
+		t.Errorf("send after close: got %v, want %v", err, errSenderClosed)
+	}
+}
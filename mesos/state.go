@@ -1,5 +1,13 @@
 package mesos
 
+import (
+	"context"
+	"time"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
 // A state function is a function that does stuff, and
 // then returns the next state function to be invoked.
 type stateFn func(*Driver) stateFn
@@ -9,11 +17,55 @@ func (d *Driver) Run() {
 	for state := stateInit; state != nil; {
 		state = state(d)
 	}
+	// Stop receiving messages before closing the channels they are delivered on.
+	if err := d.transport.Close(); err != nil {
+		d.config.Log.Warn.Println("Failed to close transport:", err)
+	}
+
 	// Close channels to indicate driver state machine is done.
 	close(d.Updates)
 	close(d.Offers)
-	close(d.events)
-	close(d.command)
+	close(d.Lost)
+	if d.callbacks != nil {
+		d.callbacks.close()
+	}
+	close(d.done)
+}
+
+// How long to wait for the master to accept our unregistration.
+const unregisterTimeout = 10 * time.Second
+
+// stopState returns the state that stops the driver, with or without failover.
+func (d *Driver) stopState(failover bool) stateFn {
+	if failover || d.frameworkId.Value == nil {
+		// Without a framework id there is nothing to unregister.
+		return stateStop
+	}
+	return stateUnregister
+}
+
+// stateUnregister removes the framework from the master, which kills its tasks.
+func stateUnregister(d *Driver) stateFn {
+	d.config.Log.Info.Println("UNREGISTER: Unregistering framework:", d)
+
+	unregisterType := mesos_scheduler.Call_UNREGISTER
+	unregisterCall := &mesos_scheduler.Call{
+		FrameworkInfo: &mesos.FrameworkInfo{
+			User: &d.config.RegisteredUser,
+			Name: &d.config.FrameworkName,
+			Id:   &d.frameworkId,
+		},
+		Type: &unregisterType,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), unregisterTimeout)
+	defer cancel()
+	if err := d.transport.Send(ctx, unregisterCall); err != nil {
+		d.config.Log.Error.Println("Failed to unregister:", err)
+		d.stopErr = err
+	}
+
+	return stateStop
 }
 
 func stateStop(d *Driver) stateFn {
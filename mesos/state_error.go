@@ -1,17 +1,200 @@
 package mesos
 
+import (
+	"fmt"
+	"time"
+)
+
 type stateErrorId int
 
 const (
+	// The error only concerns the caller of a command; the driver carries on.
 	errorNone stateErrorId = iota
+	// The driver's own libprocess endpoint never came up.
 	errorNotInitialized
+	// The master can not be reached; reconnect and re-register.
 	errorNotConnected
+	// The master is reachable but does not know about us; re-register.
 	errorNotReady
-	//...
+	// The failure is likely to go away by itself; retry with backoff.
+	errorTransient
+	// Nothing can be done; stop the driver.
+	errorFatal
 )
 
+var stateErrorNames = map[stateErrorId]string{
+	errorNone:           "none",
+	errorNotInitialized: "not initialized",
+	errorNotConnected:   "not connected",
+	errorNotReady:       "not ready",
+	errorTransient:      "transient",
+	errorFatal:          "fatal",
+}
+
+func (id stateErrorId) String() string {
+	if name, ok := stateErrorNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("stateErrorId(%d)", int(id))
+}
+
+// driverError annotates an error with the way the driver should recover from it.
+type driverError struct {
+	id  stateErrorId
+	err error
+}
+
+func (e *driverError) Error() string {
+	if e.id == errorNone {
+		// Nothing is wrong with the driver, so there is nothing to add.
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%s error: %v", e.id, e.err)
+}
+
+func newDriverError(id stateErrorId, format string, args ...interface{}) error {
+	return &driverError{id: id, err: fmt.Errorf(format, args...)}
+}
+
+// errorIdOf classifies err. Errors that were not classified when they were created
+// are assumed to be fatal.
+func errorIdOf(err error) stateErrorId {
+	if err == nil {
+		return errorNone
+	}
+	if e, ok := err.(*driverError); ok {
+		return e.id
+	}
+	return errorFatal
+}
+
+const maxRetries = 5
+
+// The delay before the first retry of a transient failure. It doubles on every
+// subsequent attempt.
+const retryBackoff = 250 * time.Millisecond
+
+// stateError decides how to recover from d.err.
 func stateError(d *Driver) stateFn {
-	// Handle any type of error state
-	d.config.Log.Error.Println("STATE: Error, driver =", d)
+	id := errorIdOf(d.err)
+	d.config.Log.Error.Printf("STATE: Error (%s): %v", id, d.err)
+
+	switch id {
+	case errorNone:
+		// The caller gets the error as it was, without the driver's classification.
+		err := d.err
+		if e, ok := err.(*driverError); ok {
+			err = e.err
+		}
+		d.finishPending(err)
+		d.err = nil
+		return stateReady
+
+	case errorTransient:
+		return stateRetry
+
+	case errorNotConnected:
+		d.finishPending(d.err)
+		return stateNotConnected
+
+	case errorNotReady:
+		d.finishPending(d.err)
+		return stateNotReady
+	}
+
+	// errorNotInitialized, errorFatal and anything unclassified.
+	d.finishPending(d.err)
+	fatal := d.err
+	if e, ok := fatal.(*driverError); ok {
+		fatal = e.err
+	}
+	d.fatalLock.Lock()
+	d.fatal = fatal
+	d.fatalLock.Unlock()
 	return stateStop
 }
+
+// stateRetry re-runs the pending command after a backoff. Commands that keep
+// failing are treated as a lost connection to the master.
+func stateRetry(d *Driver) stateFn {
+	if d.pending == nil {
+		d.err = nil
+		return stateReady
+	}
+
+	if d.retries >= maxRetries {
+		d.err = &driverError{
+			id:  errorNotConnected,
+			err: fmt.Errorf("giving up after %d retries: %v", d.retries, d.err),
+		}
+		return stateError
+	}
+
+	delay := d.retryBackoff << uint(d.retries)
+	d.retries++
+	d.config.Log.Warn.Printf("RETRY: Attempt %d of %d in %s", d.retries, maxRetries, delay)
+	if next := d.wait(delay); next != nil {
+		d.finishPending(ErrStopped)
+		return next
+	}
+
+	if err := d.pending.run(d); err != nil {
+		d.err = err
+		return stateError
+	}
+
+	d.finishPending(nil)
+	return stateReady
+}
+
+// stateNotConnected waits for a backoff and then re-registers with the master.
+func stateNotConnected(d *Driver) stateFn {
+	d.config.Log.Warn.Println("STATE: Not connected")
+	d.schedule(func(s Scheduler) { s.Disconnected(d) })
+	d.transport.Disconnect()
+
+	if next := d.wait(d.retryBackoff); next != nil {
+		return next
+	}
+	d.err = nil
+	return stateRegister
+}
+
+// stateNotReady re-registers with a master that no longer knows about us.
+func stateNotReady(d *Driver) stateFn {
+	d.config.Log.Warn.Println("STATE: Not ready")
+	d.err = nil
+	return stateRegister
+}
+
+// wait waits for delay on the driver goroutine. Commands sent meanwhile fail at once
+// with ErrNotReady rather than waiting for the driver to recover. If the driver is
+// stopped meanwhile, wait returns the state that stops it, and otherwise nil.
+func (d *Driver) wait(delay time.Duration) stateFn {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return nil
+		case failover := <-d.stop:
+			return d.stopState(failover)
+		case command, ok := <-d.command:
+			if !ok {
+				return stateStop
+			}
+			command.result <- ErrNotReady
+		}
+	}
+}
+
+// finishPending delivers the result of the pending command, if any, and resets the
+// retry state.
+func (d *Driver) finishPending(err error) {
+	if d.pending != nil {
+		d.pending.result <- err
+		d.pending = nil
+	}
+	d.retries = 0
+}
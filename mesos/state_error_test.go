@@ -0,0 +1,219 @@
+package mesos
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+func sameState(a, b stateFn) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+// newTestDriver returns a driver that is not connected to anything and retries
+// without delay.
+func newTestDriver() *Driver {
+	return &Driver{
+		config:    DriverConfig{Log: NewLog(LogConfig{})},
+		transport: NewChannelTransport(),
+		// newDriver waits retryBackoff before retrying; tests need not.
+		retryBackoff: 0,
+	}
+}
+
+func TestStateError(t *testing.T) {
+	tests := []struct {
+		err   error
+		next  stateFn
+		fatal bool
+	}{
+		{&driverError{id: errorNone, err: errors.New("canceled")}, stateReady, false},
+		{&driverError{id: errorTransient, err: errors.New("timeout")}, stateRetry, false},
+		{&driverError{id: errorNotConnected, err: errors.New("refused")}, stateNotConnected, false},
+		{&driverError{id: errorNotReady, err: errors.New("not found")}, stateNotReady, false},
+		{&driverError{id: errorNotInitialized, err: errors.New("no endpoint")}, stateStop, true},
+		{&driverError{id: errorFatal, err: errors.New("marshal")}, stateStop, true},
+		{errors.New("unclassified"), stateStop, true},
+	}
+
+	for _, test := range tests {
+		d := newTestDriver()
+		d.err = test.err
+
+		if next := stateError(d); !sameState(next, test.next) {
+			t.Errorf("stateError(%v): unexpected next state", test.err)
+		}
+		if fatal := d.Err() != nil; fatal != test.fatal {
+			t.Errorf("stateError(%v): got fatal %v, want %v", test.err, fatal, test.fatal)
+		}
+	}
+}
+
+func TestStateErrorReportsToCaller(t *testing.T) {
+	notFound := errors.New("not found")
+	notReady := &driverError{id: errorNotReady, err: notFound}
+	for _, test := range []struct {
+		err, want error
+	}{
+		{notReady, notReady},
+		// An error that only concerns the caller reaches it as it was.
+		{&driverError{id: errorNone, err: notFound}, notFound},
+	} {
+		c := newCommand(nil)
+		d := newTestDriver()
+		d.pending = c
+		d.err = test.err
+
+		stateError(d)
+
+		if got := <-c.result; got != test.want {
+			t.Errorf("command result: got %v, want %v", got, test.want)
+		}
+	}
+}
+
+// runUntil runs the state machine from state until it reaches one of the given
+// states, and returns the state it stopped at.
+func runUntil(t *testing.T, d *Driver, state stateFn, until ...stateFn) stateFn {
+	for i := 0; i < 100; i++ {
+		for _, u := range until {
+			if sameState(state, u) {
+				return state
+			}
+		}
+		state = state(d)
+	}
+	t.Fatal("state machine did not settle after 100 transitions")
+	return nil
+}
+
+func TestStateRetry(t *testing.T) {
+	attempts := 0
+	c := newCommand(func(*Driver) error {
+		attempts++
+		if attempts < 3 {
+			return &driverError{id: errorTransient, err: errors.New("timeout")}
+		}
+		return nil
+	})
+
+	d := newTestDriver()
+	d.pending = c
+	d.err = c.run(d)
+
+	if got := runUntil(t, d, stateError, stateReady, stateNotConnected, stateStop); !sameState(got, stateReady) {
+		t.Fatal("transient failures did not return to ready")
+	}
+	if attempts != 3 {
+		t.Errorf("got %d attempts, want 3", attempts)
+	}
+	if err := <-c.result; err != nil {
+		t.Errorf("command result: got %v, want nil", err)
+	}
+}
+
+func TestStateRetryGivesUp(t *testing.T) {
+	c := newCommand(func(*Driver) error {
+		return &driverError{id: errorTransient, err: errors.New("timeout")}
+	})
+
+	d := newTestDriver()
+	d.pending = c
+	d.err = c.run(d)
+
+	if got := runUntil(t, d, stateError, stateReady, stateNotConnected, stateStop); !sameState(got, stateNotConnected) {
+		t.Fatal("persistent failures did not disconnect")
+	}
+	if err := <-c.result; errorIdOf(err) != errorNotConnected {
+		t.Errorf("command result: got %v, want a %s error", err, errorNotConnected)
+	}
+}
+
+func TestWaitFailsCommands(t *testing.T) {
+	d := newTestDriver()
+	d.command = make(chan *command)
+	d.retryBackoff = time.Hour
+
+	d.stop = make(chan bool)
+	c := newCommand(func(*Driver) error { return nil })
+	d.err = &driverError{id: errorNotConnected, err: errors.New("refused")}
+	go stateNotConnected(d)
+	defer func() { d.stop <- true }()
+
+	// The driver is waiting to reconnect, but does not keep callers waiting.
+	d.command <- c
+	if err := <-c.result; err != ErrNotReady {
+		t.Errorf("command result: got %v, want %v", err, ErrNotReady)
+	}
+}
+
+func TestStopWhileWaiting(t *testing.T) {
+	for _, state := range []stateFn{stateRetry, stateNotConnected} {
+		d := newTestDriver()
+		d.stop = make(chan bool)
+		d.retryBackoff = time.Hour
+		d.pending = newCommand(func(*Driver) error { return nil })
+
+		go func() { d.stop <- true }()
+		if next := state(d); !sameState(next, stateStop) {
+			t.Error("the driver did not stop while waiting to recover")
+		}
+	}
+}
+
+func TestFrameworkErrorStopsDriver(t *testing.T) {
+	registered := mesos_scheduler.Event_REGISTERED
+	errorType := mesos_scheduler.Event_ERROR
+	message := "framework removed"
+
+	for _, registerFirst := range []bool{true, false} {
+		transport := NewChannelTransport()
+		driver, err := NewWithConfig(DriverConfig{
+			FrameworkName: "framework",
+			Log:           NewLog(LogConfig{}),
+			Transport:     transport,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if registerFirst {
+			transport.Deliver(&mesos_scheduler.Event{
+				Type: &registered,
+				Registered: &mesos_scheduler.Event_Registered{
+					FrameworkId: &mesos.FrameworkID{Value: proto.String("framework-1")},
+				},
+			})
+		}
+		transport.Deliver(&mesos_scheduler.Event{
+			Type:  &errorType,
+			Error: &mesos_scheduler.Event_Error{Message: &message},
+		})
+
+		select {
+		case _, ok := <-driver.Updates:
+			if ok {
+				t.Fatal("unexpected update")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("registered %v: driver did not stop", registerFirst)
+		}
+
+		// Err is set before the channels are closed.
+		if err, ok := driver.Err().(*FrameworkError); !ok || err.Message != message {
+			t.Errorf("registered %v: got error %v, want a framework error %q", registerFirst, driver.Err(), message)
+		}
+		if _, ok := <-driver.Offers; ok {
+			t.Errorf("registered %v: offers channel still open", registerFirst)
+		}
+		if _, ok := <-driver.Lost; ok {
+			t.Errorf("registered %v: lost channel still open", registerFirst)
+		}
+	}
+}
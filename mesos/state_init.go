@@ -1,33 +1,29 @@
 package mesos
 
 import (
-	"fmt"
-	"net/http"
 	"time"
 )
 
 const maxDelay = 2 * time.Minute
 
-// We wait until HTTP Pid endpoint is ready and healthy
+// We wait until the transport is ready, e.g. the HTTP Pid endpoint is healthy
 func stateInit(d *Driver) stateFn {
 	d.config.Log.Info.Println("INIT: Starting framework:", d)
 
 	delay := time.Second
-	healthURL := fmt.Sprintf("http://%s:%d/health", d.pidIp, d.pidPort)
 
-	// Start Pid endpoint
-	go startServing(d)
-
-	// Now wait for healthy endpoint
 	for {
-		resp, err := http.Get(healthURL)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			// Ignore content
+		err := d.transport.Start()
+		if err == nil {
 			break
 		}
 
-		d.config.Log.Warn.Printf("INIT: Timeout for URL %q: %+v", healthURL, err)
-		time.Sleep(delay)
+		d.config.Log.Warn.Printf("INIT: Transport not ready: %+v", err)
+		select {
+		case <-d.stop:
+			return stateStop
+		case <-time.After(delay):
+		}
 		if delay < maxDelay {
 			delay = delay * 2
 		}
@@ -19,21 +19,36 @@ func stateReady(d *Driver) stateFn {
 			return stateStop
 		}
 		stateSendCommand := func(fm *Driver) stateFn {
-			if err := command(fm); err != nil {
+			if err := command.run(fm); err != nil {
 				d.config.Log.Error.Println("Failed to run command:", err)
+				fm.pending = command
+				fm.err = err
 				return stateError
 			}
+			command.result <- nil
 			return stateReady
 		}
 		return stateSendCommand
 
-	case event, ok := <-d.events:
+	case failover := <-d.stop:
+		return d.stopState(failover)
+
+	case err := <-d.transport.Failures():
+		d.err = err
+		return stateError
+
+	case event, ok := <-d.transport.Events():
 		if !ok {
 			return stateStop
 		}
 		stateReceiveEvent := func(fm *Driver) stateFn {
 			if err := fm.eventDispatch(event); err != nil {
 				d.config.Log.Error.Println("Failed to dispatch event:", err)
+				if _, ok := err.(*driverError); !ok {
+					// A bad event from the master does not affect the driver.
+					err = &driverError{id: errorNone, err: err}
+				}
+				fm.err = err
 				return stateError
 			}
 			return stateReady
@@ -1,8 +1,11 @@
 package mesos
 
 import (
+	"context"
 	"time"
 
+	"code.google.com/p/goprotobuf/proto"
+
 	"github.com/twitter/gozer/proto/mesos.pb"
 	"github.com/twitter/gozer/proto/scheduler.pb"
 )
@@ -12,7 +15,8 @@ const maxRegisterWait = 20 * time.Second
 func stateRegister(d *Driver) stateFn {
 	d.config.Log.Info.Printf("REGISTERING: Trying to register framework: %+v", d)
 
-	// Create the register message and send it.
+	// Create the register message and send it. If we have been registered before,
+	// re-register with the same framework id so that our tasks are kept.
 	callType := mesos_scheduler.Call_REGISTER
 	registerCall := &mesos_scheduler.Call{
 		Type: &callType,
@@ -21,8 +25,15 @@ func stateRegister(d *Driver) stateFn {
 			Name: &d.config.FrameworkName,
 		},
 	}
+	if d.config.FailoverTimeout > 0 {
+		registerCall.FrameworkInfo.FailoverTimeout = proto.Float64(d.config.FailoverTimeout.Seconds())
+	}
+	if d.frameworkId.Value != nil {
+		callType = mesos_scheduler.Call_REREGISTER
+		registerCall.FrameworkInfo.Id = &d.frameworkId
+	}
 
-	err := d.send(registerCall)
+	err := d.transport.Send(context.Background(), registerCall)
 	registerBackoff := 1 * time.Second
 
 	if err != nil {
@@ -31,25 +42,50 @@ func stateRegister(d *Driver) stateFn {
 	}
 
 	// Wait for Registered event, throw away any other events
+	timeout := time.After(maxRegisterWait)
 	registered := false
 	for !registered {
-		select{
-		case event := <-d.events:
-			if *event.Type != mesos_scheduler.Event_REGISTERED {
+		select {
+		case event := <-d.transport.Events():
+			switch *event.Type {
+			case mesos_scheduler.Event_REGISTERED:
+				d.idLock.Lock()
+				d.frameworkId = *event.Registered.FrameworkId
+				d.idLock.Unlock()
+				d.masterInfo = event.Registered.MasterInfo
+				registered = true
+
+			case mesos_scheduler.Event_REREGISTERED:
+				if err := d.eventDispatch(event); err != nil {
+					d.config.Log.Error.Println("Failed to dispatch event:", err)
+					continue
+				}
+				return stateReady
+
+			case mesos_scheduler.Event_ERROR:
+				d.err = d.eventDispatch(event)
+				return stateError
+
+			default:
 				d.config.Log.Error.Printf("Unexpected event type: want %q, got %+v",
 					mesos_scheduler.Event_REGISTERED, *event.Type)
-				continue
 			}
-			d.frameworkId = *event.Registered.FrameworkId
-			registered = true
-			break
 
-		case <-time.After(maxRegisterWait):
+		case failover := <-d.stop:
+			return d.stopState(failover)
+
+		case err := <-d.transport.Failures():
+			d.config.Log.Error.Println("Failed while registering:", err)
+			d.err = err
+			return stateError
+
+		case <-timeout:
 			d.config.Log.Error.Printf("Failed to register after %s", maxRegisterWait)
+			d.err = newDriverError(errorNotConnected, "no response to registration after %s", maxRegisterWait)
 			return stateError
 
 		case <-time.After(registerBackoff):
-			err := d.send(registerCall)
+			err := d.transport.Send(context.Background(), registerCall)
 			if err != nil {
 				registerBackoff = registerBackoff * 2
 				d.config.Log.Warn.Println("Failed to send register:", err)
@@ -60,5 +96,7 @@ func stateRegister(d *Driver) stateFn {
 
 	d.config.Log.Info.Printf("Registered %s:%s with id %q",
 		d.config.RegisteredUser, d.config.FrameworkName, *d.frameworkId.Value)
+	frameworkId, masterInfo := *d.frameworkId.Value, d.masterInfo
+	d.schedule(func(s Scheduler) { s.Registered(d, frameworkId, masterInfo) })
 	return stateReady
 }
@@ -1,9 +1,11 @@
 package mesos
 
 import (
-	"code.google.com/p/go-uuid/uuid"
+	"context"
 	"fmt"
 
+	"code.google.com/p/go-uuid/uuid"
+
 	"github.com/twitter/gozer/proto/mesos.pb"
 	"github.com/twitter/gozer/proto/scheduler.pb"
 )
@@ -12,6 +14,8 @@ type TaskStateUpdate struct {
 	TaskId  string
 	SlaveId string
 	State   mesos.TaskState
+	// Message explains the state, such as how a command exited.
+	Message string
 	uuid    uuid.UUID
 	driver  *Driver
 }
@@ -24,8 +28,14 @@ func (u *TaskStateUpdate) String() string {
 		u.State.String())
 }
 
-func (u *TaskStateUpdate) Ack() {
-	u.driver.command <- func(d *Driver) error {
+// Ack acknowledges the update so that the slave stops resending it. Updates the
+// master generates itself, such as the answers to ReconcileTasks, have no UUID and
+// need no acknowledgement.
+func (u *TaskStateUpdate) Ack(ctx context.Context) error {
+	if len(u.uuid) == 0 {
+		return nil
+	}
+	return u.driver.do(ctx, func(d *Driver) error {
 		acknowledgeType := mesos_scheduler.Call_ACKNOWLEDGE
 		acknowledgeCall := &mesos_scheduler.Call{
 			FrameworkInfo: &mesos.FrameworkInfo{
@@ -45,6 +55,6 @@ func (u *TaskStateUpdate) Ack() {
 			},
 		}
 
-		return d.send(acknowledgeCall)
-	}
+		return d.transport.Send(ctx, acknowledgeCall)
+	})
 }
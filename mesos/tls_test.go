@@ -0,0 +1,187 @@
+package mesos
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+	"github.com/twitter/gozer/proto/messages.pb"
+)
+
+// testCA issues certificates for 127.0.0.1 that are valid for both server and client
+// authentication.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gozer test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// registeringScheduler reports the framework id it is registered with.
+type registeringScheduler struct {
+	recordingScheduler
+	registered chan string
+}
+
+func (s *registeringScheduler) Registered(d *Driver, frameworkId string, masterInfo *mesos.MasterInfo) {
+	s.registered <- frameworkId
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	masterCert := ca.issue(t, "master", 2)
+	frameworkCert := ca.issue(t, "framework", 3)
+
+	// Both sides insist on a certificate signed by the test CA.
+	serverTLS := func(cert tls.Certificate) *tls.Config {
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    ca.pool,
+		}
+	}
+	clientTLS := func(cert tls.Certificate) *tls.Config {
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      ca.pool,
+		}
+	}
+
+	// A stand-in master that answers registration by calling back to the framework.
+	masterClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS(masterCert)}}
+	var masterPid string
+	master := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/master/mesos.internal.RegisterFrameworkMessage" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		from, err := ParseUPID(r.Header.Get("Libprocess-From"))
+		if err != nil {
+			t.Errorf("register from unknown sender: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+		id := "framework-1"
+		body, _ := proto.Marshal(&mesos_internal.FrameworkRegisteredMessage{
+			FrameworkId: &mesos.FrameworkID{Value: &id},
+			MasterInfo:  &mesos.MasterInfo{Pid: &masterPid},
+		})
+		url := "https://" + net.JoinHostPort(from.Host, strconv.Itoa(from.Port)) + "/" + from.Id +
+			"/mesos.internal.FrameworkRegisteredMessage"
+		req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+		req.Header.Set("Libprocess-From", masterPid)
+		go func() {
+			resp, err := masterClient.Do(req)
+			if err != nil {
+				t.Errorf("master failed to call back framework: %v", err)
+				return
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}()
+	}))
+	master.TLS = serverTLS(masterCert)
+	master.StartTLS()
+	defer master.Close()
+
+	masterAddr := master.Listener.Addr().(*net.TCPAddr)
+	masterPid = "master@" + masterAddr.String()
+
+	driver, err := NewWithConfig(DriverConfig{
+		FrameworkName: "framework",
+		Masters:       []MasterAddress{{Hostname: "127.0.0.1", Port: masterAddr.Port}},
+		Log:           NewLog(LogConfig{}),
+		Endpoint: RouterConfig{
+			BindAddress: "127.0.0.1",
+			ServerTLS:   serverTLS(frameworkCert),
+			ClientTLS:   clientTLS(frameworkCert),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Stop(true)
+
+	sched := &registeringScheduler{registered: make(chan string, 1)}
+	go RunScheduler(driver, sched)
+
+	select {
+	case id := <-sched.registered:
+		if id != "framework-1" {
+			t.Errorf("registered with id %q, want %q", id, "framework-1")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("framework did not register over TLS")
+	}
+
+	// A peer without a client certificate is turned away by the framework endpoint.
+	plain := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool}}}
+	if _, err := plain.Post("https://"+driver.transport.(*libprocessTransport).router.localAddr()+"/framework/x", "", nil); err == nil {
+		t.Error("framework endpoint accepted a connection without a client certificate")
+	}
+}
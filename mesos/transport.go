@@ -0,0 +1,105 @@
+package mesos
+
+import (
+	"context"
+
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+// A Transport carries calls from the driver to the master, and events from the
+// master back to the driver. The driver's state machine only talks to the master
+// through its Transport, so the wire protocol can be swapped out without touching it.
+//
+// Errors returned by Send, or reported on Failures, that were not created by this
+// package stop the driver.
+type Transport interface {
+	// Start prepares the transport, for example by checking that the endpoint the
+	// master calls back on is reachable. The driver retries it until it succeeds.
+	Start() error
+
+	// Send delivers call to the master.
+	Send(ctx context.Context, call *mesos_scheduler.Call) error
+
+	// Events returns the channel that events from the master are delivered on.
+	Events() <-chan *mesos_scheduler.Event
+
+	// Failures returns a channel on which the transport reports errors noticed
+	// outside of Send, such as a lost connection to the master. It may be nil.
+	Failures() <-chan error
+
+	// Disconnect drops any connection to the master. The transport reconnects the
+	// next time the driver registers.
+	Disconnect()
+
+	// Close releases the transport. No events are delivered after it returns.
+	Close() error
+}
+
+// newTransport returns the transport config asks for.
+func newTransport(config *DriverConfig, m *metrics) (Transport, error) {
+	if config.Transport != nil {
+		return config.Transport, nil
+	}
+
+	if config.Protocol == ProtocolHTTP {
+		return newHTTPTransport(config, m), nil
+	}
+	return newLibprocessTransport(config, m)
+}
+
+// ChannelTransport connects a driver to an in-memory master, so that schedulers and
+// the driver itself can be tested without any sockets. Calls the driver sends are
+// delivered on Calls, and the test plays the master by passing events to Deliver.
+type ChannelTransport struct {
+	Calls chan *mesos_scheduler.Call
+
+	events chan *mesos_scheduler.Event
+	done   chan struct{}
+}
+
+// NewChannelTransport creates a ChannelTransport.
+func NewChannelTransport() *ChannelTransport {
+	return &ChannelTransport{
+		Calls:  make(chan *mesos_scheduler.Call, 100),
+		events: make(chan *mesos_scheduler.Event, 100),
+		done:   make(chan struct{}),
+	}
+}
+
+// Deliver sends event to the driver. It returns false if the transport is closed.
+func (t *ChannelTransport) Deliver(event *mesos_scheduler.Event) bool {
+	select {
+	case t.events <- event:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+func (t *ChannelTransport) Start() error { return nil }
+
+func (t *ChannelTransport) Send(ctx context.Context, call *mesos_scheduler.Call) error {
+	select {
+	case t.Calls <- call:
+		return nil
+	case <-ctx.Done():
+		return newDriverError(errorNone, "failed to send %s call: %+v", call.GetType(), ctx.Err())
+	case <-t.done:
+		return newDriverError(errorFatal, "failed to send %s call: transport is closed", call.GetType())
+	}
+}
+
+func (t *ChannelTransport) Events() <-chan *mesos_scheduler.Event { return t.events }
+
+func (t *ChannelTransport) Failures() <-chan error { return nil }
+
+func (t *ChannelTransport) Disconnect() {}
+
+func (t *ChannelTransport) Close() error {
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	return nil
+}
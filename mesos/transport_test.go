@@ -0,0 +1,193 @@
+package mesos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+	"github.com/twitter/gozer/proto/scheduler.pb"
+)
+
+func TestChannelTransport(t *testing.T) {
+	transport := NewChannelTransport()
+	driver, err := NewWithConfig(DriverConfig{
+		FrameworkName: "framework",
+		Log:           NewLog(LogConfig{}),
+		Transport:     transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Stop(true)
+
+	sched := &offeringScheduler{declined: make(chan error, 1)}
+	go RunScheduler(driver, sched)
+
+	nextCall := func() *mesos_scheduler.Call {
+		select {
+		case call := <-transport.Calls:
+			return call
+		case <-time.After(10 * time.Second):
+			t.Fatal("driver sent no call")
+			return nil
+		}
+	}
+
+	if call := nextCall(); call.GetType() != mesos_scheduler.Call_REGISTER {
+		t.Fatalf("got call %v, want %v", call.GetType(), mesos_scheduler.Call_REGISTER)
+	}
+
+	// Play the master: accept the registration and make an offer.
+	frameworkId := &mesos.FrameworkID{Value: proto.String("framework-1")}
+	registered := mesos_scheduler.Event_REGISTERED
+	offers := mesos_scheduler.Event_OFFERS
+	transport.Deliver(&mesos_scheduler.Event{
+		Type:       &registered,
+		Registered: &mesos_scheduler.Event_Registered{FrameworkId: frameworkId},
+	})
+	transport.Deliver(&mesos_scheduler.Event{
+		Type: &offers,
+		Offers: &mesos_scheduler.Event_Offers{Offers: []*mesos.Offer{{
+			Id:          &mesos.OfferID{Value: proto.String("offer-1")},
+			FrameworkId: frameworkId,
+			SlaveId:     &mesos.SlaveID{Value: proto.String("slave-1")},
+			Hostname:    proto.String("slave-1"),
+		}}},
+	})
+
+	select {
+	case err := <-sched.declined:
+		if err != nil {
+			t.Fatalf("decline: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("offer was not delivered to the scheduler")
+	}
+
+	call := nextCall()
+	if call.GetType() != mesos_scheduler.Call_DECLINE {
+		t.Fatalf("got call %v, want %v", call.GetType(), mesos_scheduler.Call_DECLINE)
+	}
+	if id := call.GetDecline().GetOfferIds()[0].GetValue(); id != "offer-1" {
+		t.Errorf("declined offer %q, want %q", id, "offer-1")
+	}
+	if id := call.GetFrameworkInfo().GetId().GetValue(); id != "framework-1" {
+		t.Errorf("decline from framework %q, want %q", id, "framework-1")
+	}
+}
+
+// newRegisteredDriver starts a driver on a ChannelTransport and registers it as
+// framework-1.
+func newRegisteredDriver(t *testing.T) (*Driver, *ChannelTransport) {
+	transport := NewChannelTransport()
+	driver, err := NewWithConfig(DriverConfig{
+		FrameworkName: "framework",
+		Log:           NewLog(LogConfig{}),
+		Transport:     transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sched := &registeringScheduler{registered: make(chan string, 1)}
+	go RunScheduler(driver, sched)
+
+	<-transport.Calls // REGISTER
+	registered := mesos_scheduler.Event_REGISTERED
+	transport.Deliver(&mesos_scheduler.Event{
+		Type: &registered,
+		Registered: &mesos_scheduler.Event_Registered{
+			FrameworkId: &mesos.FrameworkID{Value: proto.String("framework-1")},
+		},
+	})
+	<-sched.registered
+
+	return driver, transport
+}
+
+func TestStop(t *testing.T) {
+	for _, failover := range []bool{false, true} {
+		driver, transport := newRegisteredDriver(t)
+
+		if err := driver.Stop(failover); err != nil {
+			t.Errorf("failover %v: Stop: %v", failover, err)
+		}
+
+		var calls []mesos_scheduler.Call_Type
+		for len(transport.Calls) > 0 {
+			calls = append(calls, (<-transport.Calls).GetType())
+		}
+		if failover && len(calls) != 0 {
+			t.Errorf("failover: got calls %v, want none", calls)
+		}
+		if !failover && (len(calls) != 1 || calls[0] != mesos_scheduler.Call_UNREGISTER) {
+			t.Errorf("got calls %v, want %v", calls, mesos_scheduler.Call_UNREGISTER)
+		}
+
+		if _, ok := <-driver.Offers; ok {
+			t.Errorf("failover %v: offers channel still open", failover)
+		}
+		offer := &Offer{driver: driver, mesosOffer: &mesos.Offer{Id: &mesos.OfferID{Value: proto.String("offer-1")}}}
+		if err := offer.Decline(context.Background()); err != ErrStopped {
+			t.Errorf("failover %v: decline after stop: got %v, want %v", failover, err, ErrStopped)
+		}
+		if err := driver.Stop(failover); err != nil {
+			t.Errorf("failover %v: second Stop: %v", failover, err)
+		}
+	}
+}
+
+func TestReregisterWithFrameworkId(t *testing.T) {
+	transport := NewChannelTransport()
+	driver, err := NewWithConfig(DriverConfig{
+		FrameworkName:   "framework",
+		FrameworkId:     "framework-0",
+		FailoverTimeout: time.Hour,
+		Log:             NewLog(LogConfig{}),
+		Transport:       transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Stop(true)
+
+	call := <-transport.Calls
+	if call.GetType() != mesos_scheduler.Call_REREGISTER {
+		t.Fatalf("got call %v, want %v", call.GetType(), mesos_scheduler.Call_REREGISTER)
+	}
+	if id := call.GetFrameworkInfo().GetId().GetValue(); id != "framework-0" {
+		t.Errorf("re-registered as %q, want %q", id, "framework-0")
+	}
+	if timeout := call.GetFrameworkInfo().GetFailoverTimeout(); timeout != 3600 {
+		t.Errorf("got failover timeout %v, want %v", timeout, 3600)
+	}
+
+	// The master must be told to fail the framework over to us.
+	message, err := callToMessage(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proto.Marshal(message); err != nil {
+		t.Errorf("marshal %v: %v", message, err)
+	}
+
+	reregistered := mesos_scheduler.Event_REREGISTERED
+	transport.Deliver(&mesos_scheduler.Event{
+		Type: &reregistered,
+		Reregistered: &mesos_scheduler.Event_Reregistered{
+			FrameworkId: &mesos.FrameworkID{Value: proto.String("framework-0")},
+			MasterInfo:  &mesos.MasterInfo{},
+		},
+	})
+
+	// Commands are only run once the driver is registered.
+	if err := driver.ReconcileTasks(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if id := driver.FrameworkId(); id != "framework-0" {
+		t.Errorf("got framework id %q, want %q", id, "framework-0")
+	}
+}
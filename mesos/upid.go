@@ -0,0 +1,42 @@
+package mesos
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// UPID identifies a libprocess process as id@host:port.
+type UPID struct {
+	Id   string
+	Host string
+	Port int
+}
+
+// ParseUPID parses a libprocess PID such as "master@10.0.0.1:5050".
+func ParseUPID(pid string) (*UPID, error) {
+	at := strings.Index(pid, "@")
+	if at <= 0 {
+		return nil, fmt.Errorf("invalid pid %q: missing id", pid)
+	}
+
+	host, portStr, err := net.SplitHostPort(pid[at+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pid %q: %+v", pid, err)
+	}
+	if host == "" {
+		return nil, fmt.Errorf("invalid pid %q: missing host", pid)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid pid %q: bad port %q", pid, portStr)
+	}
+
+	return &UPID{Id: pid[:at], Host: host, Port: port}, nil
+}
+
+func (u UPID) String() string {
+	return u.Id + "@" + net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
@@ -0,0 +1,200 @@
+package mesos
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+)
+
+// Messages that slaves send straight to the framework rather than via the master.
+var slaveMessages = map[string]bool{
+	"mesos.internal.ExecutorToFrameworkMessage": true,
+}
+
+// Messages that a newly elected master sends before it is known to be the leader.
+var registrationMessages = map[string]bool{
+	"mesos.internal.FrameworkRegisteredMessage":   true,
+	"mesos.internal.FrameworkReregisteredMessage": true,
+}
+
+// senderPid returns the PID of the libprocess process that sent r. Older libprocess
+// versions set Libprocess-From, newer ones identify themselves in the User-Agent.
+func senderPid(r *http.Request) (*UPID, error) {
+	if from := r.Header.Get("Libprocess-From"); from != "" {
+		return ParseUPID(from)
+	}
+
+	if agent := r.Header.Get("User-Agent"); strings.HasPrefix(agent, "libprocess/") {
+		return ParseUPID(strings.TrimPrefix(agent, "libprocess/"))
+	}
+
+	return nil, errors.New("request does not identify a libprocess sender")
+}
+
+// verifySender checks that a message of the given type was sent by a process we
+// expect it from: the leading master for most messages, or, until we know who that
+// is, one of the configured masters.
+func (t *libprocessTransport) verifySender(r *http.Request, messageType string) error {
+	from, err := senderPid(r)
+	if err != nil {
+		return err
+	}
+
+	if slaveMessages[messageType] {
+		// Executors only run on the slaves we have been offered resources on.
+		t.slaveLock.RLock()
+		defer t.slaveLock.RUnlock()
+		for host := range t.slaveHosts {
+			if t.hosts.same(from.Host, host) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s sent by %s, which is not a slave we have been offered", messageType, from)
+	}
+
+	if from.Id != "master" {
+		return fmt.Errorf("%s sent by %s, which is not a master", messageType, from)
+	}
+
+	t.masterLock.RLock()
+	leader := t.masterPid
+	t.masterLock.RUnlock()
+
+	if leader != nil && from.Port == leader.Port && t.hosts.same(from.Host, leader.Host) {
+		return nil
+	}
+
+	if leader == nil || registrationMessages[messageType] {
+		for _, master := range t.masters {
+			if from.Port == master.Port && t.hosts.same(from.Host, master.Hostname) {
+				return nil
+			}
+		}
+	}
+
+	if leader != nil {
+		return fmt.Errorf("%s sent by %s, but the leading master is %s", messageType, from, leader)
+	}
+	return fmt.Errorf("%s sent by %s, which is not a configured master", messageType, from)
+}
+
+// setLeader records the leading master from the master info it sent us.
+func (t *libprocessTransport) setLeader(info *mesos.MasterInfo) {
+	var pid *UPID
+	if info != nil {
+		var err error
+		if pid, err = ParseUPID(info.GetPid()); err != nil {
+			// Masters that predate the pid field still tell us where they are. The ip
+			// is in network byte order.
+			ip := info.GetIp()
+			pid = &UPID{
+				Id:   "master",
+				Host: net.IPv4(byte(ip), byte(ip>>8), byte(ip>>16), byte(ip>>24)).String(),
+				Port: int(info.GetPort()),
+			}
+		}
+	}
+
+	t.masterLock.Lock()
+	t.masterPid = pid
+	t.masterLock.Unlock()
+}
+
+// addSlaves records the hosts of the slaves offers are from, which executors may
+// send us messages from.
+func (t *libprocessTransport) addSlaves(offers []*mesos.Offer) {
+	t.slaveLock.Lock()
+	defer t.slaveLock.Unlock()
+	if t.slaveHosts == nil {
+		t.slaveHosts = make(map[string]bool)
+	}
+	for _, offer := range offers {
+		t.slaveHosts[offer.GetHostname()] = true
+	}
+}
+
+// How long a host's addresses are remembered for.
+const hostCacheTime = 5 * time.Minute
+
+// hostCache remembers the addresses hosts resolve to, so that verifying a message
+// does not mean looking up its sender every time. The zero value is ready to use.
+type hostCache struct {
+	lock  sync.Mutex
+	hosts map[string]*cachedHost
+	// Looks up a host's addresses; net.LookupHost if nil.
+	resolve func(host string) ([]string, error)
+}
+
+// cachedHost is a host that has been or is being looked up. Its other fields are
+// set, under the cache's lock, before ready is closed.
+type cachedHost struct {
+	ready    chan struct{}
+	resolved bool
+	addrs    []net.IP
+	expires  time.Time
+}
+
+// lookup returns the addresses of host, which may be a name or an address. A host
+// that does not resolve has no addresses. Lookups of a host wait for one another,
+// but not for lookups of other hosts.
+func (c *hostCache) lookup(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+
+	c.lock.Lock()
+	if cached, ok := c.hosts[host]; ok && (!cached.resolved || time.Now().Before(cached.expires)) {
+		c.lock.Unlock()
+		<-cached.ready
+		return cached.addrs
+	}
+	if c.hosts == nil {
+		c.hosts = make(map[string]*cachedHost)
+	}
+	cached := &cachedHost{ready: make(chan struct{})}
+	c.hosts[host] = cached
+	resolve := c.resolve
+	c.lock.Unlock()
+
+	if resolve == nil {
+		resolve = net.LookupHost
+	}
+	var addrs []net.IP
+	if names, err := resolve(host); err == nil {
+		for _, name := range names {
+			if ip := net.ParseIP(name); ip != nil {
+				addrs = append(addrs, ip)
+			}
+		}
+	}
+
+	c.lock.Lock()
+	cached.addrs, cached.expires, cached.resolved = addrs, time.Now().Add(hostCacheTime), true
+	c.lock.Unlock()
+	close(cached.ready)
+	return addrs
+}
+
+// same reports whether the two hosts, given as names or addresses, share an
+// address.
+func (c *hostCache) same(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	addrsB := c.lookup(b)
+	for _, x := range c.lookup(a) {
+		for _, y := range addrsB {
+			if x.Equal(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package mesos
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/mesos.pb"
+)
+
+func TestParseUPID(t *testing.T) {
+	pid, err := ParseUPID("scheduler(1)@10.0.0.1:5051")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *pid != (UPID{Id: "scheduler(1)", Host: "10.0.0.1", Port: 5051}) {
+		t.Errorf("got %+v", pid)
+	}
+	if pid.String() != "scheduler(1)@10.0.0.1:5051" {
+		t.Errorf("String(): got %q", pid.String())
+	}
+
+	for _, bad := range []string{"", "master", "@10.0.0.1:5050", "master@10.0.0.1", "master@:5050", "master@10.0.0.1:0"} {
+		if _, err := ParseUPID(bad); err == nil {
+			t.Errorf("ParseUPID(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestVerifySender(t *testing.T) {
+	transport := &libprocessTransport{masters: []MasterAddress{{Hostname: "127.0.0.1", Port: 5050}}}
+
+	request := func(header, value string) *http.Request {
+		r, err := http.NewRequest("POST", "/framework/message", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		return r
+	}
+
+	const update = "mesos.internal.StatusUpdateMessage"
+	const registered = "mesos.internal.FrameworkRegisteredMessage"
+	const executor = "mesos.internal.ExecutorToFrameworkMessage"
+
+	tests := []struct {
+		header, value, message string
+		ok                     bool
+	}{
+		{"", "", update, false},
+		{"Libprocess-From", "garbage", update, false},
+		{"Libprocess-From", "master@127.0.0.1:5050", update, true},
+		{"User-Agent", "libprocess/master@127.0.0.1:5050", update, true},
+		{"User-Agent", "curl/7.0", update, false},
+		{"Libprocess-From", "master@127.0.0.2:5050", update, false},
+		{"Libprocess-From", "slave(1)@127.0.0.2:5051", update, false},
+		{"Libprocess-From", "slave(1)@127.0.0.2:5051", executor, false},
+	}
+	for _, test := range tests {
+		err := transport.verifySender(request(test.header, test.value), test.message)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: %q for %s: got %v, want ok=%v", test.header, test.value, test.message, err, test.ok)
+		}
+	}
+
+	// Executors may message us from the slaves we have been offered.
+	transport.addSlaves([]*mesos.Offer{{Hostname: proto.String("localhost")}})
+	if err := transport.verifySender(request("Libprocess-From", "slave(1)@127.0.0.1:5051"), executor); err != nil {
+		t.Errorf("message from an offered slave: %v", err)
+	}
+	if err := transport.verifySender(request("Libprocess-From", "slave(1)@127.0.0.2:5051"), executor); err == nil {
+		t.Errorf("message from a slave we were not offered was accepted")
+	}
+
+	// Once a leader is known, other configured masters may only re-register us.
+	ip := uint32(127) | 3<<24 // 127.0.0.3
+	port := uint32(5050)
+	transport.setLeader(&mesos.MasterInfo{Ip: &ip, Port: &port})
+
+	if err := transport.verifySender(request("Libprocess-From", "master@127.0.0.3:5050"), update); err != nil {
+		t.Errorf("update from leader: %v", err)
+	}
+	if err := transport.verifySender(request("Libprocess-From", "master@127.0.0.1:5050"), update); err == nil {
+		t.Errorf("update from a master that is not leading was accepted")
+	}
+	if err := transport.verifySender(request("Libprocess-From", "master@127.0.0.1:5050"), registered); err != nil {
+		t.Errorf("registration from configured master: %v", err)
+	}
+}
+
+func TestHostCache(t *testing.T) {
+	var c hostCache
+	if !c.same("127.0.0.1", "localhost") {
+		t.Error("localhost is not 127.0.0.1")
+	}
+
+	// Hosts are looked up once, until their addresses expire.
+	c.hosts["localhost"].addrs = []net.IP{net.ParseIP("10.0.0.1")}
+	if !c.same("10.0.0.1", "localhost") {
+		t.Error("localhost was looked up again")
+	}
+	c.hosts["localhost"].expires = time.Now()
+	if c.same("10.0.0.1", "localhost") {
+		t.Error("localhost was not looked up again once it expired")
+	}
+}
+
+func TestHostCacheLookupsDoNotBlock(t *testing.T) {
+	slow := make(chan bool)
+	c := hostCache{resolve: func(host string) ([]string, error) {
+		if host == "slow" {
+			<-slow
+		}
+		return []string{"10.0.0.1"}, nil
+	}}
+
+	looked := make(chan []net.IP, 2)
+	go func() { looked <- c.lookup("slow") }()
+	go func() { looked <- c.lookup("slow") }()
+
+	// Other hosts are looked up while the slow one is.
+	done := make(chan bool)
+	go func() {
+		c.lookup("fast")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("looking up a host waited for the lookup of another")
+	}
+
+	close(slow)
+	for i := 0; i < 2; i++ {
+		if addrs := <-looked; len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("10.0.0.1")) {
+			t.Errorf("got addresses %v for slow, want 10.0.0.1", addrs)
+		}
+	}
+}
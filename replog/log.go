@@ -0,0 +1,601 @@
+package replog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/log.pb"
+)
+
+var (
+	// ErrDemoted is returned by a write that failed because another writer has been
+	// elected since this one was. The next write tries to be elected again.
+	ErrDemoted = errors.New("another writer has been elected")
+
+	errNoQuorum = errors.New("no quorum of replicas answered")
+)
+
+const (
+	// How long to wait for a replica to answer a request.
+	requestTimeout = 2 * time.Second
+	// How many times to try each round before giving up.
+	roundAttempts = 5
+	// How long to wait before asking the replicas again while recovering.
+	recoverRetry = 100 * time.Millisecond
+)
+
+// Entry is an appended entry of the log.
+type Entry struct {
+	Position uint64
+	Data     []byte
+}
+
+// Log is a log replicated among a set of replicas with a variant of Paxos, as in
+// Mesos. Appending to the log, or truncating it, chooses the action at the next
+// position: a coordinator is elected by a quorum of replicas, and once elected writes
+// actions to a quorum of them without further election. Any replica may be the
+// coordinator, but only one at a time makes progress.
+//
+// Entries are read from the local replica, which learns of each action chosen while
+// it is running, and catches up on the others with CatchUp, or with Learn if it must
+// not disturb the coordinator.
+type Log struct {
+	replica *Replica
+	// Every replica, the local one first, and how many of them make a quorum.
+	peers  []Peer
+	quorum int
+
+	// The coordinator's state, which is guarded by lock: the highest proposal made,
+	// and the one we were elected with, or 0 if we are not elected.
+	lock     sync.Mutex
+	proposal uint64
+	elected  uint64
+	// The last position written while elected.
+	index uint64
+}
+
+// New returns a log kept by replica and the replicas reached through peers.
+func New(replica *Replica, peers []Peer) *Log {
+	all := append([]Peer{replica}, peers...)
+	return &Log{
+		replica: replica,
+		peers:   all,
+		quorum:  len(all)/2 + 1,
+	}
+}
+
+// quorumCall calls every peer at once, and returns the first quorum of responses.
+// It returns early if so many calls fail that there can be no quorum.
+func (l *Log) quorumCall(ctx context.Context, call func(context.Context, Peer) (interface{}, error)) ([]interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		response interface{}
+		err      error
+	}
+	results := make(chan result, len(l.peers))
+	for _, peer := range l.peers {
+		go func(peer Peer) {
+			ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+			defer cancel()
+			response, err := call(ctx, peer)
+			results <- result{response, err}
+		}(peer)
+	}
+
+	var responses []interface{}
+	var lastErr error
+	failed := 0
+	for range l.peers {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				lastErr = r.err
+				if failed++; failed > len(l.peers)-l.quorum {
+					return nil, fmt.Errorf("%v: %+v", errNoQuorum, lastErr)
+				}
+				continue
+			}
+			if responses = append(responses, r.response); len(responses) == l.quorum {
+				return responses, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errNoQuorum
+}
+
+// gather calls every peer at once, and returns the responses of those that answer.
+func (l *Log) gather(ctx context.Context, call func(context.Context, Peer) (interface{}, error)) []interface{} {
+	results := make(chan interface{}, len(l.peers))
+	for _, peer := range l.peers {
+		go func(peer Peer) {
+			ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+			defer cancel()
+			response, err := call(ctx, peer)
+			if err != nil {
+				response = nil
+			}
+			results <- response
+		}(peer)
+	}
+
+	var responses []interface{}
+	for range l.peers {
+		if response := <-results; response != nil {
+			responses = append(responses, response)
+		}
+	}
+	return responses
+}
+
+// promise asks the replicas to promise proposal, for every position or only the given
+// one. It returns the promises, or the highest proposal promised instead if any
+// replica refused.
+func (l *Log) promise(ctx context.Context, proposal uint64, position *uint64) ([]*mesos_internal_log.PromiseResponse, uint64, error) {
+	request := &mesos_internal_log.PromiseRequest{
+		Proposal: proto.Uint64(proposal),
+		Position: position,
+	}
+	results, err := l.quorumCall(ctx, func(ctx context.Context, peer Peer) (interface{}, error) {
+		return peer.Promise(ctx, request)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	responses := make([]*mesos_internal_log.PromiseResponse, len(results))
+	var promised uint64
+	for i, result := range results {
+		responses[i] = result.(*mesos_internal_log.PromiseResponse)
+		if !responses[i].GetOkay() && responses[i].GetProposal() > promised {
+			promised = responses[i].GetProposal()
+		}
+	}
+	return responses, promised, nil
+}
+
+// write asks the replicas to accept action. It returns the highest proposal promised
+// instead if any replica refused.
+func (l *Log) write(ctx context.Context, proposal uint64, action *mesos_internal_log.Action) (uint64, error) {
+	request := &mesos_internal_log.WriteRequest{
+		Proposal: proto.Uint64(proposal),
+		Position: action.Position,
+		Type:     action.Type,
+		Nop:      action.Nop,
+		Append:   action.Append,
+		Truncate: action.Truncate,
+	}
+	results, err := l.quorumCall(ctx, func(ctx context.Context, peer Peer) (interface{}, error) {
+		return peer.Write(ctx, request)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var promised uint64
+	for _, result := range results {
+		response := result.(*mesos_internal_log.WriteResponse)
+		if !response.GetOkay() && response.GetProposal() > promised {
+			promised = response.GetProposal()
+		}
+	}
+	return promised, nil
+}
+
+// learned tells every replica that action was chosen. The local replica is told
+// before it returns; the others are told in the background, and any that miss it
+// catch up later.
+func (l *Log) learned(action *mesos_internal_log.Action) error {
+	message := &mesos_internal_log.LearnedMessage{Action: action}
+	for _, peer := range l.peers[1:] {
+		go func(peer Peer) {
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			defer cancel()
+			peer.Learned(ctx, message)
+		}(peer)
+	}
+	return l.replica.Learned(context.Background(), message)
+}
+
+// nextProposal returns a proposal higher than any we know to have been promised. It
+// must be called with the lock held.
+func (l *Log) nextProposal(promised uint64) uint64 {
+	if local := l.replica.promised(); local > promised {
+		promised = local
+	}
+	if promised > l.proposal {
+		l.proposal = promised
+	}
+	l.proposal++
+	return l.proposal
+}
+
+// fill chooses the action at position with proposal: the action already chosen, if
+// there is one, and otherwise a NOP. If any replica refuses the proposal, fill
+// returns the highest proposal promised instead, and nothing is chosen. It must be
+// called with the lock held.
+func (l *Log) fill(ctx context.Context, position, proposal uint64) (uint64, error) {
+	responses, refused, err := l.promise(ctx, proposal, proto.Uint64(position))
+	if err != nil || refused > 0 {
+		return refused, err
+	}
+
+	// Of the actions the replicas have accepted, the one accepted with the highest
+	// proposal may have been chosen, and so must be chosen again.
+	var chosen *mesos_internal_log.Action
+	for _, response := range responses {
+		action := response.Action
+		if action == nil || action.Performed == nil {
+			continue
+		}
+		if action.GetLearned() {
+			chosen = action
+			break
+		}
+		if chosen == nil || action.GetPerformed() > chosen.GetPerformed() {
+			chosen = action
+		}
+	}
+	action := &mesos_internal_log.Action{
+		Position: proto.Uint64(position),
+		Promised: proto.Uint64(proposal),
+		Type:     mesos_internal_log.Action_NOP.Enum(),
+		Nop:      &mesos_internal_log.Action_Nop{},
+	}
+	if chosen != nil {
+		action.Type, action.Nop = chosen.Type, chosen.Nop
+		action.Append, action.Truncate = chosen.Append, chosen.Truncate
+	}
+
+	if refused, err = l.write(ctx, proposal, action); err != nil || refused > 0 {
+		return refused, err
+	}
+	action.Performed = proto.Uint64(proposal)
+	return 0, l.learned(action)
+}
+
+// catchUp learns every position from begin up to end that the local replica has
+// not, filling each with proposal. It stops at the first position a replica refuses
+// the proposal for, and returns the proposal promised instead. It must be called
+// with the lock held.
+func (l *Log) catchUp(ctx context.Context, begin, end, proposal uint64) (uint64, error) {
+	for position := begin; position <= end; position++ {
+		if l.replica.learned(position) != nil {
+			continue
+		}
+		if local, _ := l.replica.bounds(); position < local {
+			position = local - 1
+			continue
+		}
+		if refused, err := l.fill(ctx, position, proposal); err != nil || refused > 0 {
+			return refused, err
+		}
+	}
+	return 0, nil
+}
+
+// elect has the local replica elected coordinator, and learns every action from
+// begin that may have been chosen before, up to end at least, so that writing can
+// carry on after the last of them. Everything is chosen with the proposal the
+// replicas promised, which is the only one they told us the end of the log under;
+// if any replica refuses it, the election starts over with a higher one. It must be
+// called with the lock held.
+func (l *Log) elect(ctx context.Context, begin, end uint64) error {
+	l.elected = 0
+	var promised uint64
+	for attempt := 0; attempt < roundAttempts; attempt++ {
+		proposal := l.nextProposal(promised)
+		responses, refused, err := l.promise(ctx, proposal, nil)
+		if err != nil {
+			return err
+		}
+		if promised = refused; promised > 0 {
+			continue
+		}
+
+		last := end
+		for _, response := range responses {
+			if response.GetPosition() > last {
+				last = response.GetPosition()
+			}
+		}
+		if local, _ := l.replica.bounds(); local > begin {
+			begin = local
+		}
+		if promised, err = l.catchUp(ctx, begin, last, proposal); err != nil {
+			return err
+		}
+		if promised > 0 {
+			continue
+		}
+
+		l.elected, l.index = proposal, last
+		return nil
+	}
+	return fmt.Errorf("failed to be elected after %d attempts", roundAttempts)
+}
+
+// append chooses action at the next position, being elected first if need be.
+func (l *Log) append(ctx context.Context, action *mesos_internal_log.Action) (uint64, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.elected == 0 {
+		if err := l.elect(ctx, 0, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	position := l.index + 1
+	if action.GetType() == mesos_internal_log.Action_TRUNCATE && action.GetTruncate().GetTo() > position {
+		return 0, fmt.Errorf("can not truncate to %d, past the end of the log", action.GetTruncate().GetTo())
+	}
+	action.Position = proto.Uint64(position)
+	action.Promised = proto.Uint64(l.elected)
+
+	// Until a write succeeds, the action may or may not have been chosen, and we may
+	// not write anything else at its position. The next write is elected again,
+	// which chooses it or not for good.
+	promised, err := l.write(ctx, l.elected, action)
+	if err != nil {
+		l.elected = 0
+		return 0, err
+	}
+	if promised > 0 {
+		l.elected = 0
+		return 0, ErrDemoted
+	}
+
+	l.index = position
+	action.Performed = proto.Uint64(l.elected)
+	return position, l.learned(action)
+}
+
+// Append adds data to the end of the log, and returns its position.
+func (l *Log) Append(ctx context.Context, data []byte) (uint64, error) {
+	return l.append(ctx, &mesos_internal_log.Action{
+		Type:   mesos_internal_log.Action_APPEND.Enum(),
+		Append: &mesos_internal_log.Action_Append{Bytes: data},
+	})
+}
+
+// Truncate drops the entries before position to, and returns the position of the
+// truncation, which is itself in the log.
+func (l *Log) Truncate(ctx context.Context, to uint64) (uint64, error) {
+	return l.append(ctx, &mesos_internal_log.Action{
+		Type:     mesos_internal_log.Action_TRUNCATE.Enum(),
+		Truncate: &mesos_internal_log.Action_Truncate{To: proto.Uint64(to)},
+	})
+}
+
+// Recover readies the local replica to take part in the log. A replica that has lost
+// its state, or never had any, learns every action chosen so far from the others
+// before it votes, so as not to go back on promises it made and forgot. If every
+// replica is new, they start the log together. Recover waits until a quorum of
+// replicas can be reached.
+func (l *Log) Recover(ctx context.Context) error {
+	for {
+		status := l.replica.Status()
+		if status == mesos_internal_log.Metadata_VOTING {
+			return nil
+		}
+
+		// A replica that fails to catch up stays RECOVERING, and tries again.
+		err := l.recover(ctx, status, l.recoverResponses(ctx))
+		if err == nil && l.replica.Status() == mesos_internal_log.Metadata_VOTING {
+			return nil
+		}
+		select {
+		case <-time.After(recoverRetry):
+		case <-ctx.Done():
+			if err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// recoverResponses asks every replica, the local one included, for its status.
+func (l *Log) recoverResponses(ctx context.Context) []*mesos_internal_log.RecoverResponse {
+	request := &mesos_internal_log.RecoverRequest{}
+	results := l.gather(ctx, func(ctx context.Context, peer Peer) (interface{}, error) {
+		return peer.Recover(ctx, request)
+	})
+
+	responses := make([]*mesos_internal_log.RecoverResponse, len(results))
+	for i, result := range results {
+		responses[i] = result.(*mesos_internal_log.RecoverResponse)
+	}
+	return responses
+}
+
+// recover takes a step towards voting, given the replicas' statuses.
+func (l *Log) recover(ctx context.Context, status mesos_internal_log.Metadata_Status, responses []*mesos_internal_log.RecoverResponse) error {
+	counts := make(map[mesos_internal_log.Metadata_Status]int)
+	var begin, end uint64
+	for _, response := range responses {
+		counts[response.GetStatus()]++
+		if response.GetStatus() != mesos_internal_log.Metadata_VOTING {
+			continue
+		}
+		if begin == 0 || response.GetBegin() < begin {
+			begin = response.GetBegin()
+		}
+		if response.GetEnd() > end {
+			end = response.GetEnd()
+		}
+	}
+
+	switch {
+	case counts[mesos_internal_log.Metadata_VOTING] >= l.quorum:
+		// The log is running: learn what we missed, and then vote.
+		if err := l.replica.setStatus(mesos_internal_log.Metadata_RECOVERING); err != nil {
+			return err
+		}
+		l.lock.Lock()
+		err := l.elect(ctx, begin, end)
+		l.elected = 0
+		l.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		return l.replica.setStatus(mesos_internal_log.Metadata_VOTING)
+
+	case status == mesos_internal_log.Metadata_EMPTY &&
+		counts[mesos_internal_log.Metadata_EMPTY]+counts[mesos_internal_log.Metadata_STARTING] >= l.quorum:
+		// Nobody has voted yet. Every replica must see the others starting before
+		// any of them votes, or a replica could start after the log has begun
+		// without learning of it.
+		return l.replica.setStatus(mesos_internal_log.Metadata_STARTING)
+
+	case status == mesos_internal_log.Metadata_STARTING &&
+		counts[mesos_internal_log.Metadata_STARTING]+counts[mesos_internal_log.Metadata_VOTING] >= l.quorum:
+		return l.replica.setStatus(mesos_internal_log.Metadata_VOTING)
+	}
+	return nil
+}
+
+// end returns the last position a quorum of voting replicas holds.
+func (l *Log) end(ctx context.Context) (uint64, error) {
+	var end uint64
+	voting := 0
+	for _, response := range l.recoverResponses(ctx) {
+		if response.GetStatus() != mesos_internal_log.Metadata_VOTING {
+			continue
+		}
+		voting++
+		if response.GetEnd() > end {
+			end = response.GetEnd()
+		}
+	}
+	if voting < l.quorum {
+		return 0, errNoQuorum
+	}
+	return end, nil
+}
+
+// CatchUp learns every action chosen up to the last position a quorum of replicas
+// holds, so that reading the local replica up to it is complete. It returns that
+// position. Positions no replica has learned are filled, which demotes the
+// coordinator, so only a log that is about to write should catch up this way.
+func (l *Log) CatchUp(ctx context.Context) (uint64, error) {
+	end, err := l.end(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	begin, _ := l.replica.bounds()
+	if l.elected != 0 {
+		promised, err := l.catchUp(ctx, begin, end, l.elected)
+		if err != nil || promised == 0 {
+			return end, err
+		}
+	}
+	// We were elected only to learn: a log that does not write would otherwise hold
+	// on to an election that others have since won, and fail its next append.
+	err = l.elect(ctx, begin, end)
+	l.elected = 0
+	return end, err
+}
+
+// Learn learns from the other replicas the actions they have learned, up to the last
+// position a quorum of replicas holds, without proposing anything. It returns the
+// position up to which the local replica has learned every action, which falls short
+// of the end while an action chosen there has not been learned by any replica.
+func (l *Log) Learn(ctx context.Context) (uint64, error) {
+	end, err := l.end(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	begin, _ := l.replica.bounds()
+	for position := begin; position <= end; position++ {
+		if l.replica.learned(position) != nil {
+			continue
+		}
+		if local, _ := l.replica.bounds(); position < local {
+			position = local - 1
+			continue
+		}
+		action := l.fetch(ctx, position)
+		if action == nil {
+			return position - 1, nil
+		}
+		if err := l.replica.Learned(ctx, &mesos_internal_log.LearnedMessage{Action: action}); err != nil {
+			return 0, err
+		}
+	}
+	return end, nil
+}
+
+// fetch asks the other replicas for the action at position, and returns it from the
+// first that has learned it, or nil if none has.
+func (l *Log) fetch(ctx context.Context, position uint64) *mesos_internal_log.Action {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	others := l.peers[1:]
+	actions := make(chan *mesos_internal_log.Action, len(others))
+	for _, peer := range others {
+		go func(peer Peer) {
+			ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+			defer cancel()
+			action, err := peer.Read(ctx, position)
+			if err != nil {
+				action = nil
+			}
+			actions <- action
+		}(peer)
+	}
+	for range others {
+		if action := <-actions; action.GetLearned() {
+			return action
+		}
+	}
+	return nil
+}
+
+// Read returns the entries appended between positions from and to, inclusive, that
+// the local replica has learned. It is an error for any position in between not to
+// have been learned, or to have been truncated.
+func (l *Log) Read(from, to uint64) ([]Entry, error) {
+	if begin, _ := l.replica.bounds(); from < begin {
+		return nil, fmt.Errorf("position %d has been truncated; the log begins at %d", from, begin)
+	}
+
+	var entries []Entry
+	for position := from; position <= to; position++ {
+		action := l.replica.learned(position)
+		if action == nil {
+			return nil, fmt.Errorf("position %d has not been learned", position)
+		}
+		if action.GetType() == mesos_internal_log.Action_APPEND {
+			entries = append(entries, Entry{Position: position, Data: action.GetAppend().GetBytes()})
+		}
+	}
+	return entries, nil
+}
+
+// Beginning returns the first position of the log that has not been truncated.
+func (l *Log) Beginning() uint64 {
+	begin, _ := l.replica.bounds()
+	return begin
+}
+
+// Ending returns the last position of the log the local replica has heard of. Reading
+// up to it may need a CatchUp first.
+func (l *Log) Ending() uint64 {
+	_, end := l.replica.bounds()
+	return end
+}
@@ -0,0 +1,562 @@
+package replog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/log.pb"
+)
+
+var errLost = errors.New("message lost")
+
+// lossyPeer drops a share of the requests to a replica, and of its responses.
+type lossyPeer struct {
+	sync.Mutex
+	peer   Peer
+	loss   float64
+	random *rand.Rand
+	// Whether to drop every Learned message.
+	dropLearned bool
+	// Called once, before the next explicit promise is passed on.
+	onExplicitPromise func()
+}
+
+func (p *lossyPeer) target() Peer {
+	p.Lock()
+	defer p.Unlock()
+	return p.peer
+}
+
+func (p *lossyPeer) setTarget(peer Peer) {
+	p.Lock()
+	defer p.Unlock()
+	p.peer = peer
+}
+
+func (p *lossyPeer) setLoss(loss float64) {
+	p.Lock()
+	defer p.Unlock()
+	p.loss = loss
+}
+
+func (p *lossyPeer) lost() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.random.Float64() < p.loss
+}
+
+func (p *lossyPeer) Promise(ctx context.Context, request *mesos_internal_log.PromiseRequest) (*mesos_internal_log.PromiseResponse, error) {
+	if request.Position != nil {
+		p.Lock()
+		hook := p.onExplicitPromise
+		p.onExplicitPromise = nil
+		p.Unlock()
+		if hook != nil {
+			hook()
+		}
+	}
+	if p.lost() {
+		return nil, errLost
+	}
+	response, err := p.target().Promise(ctx, request)
+	if err == nil && p.lost() {
+		return nil, errLost
+	}
+	return response, err
+}
+
+func (p *lossyPeer) Write(ctx context.Context, request *mesos_internal_log.WriteRequest) (*mesos_internal_log.WriteResponse, error) {
+	if p.lost() {
+		return nil, errLost
+	}
+	response, err := p.target().Write(ctx, request)
+	if err == nil && p.lost() {
+		return nil, errLost
+	}
+	return response, err
+}
+
+func (p *lossyPeer) Learned(ctx context.Context, message *mesos_internal_log.LearnedMessage) error {
+	p.Lock()
+	drop := p.dropLearned
+	p.Unlock()
+	if drop || p.lost() {
+		return errLost
+	}
+	return p.target().Learned(ctx, message)
+}
+
+func (p *lossyPeer) Recover(ctx context.Context, request *mesos_internal_log.RecoverRequest) (*mesos_internal_log.RecoverResponse, error) {
+	if p.lost() {
+		return nil, errLost
+	}
+	response, err := p.target().Recover(ctx, request)
+	if err == nil && p.lost() {
+		return nil, errLost
+	}
+	return response, err
+}
+
+func (p *lossyPeer) Read(ctx context.Context, position uint64) (*mesos_internal_log.Action, error) {
+	if p.lost() {
+		return nil, errLost
+	}
+	response, err := p.target().Read(ctx, position)
+	if err == nil && p.lost() {
+		return nil, errLost
+	}
+	return response, err
+}
+
+// cluster is a set of replicas in one process, each with a log that reaches the
+// others through lossy peers.
+type cluster struct {
+	replicas []*Replica
+	logs     []*Log
+	// peers[i][j] is how log i reaches replica j.
+	peers [][]*lossyPeer
+}
+
+func newCluster(t *testing.T, paths []string, loss float64) *cluster {
+	c := &cluster{}
+	for _, path := range paths {
+		replica, err := NewReplica(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.replicas = append(c.replicas, replica)
+	}
+
+	for i, replica := range c.replicas {
+		var peers []Peer
+		c.peers = append(c.peers, make([]*lossyPeer, len(c.replicas)))
+		for j, other := range c.replicas {
+			if j == i {
+				continue
+			}
+			c.peers[i][j] = &lossyPeer{peer: other, loss: loss, random: rand.New(rand.NewSource(int64(i*len(paths) + j)))}
+			peers = append(peers, c.peers[i][j])
+		}
+		c.logs = append(c.logs, New(replica, peers))
+	}
+	return c
+}
+
+// recover recovers the given logs at once, as they wait for each other.
+func (c *cluster) recover(t *testing.T, logs ...int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	errs := make(chan error, len(logs))
+	for _, i := range logs {
+		go func(log *Log) { errs <- log.Recover(ctx) }(c.logs[i])
+	}
+	for range logs {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (c *cluster) close() {
+	for _, replica := range c.replicas {
+		replica.Close()
+	}
+}
+
+// appendAll appends each value, retrying until it is appended, and returns the
+// position of each.
+func appendAll(t *testing.T, log *Log, values []string) []uint64 {
+	positions := make([]uint64, len(values))
+	for i, value := range values {
+		var err error
+		for attempt := 0; attempt < 20; attempt++ {
+			if positions[i], err = log.Append(context.Background(), []byte(value)); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatalf("append %q: %v", value, err)
+		}
+	}
+	return positions
+}
+
+// readAll catches up log and reads all of it.
+func readAll(t *testing.T, log *Log) []Entry {
+	var end uint64
+	var err error
+	for attempt := 0; attempt < 20; attempt++ {
+		if end, err = log.CatchUp(context.Background()); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("catch up: %v", err)
+	}
+
+	entries, err := log.Read(log.Beginning(), end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func values(n int, prefix string) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = fmt.Sprintf("%s-%d", prefix, i)
+	}
+	return values
+}
+
+// checkEntries checks that entries hold the values appended at the given positions,
+// and nothing else but values that failed to append.
+func checkEntries(t *testing.T, name string, entries []Entry, positions []uint64, values []string) {
+	byPosition := make(map[uint64]string)
+	for _, entry := range entries {
+		byPosition[entry.Position] = string(entry.Data)
+	}
+	for i, position := range positions {
+		if got := byPosition[position]; got != values[i] {
+			t.Errorf("%s: position %d holds %q, want %q", name, position, got, values[i])
+		}
+	}
+}
+
+func TestAppendAndRead(t *testing.T) {
+	c := newCluster(t, []string{"", "", ""}, 0)
+	defer c.close()
+	c.recover(t, 0, 1, 2)
+
+	want := values(10, "entry")
+	positions := appendAll(t, c.logs[0], want)
+	for i, position := range positions {
+		if position != uint64(i+1) {
+			t.Errorf("appended %q at position %d, want %d", want[i], position, i+1)
+		}
+	}
+
+	for i, log := range c.logs {
+		entries := readAll(t, log)
+		if len(entries) != len(want) {
+			t.Fatalf("replica %d: got %d entries, want %d", i, len(entries), len(want))
+		}
+		checkEntries(t, fmt.Sprintf("replica %d", i), entries, positions, want)
+	}
+}
+
+func TestMessageLoss(t *testing.T) {
+	c := newCluster(t, []string{"", "", ""}, 0.2)
+	defer c.close()
+	c.recover(t, 0, 1, 2)
+
+	want := values(50, "entry")
+	positions := appendAll(t, c.logs[0], want)
+
+	// Every replica learns the same log, in which every append that succeeded is
+	// where it was reported to be.
+	first := readAll(t, c.logs[0])
+	checkEntries(t, "replica 0", first, positions, want)
+	for i, log := range c.logs[1:] {
+		entries := readAll(t, log)
+		if fmt.Sprint(entries) != fmt.Sprint(first) {
+			t.Errorf("replica %d learned %v, but replica 0 learned %v", i+1, entries, first)
+		}
+	}
+}
+
+func TestCompetingWriters(t *testing.T) {
+	c := newCluster(t, []string{"", "", ""}, 0.1)
+	defer c.close()
+	c.recover(t, 0, 1, 2)
+
+	// Each append by one writer demotes the other, whose next append has it
+	// elected again.
+	var positions []uint64
+	var want []string
+	for i := 0; i < 20; i++ {
+		value := fmt.Sprintf("writer-%d-%d", i%2, i)
+		position, err := c.logs[i%2].Append(context.Background(), []byte(value))
+		if err != nil {
+			continue
+		}
+		positions = append(positions, position)
+		want = append(want, value)
+	}
+	if len(positions) == 0 {
+		t.Fatal("no append succeeded")
+	}
+
+	first := readAll(t, c.logs[2])
+	checkEntries(t, "replica 2", first, positions, want)
+	for i, log := range c.logs[:2] {
+		if entries := readAll(t, log); fmt.Sprint(entries) != fmt.Sprint(first) {
+			t.Errorf("replica %d learned %v, but replica 2 learned %v", i, entries, first)
+		}
+	}
+}
+
+func TestCompetingCoordinators(t *testing.T) {
+	c := newCluster(t, []string{"", "", ""}, 0)
+	defer c.close()
+	c.recover(t, 0, 1, 2)
+
+	// Replicas 1 and 2 accept what log 0 appends, but never learn it, so that log 1
+	// has to fill those positions when it is elected.
+	c.peers[0][1].dropLearned = true
+	c.peers[0][2].dropLearned = true
+	appendAll(t, c.logs[0], []string{"x1", "x2"})
+
+	// Log 1 only reaches replica 2. Just as it starts filling there, log 0 is
+	// demoted and elected again, and appends.
+	c.peers[1][0].setLoss(1)
+	var position uint64
+	c.peers[1][2].onExplicitPromise = func() {
+		position = appendAll(t, c.logs[0], []string{"a"})[0]
+	}
+	if _, err := c.logs[1].Append(context.Background(), []byte("b")); err != nil {
+		t.Log(err)
+	}
+	if position == 0 {
+		t.Fatal("log 0 did not append while log 1 was filling")
+	}
+
+	c.peers[1][0].setLoss(0)
+	first := readAll(t, c.logs[0])
+	checkEntries(t, "replica 0", first, []uint64{position}, []string{"a"})
+	for i, log := range c.logs[1:] {
+		if entries := readAll(t, log); fmt.Sprint(entries) != fmt.Sprint(first) {
+			t.Errorf("replica %d learned %v, but replica 0 learned %v", i+1, entries, first)
+		}
+	}
+}
+
+func TestLearn(t *testing.T) {
+	c := newCluster(t, []string{"", "", ""}, 0)
+	defer c.close()
+	c.recover(t, 0, 1, 2)
+
+	c.peers[0][2].dropLearned = true
+	want := values(10, "entry")
+	positions := appendAll(t, c.logs[0], want[:5])
+	if _, err := c.logs[0].Truncate(context.Background(), positions[2]); err != nil {
+		t.Fatal(err)
+	}
+	positions = append(positions[2:], appendAll(t, c.logs[0], want[5:])...)
+	want = want[2:]
+
+	// Replica 2 learns everything from the others, without demoting log 0.
+	end, err := c.logs[2].Learn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.logs[0].Append(context.Background(), []byte("after")); err != nil {
+		t.Errorf("append after learning: %v", err)
+	}
+	entries, err := c.logs[2].Read(c.logs[2].Beginning(), end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		t.Errorf("got %v, want %d entries", entries, len(want))
+	}
+	checkEntries(t, "replica 2", entries, positions, want)
+}
+
+func TestTruncate(t *testing.T) {
+	c := newCluster(t, []string{"", "", ""}, 0)
+	defer c.close()
+	c.recover(t, 0, 1, 2)
+
+	positions := appendAll(t, c.logs[0], values(10, "entry"))
+	position, err := c.logs[0].Truncate(context.Background(), positions[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.logs[0].Truncate(context.Background(), position+2); err == nil {
+		t.Error("truncated past the end of the log")
+	}
+
+	for i, log := range c.logs {
+		entries := readAll(t, log)
+		if len(entries) != 5 || string(entries[0].Data) != "entry-5" {
+			t.Errorf("replica %d: got %v after truncation, want entry-5 to entry-9", i, entries)
+		}
+		if _, err := log.Read(positions[0], position); err == nil {
+			t.Errorf("replica %d: read truncated entries", i)
+		}
+	}
+}
+
+func TestCompactionFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "replica")
+	s, err := openStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	// The file can not be compacted while something is in the way of its
+	// replacement.
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(filepath.Join(tmp, "in-the-way"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	metadata := func(promised uint64) *mesos_internal_log.Metadata {
+		return &mesos_internal_log.Metadata{
+			Status:   mesos_internal_log.Metadata_VOTING.Enum(),
+			Promised: proto.Uint64(promised),
+		}
+	}
+	for s.records < compactRecords-1 {
+		if err := s.persistMetadata(metadata(uint64(s.records))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.persistMetadata(metadata(compactRecords)); err == nil {
+		t.Fatal("compaction failed silently")
+	}
+	if s.metadata.GetPromised() != compactRecords {
+		t.Errorf("got promise %d, want the record to be applied anyway", s.metadata.GetPromised())
+	}
+
+	os.RemoveAll(tmp)
+	if err := s.persistMetadata(metadata(compactRecords + 1)); err != nil {
+		t.Fatal(err)
+	}
+	if s.records != 1 {
+		t.Errorf("got %d records after compacting, want 1", s.records)
+	}
+	s.close()
+	if s, err = openStorage(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if s.metadata.GetPromised() != compactRecords+1 {
+		t.Errorf("got promise %d after reopening, want %d", s.metadata.GetPromised(), compactRecords+1)
+	}
+}
+
+func TestRecoverLostReplica(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths := []string{filepath.Join(dir, "0"), filepath.Join(dir, "1"), filepath.Join(dir, "2")}
+	c := newCluster(t, paths, 0.1)
+	defer c.close()
+
+	// A lone replica can not start the log on its own.
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	if err := c.logs[2].Recover(ctx); err == nil {
+		t.Error("replica recovered without a quorum")
+	}
+	cancel()
+	c.recover(t, 0, 1, 2)
+
+	want := values(20, "entry")
+	positions := appendAll(t, c.logs[0], want)
+
+	// Replica 2 loses its disk, and comes back empty. It learns what it missed
+	// before it votes again.
+	c.replicas[2].Close()
+	os.Remove(paths[2])
+	replica, err := NewReplica(paths[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := replica.Status(); status != mesos_internal_log.Metadata_EMPTY {
+		t.Fatalf("got status %s for a new replica, want %s", status, mesos_internal_log.Metadata_EMPTY)
+	}
+	c.replicas[2] = replica
+	c.logs[2] = New(replica, []Peer{c.peers[2][0], c.peers[2][1]})
+	for _, peers := range c.peers[:2] {
+		peers[2].setTarget(replica)
+	}
+	c.recover(t, 2)
+
+	entries, err := c.logs[2].Read(1, c.logs[2].Ending())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, "recovered replica", entries, positions, want)
+
+	// With the first replica gone, the other two carry on.
+	c.peers[1][0].setLoss(1)
+	c.peers[2][0].setLoss(1)
+	more := values(5, "more")
+	morePositions := appendAll(t, c.logs[1], more)
+	checkEntries(t, "replica 2", readAll(t, c.logs[2]), morePositions, more)
+
+	// A replica that restarts keeps what it learned.
+	c.replicas[1].Close()
+	if c.replicas[1], err = NewReplica(paths[1]); err != nil {
+		t.Fatal(err)
+	}
+	if status := c.replicas[1].Status(); status != mesos_internal_log.Metadata_VOTING {
+		t.Errorf("got status %s after restart, want %s", status, mesos_internal_log.Metadata_VOTING)
+	}
+	entries, err = New(c.replicas[1], nil).Read(1, morePositions[len(morePositions)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, "restarted replica", entries, append(positions, morePositions...), append(want, more...))
+}
+
+func TestHTTPPeer(t *testing.T) {
+	var replicas []*Replica
+	var servers []*httptest.Server
+	for i := 0; i < 3; i++ {
+		replica, err := NewReplica("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas = append(replicas, replica)
+		servers = append(servers, httptest.NewServer(replica))
+		defer servers[i].Close()
+	}
+
+	var logs []*Log
+	for i, replica := range replicas {
+		var peers []Peer
+		for j, server := range servers {
+			if j != i {
+				peers = append(peers, NewHTTPPeer(server.URL+"/log", nil))
+			}
+		}
+		logs = append(logs, New(replica, peers))
+	}
+	c := &cluster{replicas: replicas, logs: logs}
+	c.recover(t, 0, 1, 2)
+
+	want := values(5, "entry")
+	positions := appendAll(t, logs[1], want)
+	checkEntries(t, "replica 0", readAll(t, logs[0]), positions, want)
+
+	peer := NewHTTPPeer(servers[0].URL+"/log", nil)
+	if action, err := peer.Read(context.Background(), positions[0]); err != nil || string(action.GetAppend().GetBytes()) != want[0] {
+		t.Errorf("read %v, %v at position %d, want %q", action, err, positions[0], want[0])
+	}
+	if action, err := peer.Read(context.Background(), positions[len(positions)-1]+1); err != nil || action != nil {
+		t.Errorf("read %v, %v past the end of the log, want nothing", action, err)
+	}
+}
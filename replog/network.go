@@ -0,0 +1,192 @@
+package replog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/log.pb"
+)
+
+// Peer is how a replica is reached. *Replica is the Peer for a replica in the same
+// process; NewHTTPPeer returns one for a replica served over HTTP.
+type Peer interface {
+	Promise(ctx context.Context, request *mesos_internal_log.PromiseRequest) (*mesos_internal_log.PromiseResponse, error)
+	Write(ctx context.Context, request *mesos_internal_log.WriteRequest) (*mesos_internal_log.WriteResponse, error)
+	Learned(ctx context.Context, message *mesos_internal_log.LearnedMessage) error
+	Recover(ctx context.Context, request *mesos_internal_log.RecoverRequest) (*mesos_internal_log.RecoverResponse, error)
+	Read(ctx context.Context, position uint64) (*mesos_internal_log.Action, error)
+}
+
+// The paths, under the prefix a replica is served at, that it takes each message on.
+const (
+	promisePath = "promise"
+	writePath   = "write"
+	learnedPath = "learned"
+	recoverPath = "recover"
+	readPath    = "read"
+)
+
+// ServeHTTP takes each message as a POST of the encoded message to the path for its
+// type, and replies with the encoded response. A read, which Mesos has no message for,
+// is a POST with the position as a parameter, and is answered with no content if the
+// action has not been learned.
+func (r *Replica) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Add("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var response proto.Message
+	switch path.Base(req.URL.Path) {
+	case promisePath:
+		request := new(mesos_internal_log.PromiseRequest)
+		if err = proto.Unmarshal(data, request); err == nil {
+			response, err = r.Promise(req.Context(), request)
+		}
+	case writePath:
+		request := new(mesos_internal_log.WriteRequest)
+		if err = proto.Unmarshal(data, request); err == nil {
+			response, err = r.Write(req.Context(), request)
+		}
+	case learnedPath:
+		message := new(mesos_internal_log.LearnedMessage)
+		if err = proto.Unmarshal(data, message); err == nil {
+			err = r.Learned(req.Context(), message)
+		}
+	case recoverPath:
+		request := new(mesos_internal_log.RecoverRequest)
+		if err = proto.Unmarshal(data, request); err == nil {
+			response, err = r.Recover(req.Context(), request)
+		}
+	case readPath:
+		var position uint64
+		if position, err = strconv.ParseUint(req.URL.Query().Get("position"), 10, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var action *mesos_internal_log.Action
+		if action, err = r.Read(req.Context(), position); err == nil && action == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		response = action
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	if err == errNotVoting {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if response == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if data, err = proto.Marshal(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(data)
+}
+
+// httpPeer sends messages to a replica served over HTTP.
+type httpPeer struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPPeer returns a Peer for the replica served at url, such as
+// "http://host:port/log". If client is nil, http.DefaultClient is used.
+func NewHTTPPeer(url string, client *http.Client) Peer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpPeer{url: url, client: client}
+}
+
+func (p *httpPeer) call(ctx context.Context, name string, message, response proto.Message) error {
+	var data []byte
+	if message != nil {
+		var err error
+		if data, err = proto.Marshal(message); err != nil {
+			return fmt.Errorf("failed to marshal %+v: %+v", message, err)
+		}
+	}
+
+	url := p.url + "/" + name
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request for %s: %+v", url, err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to send to %s: %+v", url, err)
+	}
+	defer resp.Body.Close()
+	if data, err = ioutil.ReadAll(resp.Body); err != nil {
+		return fmt.Errorf("failed to read response from %s: %+v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("%s returned %s: %s", url, resp.Status, bytes.TrimSpace(data))
+	}
+
+	if response == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := proto.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("failed to unmarshal response from %s: %+v", url, err)
+	}
+	return nil
+}
+
+func (p *httpPeer) Promise(ctx context.Context, request *mesos_internal_log.PromiseRequest) (*mesos_internal_log.PromiseResponse, error) {
+	response := new(mesos_internal_log.PromiseResponse)
+	return response, p.call(ctx, promisePath, request, response)
+}
+
+func (p *httpPeer) Write(ctx context.Context, request *mesos_internal_log.WriteRequest) (*mesos_internal_log.WriteResponse, error) {
+	response := new(mesos_internal_log.WriteResponse)
+	return response, p.call(ctx, writePath, request, response)
+}
+
+func (p *httpPeer) Learned(ctx context.Context, message *mesos_internal_log.LearnedMessage) error {
+	return p.call(ctx, learnedPath, message, nil)
+}
+
+func (p *httpPeer) Recover(ctx context.Context, request *mesos_internal_log.RecoverRequest) (*mesos_internal_log.RecoverResponse, error) {
+	response := new(mesos_internal_log.RecoverResponse)
+	return response, p.call(ctx, recoverPath, request, response)
+}
+
+func (p *httpPeer) Read(ctx context.Context, position uint64) (*mesos_internal_log.Action, error) {
+	response := new(mesos_internal_log.Action)
+	if err := p.call(ctx, fmt.Sprintf("%s?position=%d", readPath, position), nil, response); err != nil {
+		return nil, err
+	}
+	if response.Position == nil {
+		return nil, nil
+	}
+	return response, nil
+}
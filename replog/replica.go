@@ -0,0 +1,267 @@
+package replog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/log.pb"
+)
+
+// errNotVoting is returned for promise and write requests to a replica that has not
+// yet recovered, which must not take part in choosing actions.
+var errNotVoting = errors.New("replica is not voting")
+
+// Replica is one copy of the log. It promises not to accept writes from coordinators
+// with older proposals than the ones it has promised, accepts writes, and records the
+// actions that have been learned to be chosen.
+type Replica struct {
+	sync.Mutex
+	storage *storage
+}
+
+// NewReplica opens the replica stored at path, creating it if it does not exist. If
+// path is empty, the replica is only kept in memory. A new replica must recover, with
+// Log.Recover, before it takes part in the log.
+func NewReplica(path string) (*Replica, error) {
+	storage, err := openStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replica{storage: storage}, nil
+}
+
+func (r *Replica) Status() mesos_internal_log.Metadata_Status {
+	r.Lock()
+	defer r.Unlock()
+	return r.storage.metadata.GetStatus()
+}
+
+func (r *Replica) setStatus(status mesos_internal_log.Metadata_Status) error {
+	r.Lock()
+	defer r.Unlock()
+
+	metadata := r.storage.metadata
+	metadata.Status = status.Enum()
+	return r.storage.persistMetadata(&metadata)
+}
+
+// promised returns the highest proposal the replica has promised.
+func (r *Replica) promised() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.storage.metadata.GetPromised()
+}
+
+// Promise handles a request from a coordinator to be elected. Without a position, the
+// promise covers every position; with one, only that position, as in Mesos. An
+// explicit promise is answered with the action at the position, if there is one, for
+// the coordinator to adopt.
+func (r *Replica) Promise(ctx context.Context, request *mesos_internal_log.PromiseRequest) (*mesos_internal_log.PromiseResponse, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.storage.metadata.GetStatus() != mesos_internal_log.Metadata_VOTING {
+		return nil, errNotVoting
+	}
+	proposal := request.GetProposal()
+
+	if request.Position == nil {
+		if promised := r.storage.metadata.GetPromised(); proposal <= promised {
+			return reject(promised), nil
+		}
+		metadata := r.storage.metadata
+		metadata.Promised = proto.Uint64(proposal)
+		if err := r.storage.persistMetadata(&metadata); err != nil {
+			return nil, err
+		}
+		return &mesos_internal_log.PromiseResponse{
+			Okay:     proto.Bool(true),
+			Proposal: proto.Uint64(proposal),
+			Position: proto.Uint64(r.storage.end),
+		}, nil
+	}
+
+	// An explicit promise only covers its position. The replica-wide promise is
+	// left alone, as it is what a coordinator learned the end of the log under when
+	// it was elected; writes are still held to it.
+	position := request.GetPosition()
+	response := &mesos_internal_log.PromiseResponse{
+		Okay:     proto.Bool(true),
+		Proposal: proto.Uint64(proposal),
+		Position: proto.Uint64(position),
+	}
+
+	// Nothing that was truncated matters any more.
+	if position < r.storage.begin {
+		response.Action = &mesos_internal_log.Action{
+			Position:  proto.Uint64(position),
+			Promised:  proto.Uint64(proposal),
+			Performed: proto.Uint64(proposal),
+			Learned:   proto.Bool(true),
+			Type:      mesos_internal_log.Action_NOP.Enum(),
+			Nop:       &mesos_internal_log.Action_Nop{},
+		}
+		return response, nil
+	}
+
+	action, ok := r.storage.actions[position]
+	if !ok {
+		action = &mesos_internal_log.Action{Position: proto.Uint64(position)}
+	} else if proposal <= action.GetPromised() {
+		return reject(action.GetPromised()), nil
+	} else {
+		response.Action = action
+	}
+
+	promised := proto.Clone(action).(*mesos_internal_log.Action)
+	promised.Promised = proto.Uint64(proposal)
+	if err := r.storage.persistAction(promised); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Write handles a request from a coordinator to accept an action.
+func (r *Replica) Write(ctx context.Context, request *mesos_internal_log.WriteRequest) (*mesos_internal_log.WriteResponse, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.storage.metadata.GetStatus() != mesos_internal_log.Metadata_VOTING {
+		return nil, errNotVoting
+	}
+	proposal, position := request.GetProposal(), request.GetPosition()
+	response := &mesos_internal_log.WriteResponse{
+		Okay:     proto.Bool(true),
+		Proposal: proto.Uint64(proposal),
+		Position: proto.Uint64(position),
+	}
+
+	if promised := r.storage.metadata.GetPromised(); proposal < promised {
+		response.Okay, response.Proposal = proto.Bool(false), proto.Uint64(promised)
+		return response, nil
+	}
+	// A truncated or learned action can not change.
+	if position < r.storage.begin {
+		return response, nil
+	}
+	existing, ok := r.storage.actions[position]
+	if ok && existing.GetLearned() {
+		return response, nil
+	}
+	if ok && proposal < existing.GetPromised() {
+		response.Okay, response.Proposal = proto.Bool(false), proto.Uint64(existing.GetPromised())
+		return response, nil
+	}
+
+	action := &mesos_internal_log.Action{
+		Position:  proto.Uint64(position),
+		Promised:  proto.Uint64(proposal),
+		Performed: proto.Uint64(proposal),
+		Learned:   proto.Bool(request.GetLearned()),
+		Type:      request.Type,
+		Nop:       request.Nop,
+		Append:    request.Append,
+		Truncate:  request.Truncate,
+	}
+	if err := r.storage.persistAction(action); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Learned records that an action has been chosen.
+func (r *Replica) Learned(ctx context.Context, message *mesos_internal_log.LearnedMessage) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.learn(message.Action)
+}
+
+// learn records an action as chosen. It must be called with the lock held.
+func (r *Replica) learn(action *mesos_internal_log.Action) error {
+	if action == nil {
+		return fmt.Errorf("learned message without an action")
+	}
+	if action.GetPosition() < r.storage.begin {
+		return nil
+	}
+	if existing, ok := r.storage.actions[action.GetPosition()]; ok && existing.GetLearned() {
+		return nil
+	}
+
+	learned := proto.Clone(action).(*mesos_internal_log.Action)
+	learned.Learned = proto.Bool(true)
+	return r.storage.persistAction(learned)
+}
+
+// Recover reports the replica's status, and the positions it holds.
+func (r *Replica) Recover(ctx context.Context, request *mesos_internal_log.RecoverRequest) (*mesos_internal_log.RecoverResponse, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	return &mesos_internal_log.RecoverResponse{
+		Status: r.storage.metadata.Status,
+		Begin:  proto.Uint64(r.storage.begin),
+		End:    proto.Uint64(r.storage.end),
+	}, nil
+}
+
+// Read returns the action at position if the replica has learned it, or nil, for a
+// replica that is catching up without proposing. A position that has been truncated
+// reads as a learned NOP, as for an explicit promise.
+func (r *Replica) Read(ctx context.Context, position uint64) (*mesos_internal_log.Action, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if position < r.storage.begin {
+		return &mesos_internal_log.Action{
+			Position:  proto.Uint64(position),
+			Promised:  proto.Uint64(0),
+			Performed: proto.Uint64(0),
+			Learned:   proto.Bool(true),
+			Type:      mesos_internal_log.Action_NOP.Enum(),
+			Nop:       &mesos_internal_log.Action_Nop{},
+		}, nil
+	}
+	action, ok := r.storage.actions[position]
+	if !ok || !action.GetLearned() {
+		return nil, nil
+	}
+	return action, nil
+}
+
+// learned returns the action at position if it has been learned, or nil.
+func (r *Replica) learned(position uint64) *mesos_internal_log.Action {
+	r.Lock()
+	defer r.Unlock()
+
+	action, ok := r.storage.actions[position]
+	if !ok || !action.GetLearned() {
+		return nil
+	}
+	return action
+}
+
+// bounds returns the first position that has not been truncated, and the last
+// position the replica holds an action for.
+func (r *Replica) bounds() (begin, end uint64) {
+	r.Lock()
+	defer r.Unlock()
+	return r.storage.begin, r.storage.end
+}
+
+func (r *Replica) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.storage.close()
+}
+
+func reject(promised uint64) *mesos_internal_log.PromiseResponse {
+	return &mesos_internal_log.PromiseResponse{
+		Okay:     proto.Bool(false),
+		Proposal: proto.Uint64(promised),
+	}
+}
@@ -0,0 +1,287 @@
+package replog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/log.pb"
+)
+
+// The file is rewritten once it holds at least this many records, and more than
+// twice as many as there are live ones.
+const compactRecords = 1000
+
+// storage is what a replica keeps: its metadata, and an action for each position it
+// has been asked about. Unless it is only kept in memory, every change is appended to
+// a file as a Record, and synced before it is reported done.
+//
+// Learning a TRUNCATE action drops the actions before the position it truncates to.
+type storage struct {
+	path string
+	file *os.File
+	// The length of the file, and the number of records in it.
+	size    int64
+	records int
+
+	metadata mesos_internal_log.Metadata
+	actions  map[uint64]*mesos_internal_log.Action
+	// The first position that has not been truncated, and the last position there is
+	// an action for.
+	begin, end uint64
+}
+
+// openStorage opens the storage kept at path, creating it if it does not exist. If
+// path is empty, the storage is only kept in memory.
+func openStorage(path string) (*storage, error) {
+	s := &storage{
+		path:    path,
+		actions: make(map[uint64]*mesos_internal_log.Action),
+		begin:   1,
+	}
+	s.metadata.Status = mesos_internal_log.Metadata_EMPTY.Enum()
+	s.metadata.Promised = proto.Uint64(0)
+	if path == "" {
+		return s, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %+v", path, err)
+	}
+	s.file = file
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay applies the records in the file. An incomplete last record was being
+// written when we stopped, and so was never reported done; it is dropped.
+func (s *storage) replay() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %+v", s.path, err)
+	}
+
+	reader := bufio.NewReader(s.file)
+	for {
+		length, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			break
+		}
+		if err == nil && length > uint64(info.Size()-s.size) {
+			err = io.ErrUnexpectedEOF
+		}
+		var data []byte
+		if err == nil {
+			data = make([]byte, length)
+			_, err = io.ReadFull(reader, data)
+		}
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			if err := s.file.Truncate(s.size); err != nil {
+				return fmt.Errorf("failed to truncate %s: %+v", s.path, err)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %+v", s.path, err)
+		}
+
+		record := new(mesos_internal_log.Record)
+		if err := proto.Unmarshal(data, record); err != nil {
+			return fmt.Errorf("corrupt record in %s at offset %d: %+v", s.path, s.size, err)
+		}
+		if err := s.apply(record); err != nil {
+			return fmt.Errorf("corrupt record in %s at offset %d: %+v", s.path, s.size, err)
+		}
+
+		var buffer [binary.MaxVarintLen64]byte
+		s.size += int64(binary.PutUvarint(buffer[:], length)) + int64(length)
+		s.records++
+	}
+
+	if _, err := s.file.Seek(s.size, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in %s: %+v", s.path, err)
+	}
+	return nil
+}
+
+func (s *storage) apply(record *mesos_internal_log.Record) error {
+	switch record.GetType() {
+	case mesos_internal_log.Record_METADATA:
+		if record.Metadata == nil {
+			return fmt.Errorf("%s record without metadata", record.GetType())
+		}
+		s.metadata = *record.Metadata
+
+	case mesos_internal_log.Record_ACTION:
+		action := record.Action
+		if action == nil {
+			return fmt.Errorf("%s record without an action", record.GetType())
+		}
+		if action.GetPosition() < s.begin {
+			return nil
+		}
+		s.actions[action.GetPosition()] = action
+		if action.GetPosition() > s.end {
+			s.end = action.GetPosition()
+		}
+		if action.GetLearned() && action.GetType() == mesos_internal_log.Action_TRUNCATE {
+			s.truncate(action.GetTruncate().GetTo())
+		}
+
+	default:
+		return fmt.Errorf("unknown record type %s", record.GetType())
+	}
+	return nil
+}
+
+// truncate drops the actions before the given position.
+func (s *storage) truncate(to uint64) {
+	if to <= s.begin {
+		return
+	}
+	if len(s.actions) < int(to-s.begin) {
+		for position := range s.actions {
+			if position < to {
+				delete(s.actions, position)
+			}
+		}
+	} else {
+		for position := s.begin; position < to; position++ {
+			delete(s.actions, position)
+		}
+	}
+	s.begin = to
+}
+
+func (s *storage) persistMetadata(metadata *mesos_internal_log.Metadata) error {
+	return s.persist(&mesos_internal_log.Record{
+		Type:     mesos_internal_log.Record_METADATA.Enum(),
+		Metadata: metadata,
+	})
+}
+
+func (s *storage) persistAction(action *mesos_internal_log.Action) error {
+	return s.persist(&mesos_internal_log.Record{
+		Type:   mesos_internal_log.Record_ACTION.Enum(),
+		Action: action,
+	})
+}
+
+// persist appends a record to the file and syncs it, then applies it. If the file
+// then fails to be compacted, the record has still been persisted and applied.
+func (s *storage) persist(record *mesos_internal_log.Record) error {
+	record = proto.Clone(record).(*mesos_internal_log.Record)
+	if s.file == nil {
+		return s.apply(record)
+	}
+
+	data, err := proto.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record %+v: %+v", record, err)
+	}
+	buffer := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(data))
+	buffer = append(buffer[:binary.PutUvarint(buffer, uint64(len(data)))], data...)
+
+	if _, err := s.file.Write(buffer); err != nil {
+		// Do not leave part of the record for the next one to be appended to.
+		s.file.Truncate(s.size)
+		s.file.Seek(s.size, io.SeekStart)
+		return fmt.Errorf("failed to write to %s: %+v", s.path, err)
+	}
+	s.size += int64(len(buffer))
+	s.records++
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %+v", s.path, err)
+	}
+
+	if err := s.apply(record); err != nil {
+		return err
+	}
+
+	if live := len(s.actions) + 1; s.records >= compactRecords && s.records > 2*live {
+		// The record is safe in the file, which is left to grow until the next
+		// attempt if this one fails, but the failure is not hidden from the caller.
+		if err := s.compact(); err != nil {
+			return fmt.Errorf("persisted a record, but failed to compact %s: %+v", s.path, err)
+		}
+	}
+	return nil
+}
+
+// compact replaces the file with one holding only the metadata and live actions.
+func (s *storage) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	records := []*mesos_internal_log.Record{{
+		Type:     mesos_internal_log.Record_METADATA.Enum(),
+		Metadata: &s.metadata,
+	}}
+	positions := make([]uint64, 0, len(s.actions))
+	for position := range s.actions {
+		positions = append(positions, position)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+	for _, position := range positions {
+		records = append(records, &mesos_internal_log.Record{
+			Type:   mesos_internal_log.Record_ACTION.Enum(),
+			Action: s.actions[position],
+		})
+	}
+
+	writer := bufio.NewWriter(file)
+	var size int64
+	buffer := make([]byte, binary.MaxVarintLen64)
+	for _, record := range records {
+		data, err := proto.Marshal(record)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		n := binary.PutUvarint(buffer, uint64(len(data)))
+		writer.Write(buffer[:n])
+		writer.Write(data)
+		size += int64(n + len(data))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		file.Close()
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	s.file.Close()
+	s.file, s.size, s.records = file, size, len(records)
+	return nil
+}
+
+func (s *storage) close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
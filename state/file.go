@@ -0,0 +1,305 @@
+package state
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"code.google.com/p/go-uuid/uuid"
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/mesos"
+	"github.com/twitter/gozer/proto/state.pb"
+)
+
+// The log is rewritten once it holds at least this many operations, and more than
+// twice as many as there are entries.
+const compactOperations = 1000
+
+// FileStorage keeps entries in a local file, as a log of operations: a SNAPSHOT of
+// each entry stored, and an EXPUNGE of each one removed. Every operation is written
+// after its length, and synced before it is reported done. The log is replayed when
+// it is opened, and rewritten once most of it is out of date.
+type FileStorage struct {
+	sync.RWMutex
+	path string
+	// Failures that do not fail an operation are logged to it.
+	log *mesos.Log
+
+	file *os.File
+	// The length of the log, and the number of operations in it.
+	size       int64
+	operations int
+
+	entries map[string]*mesos_internal_state.Entry
+}
+
+// OpenFileStorage opens the log at path, creating it if it does not exist. Failures
+// that do not fail an operation, such as to compact the log, are logged to log.
+func OpenFileStorage(path string, log *mesos.Log) (*FileStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %+v", path, err)
+	}
+
+	s := &FileStorage{
+		path:    path,
+		log:     log,
+		file:    file,
+		entries: make(map[string]*mesos_internal_state.Entry),
+	}
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay applies the operations in the log. An incomplete last operation was being
+// written when we stopped, and so was never reported done; it is dropped.
+func (s *FileStorage) replay() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %+v", s.path, err)
+	}
+
+	reader := bufio.NewReader(s.file)
+	for {
+		length, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			break
+		}
+		if err == nil && length > uint64(info.Size()-s.size) {
+			err = io.ErrUnexpectedEOF
+		}
+		var data []byte
+		if err == nil {
+			data = make([]byte, length)
+			_, err = io.ReadFull(reader, data)
+		}
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			if err := s.file.Truncate(s.size); err != nil {
+				return fmt.Errorf("failed to truncate %s: %+v", s.path, err)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %+v", s.path, err)
+		}
+
+		operation := new(mesos_internal_state.Operation)
+		if err := proto.Unmarshal(data, operation); err != nil {
+			return fmt.Errorf("corrupt operation in %s at offset %d: %+v", s.path, s.size, err)
+		}
+		if err := s.apply(operation); err != nil {
+			return fmt.Errorf("corrupt operation in %s at offset %d: %+v", s.path, s.size, err)
+		}
+
+		s.size += int64(uvarintSize(length)) + int64(length)
+		s.operations++
+	}
+
+	if _, err := s.file.Seek(s.size, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in %s: %+v", s.path, err)
+	}
+	return nil
+}
+
+func (s *FileStorage) apply(operation *mesos_internal_state.Operation) error {
+	switch operation.GetType() {
+	case mesos_internal_state.Operation_SNAPSHOT:
+		entry := operation.GetSnapshot().GetEntry()
+		if entry == nil {
+			return fmt.Errorf("%s operation without an entry", operation.GetType())
+		}
+		s.entries[entry.GetName()] = entry
+	case mesos_internal_state.Operation_EXPUNGE:
+		if operation.GetExpunge() == nil {
+			return fmt.Errorf("%s operation without a name", operation.GetType())
+		}
+		delete(s.entries, operation.GetExpunge().GetName())
+	default:
+		return fmt.Errorf("unknown operation %s", operation.GetType())
+	}
+	return nil
+}
+
+// write appends an operation to the log and syncs it, then applies it. It must be
+// called with the lock held.
+func (s *FileStorage) write(operation *mesos_internal_state.Operation) error {
+	data, err := proto.Marshal(operation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal operation %+v: %+v", operation, err)
+	}
+	record := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(data))
+	record = append(record[:binary.PutUvarint(record, uint64(len(data)))], data...)
+
+	if _, err := s.file.Write(record); err != nil {
+		s.rollback()
+		return fmt.Errorf("failed to write to %s: %+v", s.path, err)
+	}
+	s.size += int64(len(record))
+	s.operations++
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %+v", s.path, err)
+	}
+
+	if err := s.apply(operation); err != nil {
+		return err
+	}
+
+	if s.operations >= compactOperations && s.operations > 2*len(s.entries) {
+		// Compacting only saves space, so the operation is done either way.
+		if err := s.compact(); err != nil {
+			s.log.Warn.Printf("Failed to compact %s, which holds %d operations for %d entries: %+v",
+				s.path, s.operations, len(s.entries), err)
+		}
+	}
+	return nil
+}
+
+// rollback cuts off whatever part of a failed write reached the log, which replay
+// would otherwise take for an operation that follows it. It must be called with the
+// lock held.
+func (s *FileStorage) rollback() {
+	if err := s.file.Truncate(s.size); err != nil {
+		s.log.Error.Printf("Failed to cut %s back to %d bytes: %+v", s.path, s.size, err)
+	}
+	if _, err := s.file.Seek(s.size, io.SeekStart); err != nil {
+		s.log.Error.Printf("Failed to seek in %s: %+v", s.path, err)
+	}
+}
+
+// compact rewrites the log as a SNAPSHOT of each entry, in a new file that replaces
+// the log once it is synced. It must be called with the lock held.
+func (s *FileStorage) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %+v", tmp, err)
+	}
+	replaced := false
+	defer func() {
+		if !replaced {
+			file.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	writer := bufio.NewWriter(file)
+	var size int64
+	buffer := make([]byte, binary.MaxVarintLen64)
+	for _, name := range s.names() {
+		data, err := proto.Marshal(snapshotOperation(s.entries[name]))
+		if err != nil {
+			return fmt.Errorf("failed to marshal entry %q: %+v", name, err)
+		}
+		n := binary.PutUvarint(buffer, uint64(len(data)))
+		writer.Write(buffer[:n])
+		writer.Write(data)
+		size += int64(n + len(data))
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write %s: %+v", tmp, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %+v", tmp, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace %s: %+v", s.path, err)
+	}
+	replaced = true
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	s.file.Close()
+	s.file, s.size, s.operations = file, size, len(s.entries)
+	return nil
+}
+
+// names returns the names of the entries, in order. It must be called with the lock
+// held.
+func (s *FileStorage) names() []string {
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *FileStorage) Get(name string) (*mesos_internal_state.Entry, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return nil, nil
+	}
+	return proto.Clone(entry).(*mesos_internal_state.Entry), nil
+}
+
+func (s *FileStorage) Set(entry *mesos_internal_state.Entry, version uuid.UUID) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if stored, ok := s.entries[entry.GetName()]; ok && !sameVersion(stored, version) {
+		return false, nil
+	}
+	entry = proto.Clone(entry).(*mesos_internal_state.Entry)
+	if err := s.write(snapshotOperation(entry)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *FileStorage) Expunge(entry *mesos_internal_state.Entry) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	stored, ok := s.entries[entry.GetName()]
+	if !ok || !sameVersion(stored, entry.Uuid) {
+		return false, nil
+	}
+	expunge := mesos_internal_state.Operation_EXPUNGE
+	if err := s.write(&mesos_internal_state.Operation{
+		Type:    &expunge,
+		Expunge: &mesos_internal_state.Operation_Expunge{Name: entry.Name},
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *FileStorage) Names() ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.names(), nil
+}
+
+func (s *FileStorage) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.file.Close()
+}
+
+func snapshotOperation(entry *mesos_internal_state.Entry) *mesos_internal_state.Operation {
+	snapshot := mesos_internal_state.Operation_SNAPSHOT
+	return &mesos_internal_state.Operation{
+		Type:     &snapshot,
+		Snapshot: &mesos_internal_state.Operation_Snapshot{Entry: entry},
+	}
+}
+
+// uvarintSize returns the number of bytes x takes as a uvarint.
+func uvarintSize(x uint64) int {
+	var buffer [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buffer[:], x)
+}
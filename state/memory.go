@@ -0,0 +1,68 @@
+package state
+
+import (
+	"sort"
+	"sync"
+
+	"code.google.com/p/go-uuid/uuid"
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/state.pb"
+)
+
+// MemoryStorage keeps entries in memory, for tests and for state that need not
+// outlive the process.
+type MemoryStorage struct {
+	sync.RWMutex
+	entries map[string]*mesos_internal_state.Entry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{entries: make(map[string]*mesos_internal_state.Entry)}
+}
+
+func (s *MemoryStorage) Get(name string) (*mesos_internal_state.Entry, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return nil, nil
+	}
+	return proto.Clone(entry).(*mesos_internal_state.Entry), nil
+}
+
+func (s *MemoryStorage) Set(entry *mesos_internal_state.Entry, version uuid.UUID) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if stored, ok := s.entries[entry.GetName()]; ok && !sameVersion(stored, version) {
+		return false, nil
+	}
+	s.entries[entry.GetName()] = proto.Clone(entry).(*mesos_internal_state.Entry)
+	return true, nil
+}
+
+func (s *MemoryStorage) Expunge(entry *mesos_internal_state.Entry) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	stored, ok := s.entries[entry.GetName()]
+	if !ok || !sameVersion(stored, entry.Uuid) {
+		return false, nil
+	}
+	delete(s.entries, entry.GetName())
+	return true, nil
+}
+
+func (s *MemoryStorage) Names() ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+
+	"code.google.com/p/go-uuid/uuid"
+	"code.google.com/p/goprotobuf/proto"
+
+	"github.com/twitter/gozer/proto/state.pb"
+)
+
+// ErrVersionMismatch is returned when storing or expunging a variable that has been
+// changed or expunged since it was fetched.
+var ErrVersionMismatch = errors.New("variable has changed since it was fetched")
+
+// Storage is where a State keeps its entries. Implementations must be safe for
+// concurrent use.
+type Storage interface {
+	// Get returns the entry with the given name, or nil if there is none.
+	Get(name string) (*mesos_internal_state.Entry, error)
+	// Set stores entry if the stored entry of the same name, if any, has the given
+	// version as its uuid. It reports whether it stored it.
+	Set(entry *mesos_internal_state.Entry, version uuid.UUID) (bool, error)
+	// Expunge removes the stored entry of the same name if it has the same uuid as
+	// entry. It reports whether it removed it.
+	Expunge(entry *mesos_internal_state.Entry) (bool, error)
+	Names() ([]string, error)
+}
+
+// Variable is a named value, at the version it was fetched or stored at.
+type Variable struct {
+	entry *mesos_internal_state.Entry
+}
+
+func (v *Variable) Name() string {
+	return v.entry.GetName()
+}
+
+func (v *Variable) Value() []byte {
+	return v.entry.GetValue()
+}
+
+// Mutate returns a copy of the variable with a new value. The copy still has the
+// version of v, so storing it fails if v has been changed since it was fetched.
+func (v *Variable) Mutate(value []byte) *Variable {
+	entry := proto.Clone(v.entry).(*mesos_internal_state.Entry)
+	entry.Value = append([]byte{}, value...)
+	return &Variable{entry: entry}
+}
+
+// State is the Mesos state abstraction: a set of named variables, each of which is
+// changed by compare-and-swap on the version it was fetched at.
+type State struct {
+	storage Storage
+}
+
+func New(storage Storage) *State {
+	return &State{storage: storage}
+}
+
+// Fetch returns the variable with the given name. A variable that has not been
+// stored has an empty value.
+func (s *State) Fetch(name string) (*Variable, error) {
+	entry, err := s.storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		entry = &mesos_internal_state.Entry{
+			Name:  proto.String(name),
+			Uuid:  uuid.NewRandom(),
+			Value: []byte{},
+		}
+	}
+	return &Variable{entry: entry}, nil
+}
+
+// Store stores v, and returns it at its new version. It returns ErrVersionMismatch if
+// the variable has been changed since v was fetched.
+func (s *State) Store(v *Variable) (*Variable, error) {
+	entry := proto.Clone(v.entry).(*mesos_internal_state.Entry)
+	entry.Uuid = uuid.NewRandom()
+
+	ok, err := s.storage.Set(entry, v.entry.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrVersionMismatch
+	}
+	return &Variable{entry: entry}, nil
+}
+
+// Expunge removes v. It returns ErrVersionMismatch if the variable has been changed
+// or expunged since v was fetched.
+func (s *State) Expunge(v *Variable) error {
+	ok, err := s.storage.Expunge(v.entry)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// Names returns the names of the stored variables.
+func (s *State) Names() ([]string, error) {
+	return s.storage.Names()
+}
+
+// sameVersion reports whether a stored entry is at the given version.
+func sameVersion(stored *mesos_internal_state.Entry, version uuid.UUID) bool {
+	return bytes.Equal(stored.Uuid, version)
+}
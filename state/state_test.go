@@ -0,0 +1,254 @@
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/twitter/gozer/mesos"
+)
+
+var testLog = mesos.NewLog(mesos.LogConfig{})
+
+// testState runs the State semantics against a storage.
+func testState(t *testing.T, storage Storage) {
+	s := New(storage)
+
+	v, err := s.Fetch("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name() != "a" || len(v.Value()) != 0 {
+		t.Errorf("got new variable %q = %q, want an empty one named %q", v.Name(), v.Value(), "a")
+	}
+
+	stored, err := s.Store(v.Mutate([]byte("1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.Value()) != "1" {
+		t.Errorf("stored value %q, want %q", stored.Value(), "1")
+	}
+
+	// A variable fetched before the store is out of date.
+	if _, err := s.Store(v.Mutate([]byte("2"))); err != ErrVersionMismatch {
+		t.Errorf("store of stale variable: got %v, want %v", err, ErrVersionMismatch)
+	}
+
+	fetched, err := s.Fetch("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fetched.Value()) != "1" {
+		t.Errorf("fetched value %q, want %q", fetched.Value(), "1")
+	}
+	if stored, err = s.Store(fetched.Mutate([]byte("2"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Store(mustFetch(t, s, "b").Mutate([]byte("b"))); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := s.Names(); err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("got names %v (%v), want [a b]", names, err)
+	}
+
+	if err := s.Expunge(fetched); err != ErrVersionMismatch {
+		t.Errorf("expunge of stale variable: got %v, want %v", err, ErrVersionMismatch)
+	}
+	if err := s.Expunge(stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Expunge(stored); err != ErrVersionMismatch {
+		t.Errorf("second expunge: got %v, want %v", err, ErrVersionMismatch)
+	}
+	if v := mustFetch(t, s, "a"); len(v.Value()) != 0 {
+		t.Errorf("expunged variable has value %q", v.Value())
+	}
+	if names, _ := s.Names(); !reflect.DeepEqual(names, []string{"b"}) {
+		t.Errorf("got names %v after expunge, want [b]", names)
+	}
+}
+
+func mustFetch(t *testing.T, s *State, name string) *Variable {
+	v, err := s.Fetch(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testState(t, NewMemoryStorage())
+}
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	storage, err := OpenFileStorage(path, &testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testState(t, storage)
+	storage.Close()
+
+	// The variables outlive the storage.
+	storage, err = OpenFileStorage(path, &testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	s := New(storage)
+	if names, _ := s.Names(); !reflect.DeepEqual(names, []string{"b"}) {
+		t.Errorf("got names %v after reopening, want [b]", names)
+	}
+	if v := mustFetch(t, s, "b"); string(v.Value()) != "b" {
+		t.Errorf("got value %q after reopening, want %q", v.Value(), "b")
+	}
+}
+
+func TestFileStorageTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	storage, err := OpenFileStorage(path, &testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(storage)
+	s.Store(mustFetch(t, s, "a").Mutate([]byte("a")))
+	s.Store(mustFetch(t, s, "b").Mutate([]byte("b")))
+	storage.Close()
+
+	// Lose the end of the last operation.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = OpenFileStorage(path, &testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = New(storage)
+	if names, _ := s.Names(); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("got names %v, want [a]", names)
+	}
+	s.Store(mustFetch(t, s, "c").Mutate([]byte("c")))
+	storage.Close()
+
+	storage, err = OpenFileStorage(path, &testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if names, _ := New(storage).Names(); !reflect.DeepEqual(names, []string{"a", "c"}) {
+		t.Errorf("got names %v, want [a c]", names)
+	}
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	storage, err := OpenFileStorage(path, &testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(storage)
+	v := mustFetch(t, s, "counter")
+	for i := 0; i < compactOperations; i++ {
+		if v, err = s.Store(v.Mutate([]byte(strconv.Itoa(i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if storage.operations != 1 {
+		t.Errorf("got %d operations after compaction, want 1", storage.operations)
+	}
+	storage.Close()
+
+	storage, err = OpenFileStorage(path, &testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	want := strconv.Itoa(compactOperations - 1)
+	if got := mustFetch(t, New(storage), "counter").Value(); string(got) != want {
+		t.Errorf("got value %q after compaction, want %q", got, want)
+	}
+}
+
+func TestFileStorageCompactionFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	// Compacting can not create its new file, so the log keeps growing.
+	if err := os.MkdirAll(filepath.Join(path+".tmp", "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var warnings bytes.Buffer
+	log := mesos.NewLog(mesos.LogConfig{Warn: &warnings})
+	storage, err := OpenFileStorage(path, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(storage)
+	v := mustFetch(t, s, "counter")
+	for i := 0; i < compactOperations+1; i++ {
+		if v, err = s.Store(v.Mutate([]byte(strconv.Itoa(i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if storage.operations != compactOperations+1 {
+		t.Errorf("got %d operations, want %d", storage.operations, compactOperations+1)
+	}
+	if !strings.Contains(warnings.String(), "Failed to compact") {
+		t.Errorf("got warnings %q, want the failure to compact", warnings.String())
+	}
+
+	// Once it can, it does.
+	if err := os.RemoveAll(path + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Store(v.Mutate([]byte("last"))); err != nil {
+		t.Fatal(err)
+	}
+	if storage.operations != 1 {
+		t.Errorf("got %d operations after compaction, want 1", storage.operations)
+	}
+	storage.Close()
+
+	storage, err = OpenFileStorage(path, &testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if got := mustFetch(t, New(storage), "counter").Value(); string(got) != "last" {
+		t.Errorf("got value %q after compaction, want %q", got, "last")
+	}
+}